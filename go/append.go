@@ -0,0 +1,375 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	sigCentralDir   = 0x02014b50
+	sigEOCD         = 0x06054b50
+	sigEOCD64       = 0x06064b50
+	sigEOCD64Locate = 0x07064b50
+	eocdLen         = 22
+	eocd64Len       = 56
+	eocd64LocLen    = 20
+)
+
+// zipDirectory is what -append needs to know about the existing output:
+// where the old central directory starts, its raw bytes, and the comment.
+type zipDirectory struct {
+	offset  int64
+	entries uint64
+	raw     []byte
+	comment []byte
+	zip64   bool // had a Zip64 end record
+}
+
+// readZipDirectory locates the end record within [start, size) of r.
+func readZipDirectory(f io.ReaderAt, start, size int64) (*zipDirectory, error) {
+	tailLen := int64(eocdLen + 0xFFFF + eocd64Len + eocd64LocLen)
+	if tailLen > size-start { tailLen = size - start }
+	tail := make([]byte, tailLen)
+	if _, err := f.ReadAt(tail, size-tailLen); err != nil { return nil, err }
+
+	pos := -1
+	for i := len(tail) - eocdLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != sigEOCD { continue }
+		if i+eocdLen+int(binary.LittleEndian.Uint16(tail[i+20:])) == len(tail) { pos = i; break }
+	}
+	if pos < 0 { return nil, errors.New("không tìm thấy end-of-central-directory") }
+	e := tail[pos:]
+	d := &zipDirectory{
+		entries: uint64(binary.LittleEndian.Uint16(e[10:])),
+		offset:  int64(binary.LittleEndian.Uint32(e[16:])),
+		comment: append([]byte(nil), e[eocdLen:]...),
+	}
+	cdSize := int64(binary.LittleEndian.Uint32(e[12:]))
+	if loc := pos - eocd64LocLen; loc >= 0 && binary.LittleEndian.Uint32(tail[loc:]) == sigEOCD64Locate {
+		off := int64(binary.LittleEndian.Uint64(tail[loc+8:]))
+		rec := make([]byte, eocd64Len)
+		if _, err := f.ReadAt(rec, off); err != nil { return nil, err }
+		if binary.LittleEndian.Uint32(rec) != sigEOCD64 { return nil, errors.New("zip64 end-of-central-directory hỏng") }
+		d.zip64 = true
+		d.entries = binary.LittleEndian.Uint64(rec[32:])
+		cdSize = int64(binary.LittleEndian.Uint64(rec[40:]))
+		d.offset = int64(binary.LittleEndian.Uint64(rec[48:]))
+	}
+	if d.offset < 0 || cdSize < 0 || d.offset+cdSize > size { return nil, errors.New("central directory nằm ngoài file") }
+	if cdSize > maxBufferBytes { return nil, fmt.Errorf("central directory %s, vượt giới hạn %s của bản build này", humanBytes(uint64(cdSize)), humanBytes(maxBufferBytes)) }
+	d.raw = make([]byte, cdSize)
+	if _, err := f.ReadAt(d.raw, d.offset); err != nil { return nil, err }
+	return d, nil
+}
+
+// captureWriter forwards to w until capture is switched on, after
+// which zip.Writer's tail (last data descriptor, its central directory and
+// end record) lands in buf for re-assembly. pos is the absolute file offset.
+type captureWriter struct {
+	w       io.Writer
+	pos     int64
+	capture bool
+	buf     bytes.Buffer
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.capture { return c.buf.Write(p) }
+	n, err := c.w.Write(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+// ReadAt exposes the captured tail at its absolute offset.
+func (c *captureWriter) ReadAt(p []byte, off int64) (int, error) {
+	b := c.buf.Bytes()
+	if off < c.pos || off-c.pos > int64(len(b)) { return 0, io.EOF }
+	n := copy(p, b[off-c.pos:])
+	if n < len(p) { return n, io.EOF }
+	return n, nil
+}
+
+// zipAppendArchive writes new entries where the old central directory began,
+// then emits old directory + new directory + a fresh end record.
+type zipAppendArchive struct {
+	f        *os.File
+	cw       *captureWriter
+	zw       *zip.Writer
+	dir      *zipDirectory
+	opt      options
+	meter    *packMeter
+	rollback bool // drop the last (half-written) entry on close
+}
+
+func openAppendArchive(f *os.File, opt options, m *packMeter) (*zipAppendArchive, error) {
+	info, err := f.Stat()
+	if err != nil { return nil, err }
+	dir, err := readZipDirectory(f, 0, info.Size())
+	if err != nil { return nil, err }
+	if _, err := f.Seek(dir.offset, io.SeekStart); err != nil { return nil, err }
+	if opt.comment != "" { dir.comment = []byte(opt.comment) }
+	a := &zipAppendArchive{f: f, dir: dir, opt: opt, meter: m}
+	a.start(limitWriter(f, opt.writeLimit), dir.offset)
+	return a, nil
+}
+
+func (a *zipAppendArchive) start(w io.Writer, offset int64) {
+	a.cw = &captureWriter{w: w, pos: offset}
+	a.zw = zip.NewWriter(a.cw)
+	a.zw.SetOffset(offset)
+	registerCompressors(a.zw, a.opt, a.meter)
+}
+
+func (a *zipAppendArchive) create(hdr *zip.FileHeader) (io.Writer, error) {
+	return a.zw.CreateHeader(hdr)
+}
+
+// cut drops the entry being written: its local header and data are already
+// in the file, so the file is truncated where the header began, the records
+// written so far join the old directory and a new zip.Writer goes on from
+// there.
+func (a *zipAppendArchive) cut() error {
+	tail, err := a.finishDirectory()
+	if err != nil { return err }
+	raw, start, err := dropLastRecord(tail.raw)
+	if err != nil { return err }
+	if err := a.f.Truncate(start); err != nil { return err }
+	if _, err := a.f.Seek(start, io.SeekStart); err != nil { return err }
+	a.dir.raw = append(a.dir.raw[:len(a.dir.raw):len(a.dir.raw)], raw...)
+	a.dir.entries += tail.entries - 1
+	a.dir.zip64 = a.dir.zip64 || tail.zip64
+	a.start(a.cw.w, start)
+	return nil
+}
+
+// finishDirectory closes zw with everything after the entry data captured,
+// and parses the directory it wrote.
+func (a *zipAppendArchive) finishDirectory() (*zipDirectory, error) {
+	zw := a.zw
+	a.zw = nil
+	if err := zw.Flush(); err != nil { return nil, err }
+	a.cw.capture = true
+	if err := zw.Close(); err != nil { return nil, err }
+	tail, err := readZipDirectory(a.cw, a.cw.pos, a.cw.pos+int64(a.cw.buf.Len()))
+	if err != nil { return nil, fmt.Errorf("central directory mới không hợp lệ: %v", err) }
+	return tail, nil
+}
+
+func (a *zipAppendArchive) close() error {
+	if a.zw == nil { return nil }
+	if a.rollback {
+		a.rollback = false
+		if err := a.cut(); err != nil { return err }
+	}
+	tail, err := a.finishDirectory()
+	if err != nil { return err }
+	cdStart := tail.offset
+	if _, err := a.f.Write(a.cw.buf.Bytes()[:tail.offset-a.cw.pos]); err != nil { return err }
+	if _, err := a.f.Write(a.dir.raw); err != nil { return err }
+	if _, err := a.f.Write(tail.raw); err != nil { return err }
+	entries := a.dir.entries + tail.entries
+	cdSize := uint64(len(a.dir.raw) + len(tail.raw))
+	// Like zip.Writer, keep the Zip64 end record whenever either directory
+	// needed one, even if the totals would fit the classic record again.
+	end := buildEOCD(entries, cdSize, uint64(cdStart), a.dir.comment, a.dir.zip64 || tail.zip64)
+	if _, err := a.f.Write(end); err != nil { return err }
+	return a.f.Truncate(cdStart + int64(cdSize) + int64(len(end)))
+}
+
+// dropLastRecord removes the final record of a central directory and returns
+// the local header offset of the entry it described.
+func dropLastRecord(raw []byte) ([]byte, int64, error) {
+	le := binary.LittleEndian
+	last := -1
+	for i := 0; i < len(raw); {
+		if i+46 > len(raw) || le.Uint32(raw[i:]) != sigCentralDir { return nil, 0, errors.New("central directory mới không hợp lệ") }
+		last = i
+		i += 46 + int(le.Uint16(raw[i+28:])) + int(le.Uint16(raw[i+30:])) + int(le.Uint16(raw[i+32:]))
+	}
+	if last < 0 { return nil, 0, errors.New("central directory mới rỗng") }
+	r := raw[last:]
+	off := uint64(le.Uint32(r[42:]))
+	if off == 0xFFFFFFFF {
+		// Zip64 extra: the 64-bit fields follow in order, present only for
+		// the header fields that are saturated.
+		n, m := int(le.Uint16(r[28:])), int(le.Uint16(r[30:]))
+		extra := r[46+n : 46+n+m]
+		for len(extra) >= 4 {
+			id, size := le.Uint16(extra), int(le.Uint16(extra[2:]))
+			if 4+size > len(extra) { break }
+			if id == 0x0001 {
+				f := extra[4 : 4+size]
+				if le.Uint32(r[24:]) == 0xFFFFFFFF && len(f) >= 8 { f = f[8:] }
+				if le.Uint32(r[20:]) == 0xFFFFFFFF && len(f) >= 8 { f = f[8:] }
+				if len(f) < 8 { return nil, 0, errors.New("zip64 extra thiếu offset") }
+				off = le.Uint64(f)
+				break
+			}
+			extra = extra[4+size:]
+		}
+	}
+	return raw[:last], int64(off), nil
+}
+
+func buildEOCD(entries, cdSize, cdOffset uint64, comment []byte, zip64 bool) []byte {
+	var out []byte
+	le := binary.LittleEndian
+	if zip64 || entries >= 0xFFFF || cdSize >= 0xFFFFFFFF || cdOffset >= 0xFFFFFFFF {
+		rec := make([]byte, eocd64Len+eocd64LocLen)
+		le.PutUint32(rec[0:], sigEOCD64)
+		le.PutUint64(rec[4:], eocd64Len-12)
+		le.PutUint16(rec[12:], 45)
+		le.PutUint16(rec[14:], 45)
+		le.PutUint64(rec[24:], entries)
+		le.PutUint64(rec[32:], entries)
+		le.PutUint64(rec[40:], cdSize)
+		le.PutUint64(rec[48:], cdOffset)
+		loc := rec[eocd64Len:]
+		le.PutUint32(loc[0:], sigEOCD64Locate)
+		le.PutUint64(loc[8:], cdOffset+cdSize)
+		le.PutUint32(loc[16:], 1)
+		out = rec
+		if entries > 0xFFFF { entries = 0xFFFF }
+		if cdSize > 0xFFFFFFFF { cdSize = 0xFFFFFFFF }
+		cdOffset = 0xFFFFFFFF
+	}
+	e := make([]byte, eocdLen)
+	le.PutUint32(e[0:], sigEOCD)
+	le.PutUint16(e[8:], uint16(entries))
+	le.PutUint16(e[10:], uint16(entries))
+	le.PutUint32(e[12:], uint32(cdSize))
+	le.PutUint32(e[16:], uint32(cdOffset))
+	le.PutUint16(e[20:], uint16(len(comment)))
+	return append(append(out, e...), comment...)
+}
+
+var dupNameRe = regexp.MustCompile(`^(.*)__dup([0-9]+)(\.[^.]*)?$`)
+
+type existingKey struct {
+	name string
+	crc  uint32
+	size uint64
+}
+
+// existingArchive indexes the entries already in the output for -append,
+// and the sources earlier -append runs recorded as merged into it.
+type existingArchive struct {
+	keys    map[existingKey]bool
+	sources map[string]sourceStamp
+}
+
+func loadExistingArchive(opt options, path string, dedup map[string]int) (*existingArchive, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil { return nil, fmt.Errorf("-append: không đọc được %s: %v", path, err) }
+	defer zr.Close()
+	ex := &existingArchive{keys: map[existingKey]bool{}, sources: loadAppendRecord(opt, path)}
+	for _, f := range zr.File {
+		base, next := f.Name, 1
+		if m := dupNameRe.FindStringSubmatch(f.Name); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			base, next = m[1]+m[3], n+1
+		}
+		if dedup[base] < next { dedup[base] = next }
+		ex.keys[existingKey{base, f.CRC32, f.UncompressedSize64}] = true
+	}
+	return ex, nil
+}
+
+// contains reports whether a source is already in the output: recorded by
+// an earlier run with the same size and mtime, or every entry present (same
+// mapped path modulo __dupN, CRC32 and size). The record is what covers a
+// source with an entry that could not be copied (encrypted, corrupt): that
+// entry is missing or cut short in the output and never matches.
+// Only zip sources carry a CRC32, so unrecorded tarballs are always merged.
+func (ex *existingArchive) contains(opt options, zipName string, stamp sourceStamp, entries []*sourceEntry) bool {
+	if st, ok := ex.sources[zipName]; ok && stamp.size > 0 && st.size == stamp.size && st.mod.Equal(stamp.mod) { return true }
+	n := 0
+	for _, e := range entries {
+		if e.IsDir || !wantEntry(opt, e) { continue }
+		if !ex.has(opt, zipName, e) { return false }
+		n++
+	}
+	return n > 0
+}
+
+// has reports whether the file entry e of zipName is in the output already
+// (same mapped path modulo __dupN, CRC32 and size). A source that is not
+// skipped as a whole is merged without those entries, so resuming an -append
+// cut off half way through a source does not add its first entries again as
+// __dupN copies. ex may be nil (no -append output yet).
+func (ex *existingArchive) has(opt options, zipName string, e *sourceEntry) bool {
+	return ex != nil && e.zf != nil && ex.keys[existingKey{targetBase(opt, zipName, e.Name), e.CRC32, e.Size}]
+}
+
+// The append record lists the sources merged into an -append output, in
+// <outdir>/.mergezip-append/<output>.json. It holds the output's size when
+// written: an output replaced or grown by something else since makes it
+// stale, and the entry check alone decides again.
+
+const appendDir = ".mergezip-append"
+
+type appendRecord struct {
+	Output  string         `json:"output"`
+	Size    int64          `json:"size"`
+	Sources []appendSource `json:"sources"`
+}
+
+type appendSource struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+func appendRecordPath(opt options, outPath string) string {
+	return filepath.Join(opt.outDir, appendDir, filepath.Base(outPath)+".json")
+}
+
+func loadAppendRecord(opt options, outPath string) map[string]sourceStamp {
+	info, err := os.Stat(outPath)
+	if err != nil { return nil }
+	b, err := os.ReadFile(appendRecordPath(opt, outPath))
+	if err != nil { return nil }
+	var rec appendRecord
+	if json.Unmarshal(b, &rec) != nil || rec.Size != info.Size() { return nil }
+	m := map[string]sourceStamp{}
+	for _, s := range rec.Sources { m[s.Name] = sourceStamp{size: s.Size, mod: s.Modified} }
+	return m
+}
+
+// saveAppendRecord adds the sources marked in merged to those recorded
+// before (ex, nil for a new output) and writes the record for the output as
+// it is now.
+func saveAppendRecord(opt options, outPath string, ex *existingArchive, names []string, stamps []sourceStamp, merged []bool) {
+	info, err := os.Stat(outPath)
+	if err != nil { return } // removed (cancelled first run)
+	all := map[string]sourceStamp{}
+	if ex != nil {
+		for n, st := range ex.sources { all[n] = st }
+	}
+	for i, n := range names {
+		if merged[i] && stamps[i].size > 0 { all[n] = stamps[i] }
+	}
+	rec := appendRecord{Output: filepath.Base(outPath), Size: info.Size(), Sources: []appendSource{}}
+	for n, st := range all { rec.Sources = append(rec.Sources, appendSource{n, st.size, st.mod}) }
+	sort.Slice(rec.Sources, func(i, j int) bool { return rec.Sources[i].Name < rec.Sources[j].Name })
+	path := appendRecordPath(opt, outPath)
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		var b []byte
+		if b, err = json.MarshalIndent(rec, "", "  "); err == nil {
+			tmp := path + ".tmp"
+			if err = os.WriteFile(tmp, b, 0o644); err == nil { err = os.Rename(tmp, path) }
+		}
+	}
+	if err != nil { errorf("WARNING: -append: không ghi được %s (%v); lần sau nguồn có entry lỗi sẽ được gộp lại", path, err) }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func entryCount(t *testing.T, path string) int {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	if err != nil { t.Fatal(err) }
+	defer zr.Close()
+	return len(zr.File)
+}
+
+func runMerge(t *testing.T, args ...string) {
+	t.Helper()
+	opt, err := parseFlags(append([]string{"-progress", "none", "-q"}, args...))
+	if err != nil { t.Fatal(err) }
+	if _, err := mergeZIP(context.Background(), opt, newWarnLog(opt)); err != nil { t.Fatal(err) }
+}
+
+// A source with an entry that cannot be copied never matches the output
+// entry by entry; the append record must still keep it from being merged
+// again.
+func TestAppendIdempotent(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	if err := genFixtures(fixtureSpec{dir: in, zips: 2, entries: 20, size: 4 << 10, dup: 0.2, broken: 1, encrypted: 1, weird: 1, seed: 1}); err != nil { t.Fatal(err) }
+	args := []string{"-input", in, "-outdir", out, "-out", "m", "-append"}
+	runMerge(t, args...)
+	first := entryCount(t, filepath.Join(out, "m.zip"))
+	runMerge(t, args...)
+	if got := entryCount(t, filepath.Join(out, "m.zip")); got != first { t.Fatalf("second -append: %d entries, want %d", got, first) }
+
+	// Without the record only the entry check is left, which fails on the
+	// encrypted entries: the case the record is there for.
+	opt, err := parseFlags(args)
+	if err != nil { t.Fatal(err) }
+	names, paths, err := collectSources(opt)
+	if err != nil { t.Fatal(err) }
+	ex, err := loadExistingArchive(opt, filepath.Join(out, "m.zip"), map[string]int{})
+	if err != nil { t.Fatal(err) }
+	for i, name := range names {
+		entries, err := listSource(opt, paths[i])
+		if err != nil { continue } // the truncated fixture
+		st, _ := stampSource(paths[i])
+		if !ex.contains(opt, name, st, entries) { t.Errorf("%s not recognised as merged", name) }
+		if ex.contains(opt, name, sourceStamp{}, entries) { t.Errorf("%s matched entry by entry despite its encrypted entry", name) }
+	}
+}
+
+// A run cut off half way through a source leaves that source unrecorded;
+// the next -append copies the rest of it without adding the entries already
+// there again as __dupN.
+func TestAppendResumesSource(t *testing.T) {
+	base, in, out := t.TempDir(), t.TempDir(), t.TempDir()
+	writeTestZip(t, filepath.Join(base, "base.zip"), 3, 0)
+	// The encrypted entry comes after the ten others; -max-warnings 0 stops
+	// the run there.
+	if err := genFixtures(fixtureSpec{dir: in, zips: 1, entries: 10, size: 512, encrypted: 1, seed: 2}); err != nil { t.Fatal(err) }
+	runMerge(t, "-input", base, "-outdir", out, "-out", "m", "-append")
+
+	args := []string{"-input", in, "-outdir", out, "-out", "m", "-append"}
+	opt, err := parseFlags(append([]string{"-progress", "none", "-q", "-max-warnings", "0"}, args...))
+	if err != nil { t.Fatal(err) }
+	if _, err := mergeZIP(context.Background(), opt, newWarnLog(opt)); err == nil { t.Fatal("-max-warnings 0 did not stop the run") }
+	path := filepath.Join(out, "m.zip")
+	if n := entryCount(t, path); n != 3+10 { t.Fatalf("after the cut-off run: %d entries, want %d", n, 3+10) }
+
+	runMerge(t, args...)
+	zr, err := zip.OpenReader(path)
+	if err != nil { t.Fatal(err) }
+	defer zr.Close()
+	if len(zr.File) != 3+10 { t.Errorf("after resuming: %d entries, want %d", len(zr.File), 3+10) }
+	for _, f := range zr.File {
+		if strings.Contains(f.Name, "__dup") { t.Errorf("resumed source added %s", f.Name) }
+	}
+}
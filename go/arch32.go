@@ -0,0 +1,9 @@
+//go:build 386 || arm || mips || mipsle
+
+package main
+
+// maxBufferBytes caps -chunk × -read-ahead and any buffer sized from archive
+// metadata. A 32-bit process has 2-3 GB of address space in total; file
+// offsets stay 64-bit, so sources and outputs far beyond 4 GB still work as
+// long as nothing tries to hold them in memory.
+const maxBufferBytes = 256 << 20
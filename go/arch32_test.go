@@ -0,0 +1,46 @@
+//go:build 386 || arm || mips || mipsle
+
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// Run with GOARCH=386 (see arch32.go).
+
+func TestArch32BufferLimit(t *testing.T) {
+	if _, err := parseFlags([]string{"-input", t.TempDir(), "-chunk", "128", "-read-ahead", "4"}); err == nil || !strings.Contains(err.Error(), "-read-ahead") {
+		t.Fatalf("-chunk 128 × -read-ahead 4 on 32-bit: err = %v, want the buffer limit", err)
+	}
+	if _, err := parseFlags([]string{"-input", t.TempDir(), "-chunk", "64", "-read-ahead", "4"}); err != nil { t.Fatal(err) }
+
+	// A central directory larger than the limit is refused before anything
+	// is allocated for it.
+	f := newSparseFile()
+	cdSize := uint64(maxBufferBytes + 1)
+	f.set(int64(6<<30+cdSize), buildEOCD(1, cdSize, 6<<30, nil, true))
+	if _, err := readZipDirectory(f, 0, f.size); err == nil || !strings.Contains(err.Error(), "vượt giới hạn") {
+		t.Fatalf("central directory of %d bytes: err = %v, want the buffer limit", cdSize, err)
+	}
+}
+
+// File offsets stay 64-bit on 32-bit builds: a central directory past
+// 4 GiB is found through the Zip64 end record.
+func TestArch32DirectoryBeyond4GiB(t *testing.T) {
+	const cdOff = 5<<30 + 123
+	cd := make([]byte, 46+len("a.txt"))
+	binary.LittleEndian.PutUint32(cd, sigCentralDir)
+	binary.LittleEndian.PutUint16(cd[28:], uint16(len("a.txt")))
+	copy(cd[46:], "a.txt")
+	f := newSparseFile()
+	f.set(cdOff, cd)
+	f.set(cdOff+int64(len(cd)), buildEOCD(1, uint64(len(cd)), cdOff, []byte("c"), false))
+
+	d, err := readZipDirectory(f, 0, f.size)
+	if err != nil { t.Fatal(err) }
+	if d.offset != cdOff || d.entries != 1 || !d.zip64 || string(d.raw) != string(cd) || string(d.comment) != "c" {
+		t.Fatalf("got offset %d, %d entries, zip64 %v, comment %q", d.offset, d.entries, d.zip64, d.comment)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !(386 || arm || mips || mipsle)
+
+package main
+
+// maxBufferBytes caps -chunk × -read-ahead and any buffer sized from archive
+// metadata (see arch32.go).
+const maxBufferBytes = 4 << 30
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Output budgets: some consumers (old Java zip readers, upload gateways)
+// reject archives above a fixed entry count or size. -on-overflow decides
+// what happens to the entry that would cross the line.
+const (
+	overflowFail     = "fail"
+	overflowRollover = "rollover"
+	overflowTruncate = "truncate-report"
+)
+
+func validateBudget(opt *options) error {
+	if opt.maxOutput != "" {
+		n, err := parseSize(opt.maxOutput)
+		if err != nil || n <= 0 { return fmt.Errorf("-max-output-bytes không hợp lệ: %q", opt.maxOutput) }
+		opt.maxOutBytes = n
+	}
+	if opt.maxEntries < 0 { opt.maxEntries = 0 }
+	switch opt.onOverflow {
+	case overflowFail, overflowRollover, overflowTruncate:
+	default:
+		return fmt.Errorf("-on-overflow không hợp lệ: %q (fail|rollover|truncate-report)", opt.onOverflow)
+	}
+	if !opt.budgeted() { return nil }
+	if opt.appendOut { return errors.New("-max-entries/-max-output-bytes không dùng chung được với -append") }
+	if opt.toStdout && opt.onOverflow == overflowRollover { return errors.New("-on-overflow rollover cần file output, không dùng được với -out -") }
+	return nil
+}
+
+func (opt options) budgeted() bool { return opt.maxEntries > 0 || opt.maxOutBytes > 0 }
+
+// rolloverPath names the n-th output of a run: merged.zip, merged-2.zip, ...
+func rolloverPath(opt options, n int) string {
+	return filepath.Join(opt.outDir, fmt.Sprintf("%s-%d%s", opt.outBase, n, outputExt(opt.format)))
+}
+
+// entryCost is a worst-case estimate (as if the data did not compress at
+// all) of the bytes an entry adds to the output, split into what is written
+// now and what the zip central directory will add at close.
+func entryCost(opt options, size uint64, name string) (data, dir int64) {
+	n := int64(len(name))
+	if opt.format == "zip" {
+		data = int64(size) + (int64(size)/16384+1)*5 // stored deflate blocks
+		return data + 64 + n, 96 + n                   // local header + ext time + zip64 descriptor; central record + extras
+	}
+	return (int64(size)+511)/512*512 + 1536 + (n+511)/512*512, 0 // header + PAX record
+}
+
+// archiveEndCost covers what close writes: end records, tar end blocks,
+// whatever a streaming compressor still holds in its buffers and the
+// -block-size padding.
+func archiveEndCost(opt options) int64 {
+	switch opt.format {
+	case "zip": return 98 + int64(len(opt.comment)) + opt.blockBytes
+	case "tgz": return 1024 + 1<<20
+	case "tzst": return 1024 + 8<<20
+	}
+	return 1024 + opt.blockBytes
+}
+
+// overBudget names the limit that adding this entry would break, or "".
+func (o *outputFile) overBudget(opt options, size uint64, name, comment string) string {
+	extra, signing := 0, int64(0) // room for -sign-key's two entries
+	if o.signer != nil { extra, signing = 2, o.signer.cost(opt, name) }
+	if opt.maxEntries > 0 && o.entries+1+extra > opt.maxEntries { return fmt.Sprintf("-max-entries %d", opt.maxEntries) }
+	if opt.maxOutBytes > 0 {
+		data, dir := entryCost(opt, size, name)
+		dir += int64(len(comment))
+		if o.count.n+o.dirBytes+data+dir+signing+archiveEndCost(opt) > opt.maxOutBytes { return "-max-output-bytes " + opt.maxOutput }
+	}
+	return ""
+}
+
+func (o *outputFile) added(opt options, name, comment string) {
+	o.entries++
+	_, dir := entryCost(opt, 0, name)
+	o.dirBytes += dir + int64(len(comment))
+}
+
+// overflowReport lists the entries -on-overflow truncate-report left out.
+type overflowReport struct {
+	path  string
+	f     *os.File
+	w     *csv.Writer
+	count int
+}
+
+func overflowReportPath(opt options) string {
+	return filepath.Join(opt.outDir, opt.outBase+".overflow.csv")
+}
+
+func (r *overflowReport) add(source, path, target string, size uint64, limit string) error {
+	if r.f == nil {
+		f, err := os.Create(r.path)
+		if err != nil { return err }
+		r.f, r.w = f, csv.NewWriter(f)
+		if err := r.w.Write([]string{"source", "path", "target", "size", "limit"}); err != nil { return err }
+	}
+	r.count++
+	return r.w.Write([]string{source, path, target, strconv.FormatUint(size, 10), limit})
+}
+
+func (r *overflowReport) close() error {
+	if r.f == nil { return nil }
+	r.w.Flush()
+	err := r.w.Error()
+	if cErr := r.f.Close(); err == nil { err = cErr }
+	r.f = nil
+	return err
+}
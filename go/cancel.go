@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+var errCanceled = errors.New("đã huỷ (SIGINT/SIGTERM)")
+
+// caught holds the signal that cancelled the run; stopNoted is set once the
+// main goroutine has said so.
+var (
+	caught    atomic.Value
+	stopNoted atomic.Bool
+)
+
+// cancelOnSignal returns a context that is cancelled by the first SIGINT or
+// SIGTERM. The merge stops at the next safe point (between copy chunks of an
+// entry / split chunks) and cleans up; a second signal kills the process
+// immediately. The signal goroutine only cancels: the console belongs to the
+// main goroutine (progress line, see endProgress), which reports the stop
+// through canceled.
+func cancelOnSignal() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs) // restore default handling for the second signal
+		caught.Store(sig)
+		cancel()
+	}()
+	return ctx, func() { signal.Stop(sigs); cancel() }
+}
+
+// canceled is returned by the main goroutine where it finds the run's
+// context done; the first call prints which signal stopped it.
+func canceled() error {
+	if sig, ok := caught.Load().(os.Signal); ok && stopNoted.CompareAndSwap(false, true) {
+		errorf("%v: đang dừng và dọn dẹp... (lặp lại để thoát ngay)", sig)
+	}
+	return errCanceled
+}
+
+// removePartial deletes incomplete outputs left behind by a cancelled run.
+func removePartial(paths ...string) {
+	removed := 0
+	for _, p := range paths {
+		if err := os.Remove(p); err == nil {
+			removed++
+		} else if !os.IsNotExist(err) {
+			errorf("WARNING: không xoá được %s: %v", p, err)
+		}
+	}
+	switch {
+	case removed == 1 && len(paths) == 1:
+		errorf("Removed incomplete: %s", paths[0])
+	case removed > 0:
+		errorf("Removed %d incomplete files (%s ...)", removed, paths[0])
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// The signal goroutine must leave the console alone while the main goroutine
+// is printing progress; run with -race.
+func TestCancelOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" { t.Skip("no SIGINT to self") }
+	defer func(w io.Writer) { logOut = w }(logOut)
+	logOut = io.Discard
+	ctx, stop := cancelOnSignal()
+	defer stop()
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil { t.Fatal(err) }
+	if err := p.Signal(os.Interrupt); err != nil { t.Fatal(err) }
+	for ctx.Err() == nil {
+		progressOpen = true // what printZipProgress does between chunks
+		endProgress()
+	}
+	if err := canceled(); err != errCanceled { t.Fatalf("canceled() = %v", err) }
+	if !stopNoted.Load() { t.Fatal("stop not reported") }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// -on-changed policies for sources whose size/mtime moved after the pre-scan
+// (typically an uploader still writing the file).
+const (
+	changedSkip = "skip"
+	changedWait = "wait"
+	changedFail = "fail"
+)
+
+const (
+	changedPoll    = 5 * time.Second  // how often a changing source is re-checked
+	changedMaxWait = 30 * time.Minute // give up waiting and skip after this
+)
+
+type sourceStamp struct {
+	size int64
+	mod  time.Time
+}
+
+func stampSource(path string) (sourceStamp, error) {
+	if isRemote(path) { return stampRemote(path) }
+	info, err := os.Stat(path)
+	if err != nil { return sourceStamp{}, err }
+	return sourceStamp{size: info.Size(), mod: info.ModTime()}, nil
+}
+
+// filterMinAge drops sources modified less than -min-age ago; they are most
+// likely still being uploaded and will be picked up by a later run.
+func filterMinAge(opt options, names, paths []string) ([]string, []string) {
+	if opt.minAge <= 0 { return names, paths }
+	var keepNames, keepPaths []string
+	for i, name := range names {
+		st, err := stampSource(paths[i])
+		if err == nil && !st.mod.IsZero() {
+			if age := time.Since(st.mod); age < opt.minAge {
+				logf("Bỏ qua %s: mới sửa %s trước (< -min-age %s)", name, age.Round(time.Second), opt.minAge)
+				continue
+			}
+		}
+		keepNames = append(keepNames, name)
+		keepPaths = append(keepPaths, paths[i])
+	}
+	return keepNames, keepPaths
+}
+
+// settleSource compares a source with its pre-scan stamp just before it is
+// merged. It returns whether to merge it and, with -on-changed wait, the new
+// stamp once the file has stopped changing (callers must re-measure it).
+func settleSource(ctx context.Context, opt options, wl *warnLog, path, name string, before sourceStamp) (bool, sourceStamp, error) {
+	now, err := stampSource(path)
+	if err != nil { return false, before, wl.warn(warnChanged, "%s biến mất sau pre-scan: %v", name, err) }
+	if now == before { return true, before, nil }
+
+	switch opt.onChanged {
+	case changedFail:
+		return false, before, fmt.Errorf("%s đã thay đổi sau pre-scan (%d -> %d bytes)", name, before.size, now.size)
+	case changedWait:
+		logf("%s đang thay đổi (%d -> %d bytes), chờ ổn định...", name, before.size, now.size)
+		deadline := time.Now().Add(changedMaxWait)
+		for time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done(): return false, before, canceled()
+			case <-time.After(changedPoll):
+			}
+			next, err := stampSource(path)
+			if err != nil { return false, before, wl.warn(warnChanged, "%s biến mất khi đang chờ: %v", name, err) }
+			if next == now { return true, now, nil }
+			now = next
+		}
+		return false, before, wl.warn(warnChanged, "%s vẫn thay đổi sau %s, bỏ qua", name, changedMaxWait)
+	}
+	return false, before, wl.warn(warnChanged, "%s đã thay đổi sau pre-scan (%d -> %d bytes), bỏ qua", name, before.size, now.size)
+}
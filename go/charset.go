@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Zip names without the UTF-8 flag (general purpose bit 11) are in whatever
+// code page the writing tool used: CP437 for old Windows/DOS tools (the zip
+// spec's default), the ANSI code page of a Vietnamese, Japanese or Chinese
+// Windows, or plain UTF-8 from tools that never set the flag. -src-encoding
+// auto guesses per source archive from all of its flagless names and
+// transcodes them to UTF-8; a guess that is not convincing keeps the bytes
+// and says so. CP437 and CP1258 are mapped here, Shift-JIS (CP932) and GBK
+// (CP936) through the iconv command.
+
+const (
+	encAuto = "auto"
+	encRaw  = "raw" // keep the bytes (and let readers guess)
+
+	charsetMinScore = 0.6 // below this, auto detection keeps the raw names
+)
+
+// nameEncodings are the -src-encoding choices besides auto/raw, in the
+// order auto prefers them on a tie.
+var nameEncodings = []string{"cp437", "cp1258", "shift-jis", "gbk"}
+
+var iconvNames = map[string]string{"shift-jis": "CP932", "gbk": "GBK"}
+
+// charsetDone remembers per source path what was decided, so the prescan
+// and the merge pass agree and the report is printed once.
+var charsetDone = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+func validateSrcEncoding(opt *options) error {
+	enc := strings.ToLower(opt.srcEncoding)
+	switch {
+	case enc == encAuto, enc == encRaw:
+	case indexOf(nameEncodings, enc) < 0:
+		return fmt.Errorf("-src-encoding không hợp lệ: %q (auto|raw|%s)", opt.srcEncoding, strings.Join(nameEncodings, "|"))
+	case iconvNames[enc] != "":
+		if _, err := exec.LookPath("iconv"); err != nil { return fmt.Errorf("-src-encoding %s cần lệnh iconv trong PATH", enc) }
+	}
+	opt.srcEncoding = enc
+	return nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, l := range list {
+		if l == s { return i }
+	}
+	return -1
+}
+
+// decodeNames rewrites the flagless non-ASCII names of zr to UTF-8 as
+// -src-encoding (srcEnc) says. Failures only cost the transcoding, never
+// the source.
+func decodeNames(srcEnc, path string, zr *zip.Reader) {
+	if srcEnc == encRaw { return }
+	var files []*zip.File
+	var names []string
+	for _, f := range zr.File {
+		if f.Flags&0x800 != 0 || !hasHighByte(f.Name) { continue }
+		files = append(files, f)
+		names = append(names, f.Name)
+	}
+	if len(files) == 0 { return }
+
+	charsetDone.Lock()
+	defer charsetDone.Unlock()
+	enc, seen := charsetDone.m[path]
+	if !seen {
+		enc = decideCharset(srcEnc, filepath.Base(path), names)
+		charsetDone.m[path] = enc
+	}
+	if enc == "" { return }
+	decoded, err := transcodeNames(enc, names)
+	if err != nil {
+		charsetDone.m[path] = ""
+		errorf("WARNING: %s: không chuyển được tên entry từ %s sang UTF-8 (%v), giữ nguyên byte gốc", filepath.Base(path), enc, err)
+		return
+	}
+	for i, f := range files {
+		f.Name, f.NonUTF8 = decoded[i], false
+	}
+}
+
+// decideCharset picks the encoding for one archive and reports it; ""
+// means keep the names as they are.
+func decideCharset(srcEnc, name string, names []string) string {
+	if srcEnc != encAuto {
+		logEvent("charset", fmt.Sprintf("%s: %d tên entry không có cờ UTF-8, đọc theo -src-encoding %s", name, len(names), srcEnc),
+			"source", name, "names", len(names), "encoding", srcEnc)
+		return srcEnc
+	}
+	allUTF8 := true
+	for _, n := range names { allUTF8 = allUTF8 && utf8.ValidString(n) }
+	if allUTF8 { return "" } // UTF-8 written by a tool that does not set the flag
+
+	best, score := "", 0.0
+	for _, enc := range nameEncodings {
+		if s := charsetScore(enc, names); s > score { best, score = enc, s }
+	}
+	if score < charsetMinScore || (iconvNames[best] != "" && !haveIconv()) {
+		why := fmt.Sprintf("đoán %s với độ tin cậy %.2f", best, score)
+		if score >= charsetMinScore { why = fmt.Sprintf("có vẻ là %s nhưng thiếu lệnh iconv", best) }
+		logf("WARNING: %s: %d tên entry không có cờ UTF-8, %s; giữ nguyên byte gốc (chỉ định bằng -src-encoding)", name, len(names), why)
+		record(slog.LevelWarn, "charset", "source", name, "names", len(names), "guess", best, "score", score, "transcoded", false)
+		return ""
+	}
+	logf("%s: %d tên entry không có cờ UTF-8, nhận dạng %s (độ tin cậy %.2f), chuyển sang UTF-8", name, len(names), best, score)
+	record(slog.LevelInfo, "charset", "source", name, "names", len(names), "encoding", best, "score", score, "transcoded", true)
+	return best
+}
+
+func haveIconv() bool {
+	_, err := exec.LookPath("iconv")
+	return err == nil
+}
+
+func hasHighByte(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 { return true }
+	}
+	return false
+}
+
+// charsetScore rates how plausible names are as file names in enc: the
+// average weight of their non-ASCII characters (1 = typical letter or
+// ideograph, 0 = box drawing, control, undefined). A multi-byte encoding
+// the bytes do not even parse as scores 0.
+func charsetScore(enc string, names []string) float64 {
+	var sum float64
+	var chars int
+	for _, n := range names {
+		var s float64
+		var c int
+		var ok bool
+		switch enc {
+		case "shift-jis": s, c, ok = scoreDoubleByte(n, sjisWeight)
+		case "gbk": s, c, ok = scoreDoubleByte(n, gbkWeight)
+		default: s, c, ok = scoreSingleByte(n, singleByteTables[enc])
+		}
+		if !ok { return 0 }
+		sum, chars = sum+s, chars+c
+	}
+	if chars == 0 { return 0 }
+	return sum / float64(chars)
+}
+
+func scoreSingleByte(s string, table *[128]rune) (float64, int, bool) {
+	var sum float64
+	var chars int
+	prev := rune(0)
+	for i := 0; i < len(s); i++ {
+		r := rune(s[i])
+		if r >= 0x80 {
+			r = table[r-0x80]
+			chars++
+			switch {
+			case unicode.Is(unicode.Mn, r) && unicode.Is(unicode.Latin, prev): sum += 1 // CP1258 tone mark after a vowel
+			case unicode.Is(unicode.Latin, r) && unicode.IsLetter(r): sum += 0.9
+			}
+		}
+		prev = r
+	}
+	return sum, chars, true
+}
+
+// scoreDoubleByte walks a lead/trail byte encoding; weight returns the
+// plausibility of one character (b2 = 0 for a single byte) or -1 if the
+// bytes are not valid there.
+func scoreDoubleByte(s string, weight func(b1, b2 byte) (w float64, width int)) (float64, int, bool) {
+	var sum float64
+	var chars int
+	for i := 0; i < len(s); {
+		if s[i] < 0x80 { i++; continue }
+		var b2 byte
+		if i+1 < len(s) { b2 = s[i+1] }
+		w, width := weight(s[i], b2)
+		if w < 0 { return 0, 0, false }
+		sum, chars, i = sum+w, chars+1, i+width
+	}
+	return sum, chars, true
+}
+
+func sjisWeight(b1, b2 byte) (float64, int) {
+	if b1 >= 0xA1 && b1 <= 0xDF { return 0.3, 1 } // half-width katakana
+	if !(b1 >= 0x81 && b1 <= 0x9F || b1 >= 0xE0 && b1 <= 0xFC) || !(b2 >= 0x40 && b2 <= 0x7E || b2 >= 0x80 && b2 <= 0xFC) { return -1, 0 }
+	switch {
+	case b1 == 0x82 || b1 == 0x83: return 1, 2 // hiragana, katakana
+	case b1 >= 0x88 && b1 <= 0x98: return 1, 2 // JIS level 1 kanji
+	case b1 == 0x81, b1 >= 0x99 && b1 <= 0x9F, b1 >= 0xE0 && b1 <= 0xEA: return 0.5, 2
+	}
+	return 0.2, 2
+}
+
+func gbkWeight(b1, b2 byte) (float64, int) {
+	if b1 < 0x81 || b1 == 0xFF || !(b2 >= 0x40 && b2 <= 0x7E || b2 >= 0x80 && b2 <= 0xFE) { return -1, 0 }
+	switch {
+	case b2 < 0xA1: return 0.3, 2 // GBK extension
+	case b1 >= 0xB0 && b1 <= 0xD7: return 0.95, 2 // GB2312 level 1 hanzi
+	case b1 >= 0xD8 && b1 <= 0xF7: return 0.7, 2
+	case b1 >= 0xA1 && b1 <= 0xA9: return 0.5, 2 // full-width punctuation, kana
+	}
+	return 0.3, 2
+}
+
+func transcodeNames(enc string, names []string) ([]string, error) {
+	if table := singleByteTables[enc]; table != nil {
+		out := make([]string, len(names))
+		for i, n := range names {
+			var b strings.Builder
+			for j := 0; j < len(n); j++ {
+				if c := n[j]; c < 0x80 { b.WriteByte(c) } else { b.WriteRune(table[c-0x80]) }
+			}
+			out[i] = b.String()
+		}
+		return out, nil
+	}
+	// One iconv run per archive; names cannot contain NUL in either encoding.
+	cmd := exec.Command("iconv", "-f", iconvNames[enc], "-t", "UTF-8")
+	cmd.Stdin = strings.NewReader(strings.Join(names, "\x00"))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" { err = fmt.Errorf("%v: %s", err, msg) }
+		return nil, err
+	}
+	decoded := strings.Split(string(out), "\x00")
+	if len(decoded) != len(names) { return nil, fmt.Errorf("iconv trả về %d tên thay vì %d", len(decoded), len(names)) }
+	return decoded, nil
+}
+
+var singleByteTables = map[string]*[128]rune{"cp437": &cp437Table, "cp1258": &cp1258Table}
+
+var cp437Table = [128]rune{
+	'Ç', 'ü', 'é', 'â', 'ä', 'à', 'å', 'ç', 'ê', 'ë', 'è', 'ï', 'î', 'ì', 'Ä', 'Å',
+	'É', 'æ', 'Æ', 'ô', 'ö', 'ò', 'û', 'ù', 'ÿ', 'Ö', 'Ü', '¢', '£', '¥', '₧', 'ƒ',
+	'á', 'í', 'ó', 'ú', 'ñ', 'Ñ', 'ª', 'º', '¿', '⌐', '¬', '½', '¼', '¡', '«', '»',
+	'░', '▒', '▓', '│', '┤', '╡', '╢', '╖', '╕', '╣', '║', '╗', '╝', '╜', '╛', '┐',
+	'└', '┴', '┬', '├', '─', '┼', '╞', '╟', '╚', '╔', '╩', '╦', '╠', '═', '╬', '╧',
+	'╨', '╤', '╥', '╙', '╘', '╒', '╓', '╫', '╪', '┘', '┌', '█', '▄', '▌', '▐', '▀',
+	'α', 'ß', 'Γ', 'π', 'Σ', 'σ', 'µ', 'τ', 'Φ', 'Θ', 'Ω', 'δ', '∞', 'φ', 'ε', '∩',
+	'≡', '±', '≥', '≤', '⌠', '⌡', '÷', '≈', '°', '∙', '·', '√', 'ⁿ', '²', '■', '\u00a0',
+}
+
+// cp1258Table keeps Vietnamese tone marks as combining characters
+// (U+0300, U+0301, U+0303, U+0309, U+0323), exactly as the code page does.
+var cp1258Table = [128]rune{
+	'€', '\ufffd', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', '\ufffd', '‹', 'Œ', '\ufffd', '\ufffd', '\ufffd',
+	'\ufffd', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', '\ufffd', '›', 'œ', '\ufffd', '\ufffd', 'Ÿ',
+	'\u00a0', '¡', '¢', '£', '¤', '¥', '¦', '§', '¨', '©', 'ª', '«', '¬', '\u00ad', '®', '¯',
+	'°', '±', '²', '³', '´', 'µ', '¶', '·', '¸', '¹', 'º', '»', '¼', '½', '¾', '¿',
+	'À', 'Á', 'Â', 'Ă', 'Ä', 'Å', 'Æ', 'Ç', 'È', 'É', 'Ê', 'Ë', '\u0300', 'Í', 'Î', 'Ï',
+	'Đ', 'Ñ', '\u0309', 'Ó', 'Ô', 'Ơ', 'Ö', '×', 'Ø', 'Ù', 'Ú', 'Û', 'Ü', 'Ư', '\u0303', 'ß',
+	'à', 'á', 'â', 'ă', 'ä', 'å', 'æ', 'ç', 'è', 'é', 'ê', 'ë', '\u0301', 'í', 'î', 'ï',
+	'đ', 'ñ', '\u0323', 'ó', 'ô', 'ơ', 'ö', '÷', 'ø', 'ù', 'ú', 'û', 'ü', 'ư', '₫', 'ÿ',
+}
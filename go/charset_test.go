@@ -0,0 +1,34 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The encoding comes from the options the source is opened with, not from
+// whichever run parsed its flags last.
+func TestSourceEncodingFromOptions(t *testing.T) {
+	for _, tc := range []struct{ enc, want string }{
+		{"cp437", "café.txt"},
+		{encRaw, "caf\x82.txt"},
+	} {
+		path := filepath.Join(t.TempDir(), "src.zip")
+		f, err := os.Create(path)
+		if err != nil { t.Fatal(err) }
+		zw := zip.NewWriter(f)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "caf\x82.txt", NonUTF8: true})
+		if err != nil { t.Fatal(err) }
+		if _, err := io.WriteString(w, "x"); err != nil { t.Fatal(err) }
+		if err := zw.Close(); err != nil { t.Fatal(err) }
+		if err := f.Close(); err != nil { t.Fatal(err) }
+
+		if _, err := parseFlags([]string{"-src-encoding", "auto"}); err != nil { t.Fatal(err) }
+		entries, err := listSource(options{srcEncoding: tc.enc}, path)
+		if err != nil { t.Fatal(err) }
+		if len(entries) != 1 { t.Fatalf("%d entries", len(entries)) }
+		if entries[0].Name != tc.want { t.Errorf("-src-encoding %s: %q, want %q", tc.enc, entries[0].Name, tc.want) }
+	}
+}
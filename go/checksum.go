@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// -checksum writes a coreutils-style sidecar (`<hex>  <name>`, as read by
+// `sha256sum -c`) next to the output. Digests are taken while the bytes are
+// written; only -append needs an extra read of the finished archive.
+
+func validChecksum(algo string) bool {
+	switch algo {
+	case "", "sha256", "sha512", "sha1", "md5": return true
+	}
+	return false
+}
+
+func newChecksum(algo string) hash.Hash {
+	switch algo {
+	case "sha512": return sha512.New()
+	case "sha1": return sha1.New()
+	case "md5": return md5.New()
+	}
+	return sha256.New()
+}
+
+// checksumAlgoFor guesses the algorithm from a sidecar name (SHA256SUMS, md5sums.txt, ...).
+func checksumAlgoFor(path string) string {
+	lower := strings.ToLower(filepath.Base(path))
+	for _, a := range []string{"sha256", "sha512", "sha1", "md5"} {
+		if strings.HasPrefix(lower, a) { return a }
+	}
+	return ""
+}
+
+func checksumPath(opt options) string {
+	return filepath.Join(opt.outDir, strings.ToUpper(opt.checksum)+"SUMS")
+}
+
+type checksumLine struct{ sum, name string }
+
+func readChecksums(path string) ([]checksumLine, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+	var out []checksumLine
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") { continue }
+		i := strings.IndexAny(line, " \t")
+		if i < 0 { return nil, fmt.Errorf("%s:%d: dòng không hợp lệ", path, n) }
+		out = append(out, checksumLine{sum: strings.ToLower(line[:i]), name: strings.TrimPrefix(strings.TrimLeft(line[i:], " \t"), "*")})
+	}
+	return out, sc.Err()
+}
+
+func writeChecksums(path string, lines []checksumLine) error {
+	var b strings.Builder
+	for _, l := range lines { fmt.Fprintf(&b, "%s  %s\n", l.sum, l.name) }
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+func hashFile(path, algo string, buf []byte) (string, error) {
+	f, err := os.Open(path)
+	if err != nil { return "", err }
+	defer f.Close()
+	h := newChecksum(algo)
+	if _, err := io.CopyBuffer(h, f, buf); err != nil { return "", err }
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// runCheck verifies every file listed in a sidecar, relative to the sidecar's
+// directory (or -dir), like `sha256sum -c`.
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	dir := fs.String("dir", "", "Thư mục chứa các file cần kiểm tra (mặc định: thư mục của file checksum)")
+	algo := fs.String("algo", "", "sha256 | sha512 | sha1 | md5 (mặc định: đoán từ tên file checksum)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 { return errors.New("cần đúng một file checksum, vd: check merged_output/SHA256SUMS") }
+	sums := fs.Arg(0)
+	if *algo == "" { *algo = checksumAlgoFor(sums) }
+	if *algo == "" || !validChecksum(*algo) { return fmt.Errorf("không đoán được thuật toán từ %s, dùng -algo", sums) }
+	if *dir == "" { *dir = filepath.Dir(sums) }
+	lines, err := readChecksums(sums)
+	if err != nil { return err }
+	if len(lines) == 0 { return fmt.Errorf("%s không có dòng nào", sums) }
+
+	buf := make([]byte, 4*1024*1024)
+	bad := 0
+	for _, l := range lines {
+		got, err := hashFile(filepath.Join(*dir, l.name), *algo, buf)
+		switch {
+		case err != nil:
+			fmt.Printf("%s: FAILED open (%v)\n", l.name, err)
+			bad++
+		case got != l.sum:
+			fmt.Printf("%s: FAILED\n", l.name)
+			bad++
+		default:
+			fmt.Printf("%s: OK\n", l.name)
+		}
+	}
+	if bad > 0 { return fmt.Errorf("%d/%d file không khớp %s", bad, len(lines), *algo) }
+	return nil
+}
+
+// updateChecksums replaces the sidecar lines of one output (the archive and
+// its split parts) and keeps lines of other outputs in the same directory.
+func updateChecksums(opt options, path string, keepWhole bool, add []checksumLine) error {
+	side := checksumPath(opt)
+	old, err := readChecksums(side)
+	if err != nil && !os.IsNotExist(err) { return err }
+	base := filepath.Base(path)
+	var lines []checksumLine
+	for _, l := range old {
+		if l.name == base && !keepWhole { continue }
+		if partSuffixRe.ReplaceAllString(l.name, "") == base && l.name != base { continue }
+		lines = append(lines, l)
+	}
+	if err := writeChecksums(side, append(lines, add...)); err != nil { return err }
+	logf("Checksum: %s", side)
+	return nil
+}
+
+// addPartChecksums records the split parts, keeping the line of the unsplit
+// archive unless -rm-after-split removed it.
+func addPartChecksums(opt options, path string, parts []splitPart) error {
+	var add []checksumLine
+	for _, p := range parts {
+		add = append(add, checksumLine{sum: hex.EncodeToString(p.sum.Sum(nil)), name: filepath.Base(p.path)})
+	}
+	return updateChecksums(opt, path, !opt.rmAfterSplit, add)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// `completion bash|zsh|fish` prints a completion script. The scripts are thin:
+// they call back `__complete <cur> <prev> <words before cur...>`, so flags
+// and their values always match the binary, and values are looked up live:
+// profile names and remotes from the config file in effect, source names in
+// the -input directory for -filter.
+
+// extractValues are the choices of extract's own -on-existing, which is not
+// part of optionModel.
+var extractValues = map[string][]string{"on-existing": {existingSkip, existingOverwrite, existingRename}}
+
+// Completion answers that tell the script to fall back to the shell's own
+// path completion.
+const (
+	completeFiles = ":files"
+	completeDirs  = ":dirs"
+)
+
+var dirFlags = map[string]bool{"input": true, "outdir": true, "spool-dir": true, "dest": true}
+
+func runCompletion(args []string) error {
+	prog := filepath.Base(os.Args[0])
+	if len(args) != 1 { return errors.New("dùng: completion bash|zsh|fish") }
+	var script string
+	switch args[0] {
+	case "bash": script = bashCompletion
+	case "zsh": script = zshCompletion
+	case "fish": script = fishCompletion
+	default:
+		return fmt.Errorf("shell không hỗ trợ: %q (bash|zsh|fish)", args[0])
+	}
+	fmt.Print(strings.ReplaceAll(script, "PROG", prog))
+	return nil
+}
+
+// runComplete implements the hidden `__complete <cur> <prev> [words...]`;
+// words are the arguments before cur, without the program name.
+func runComplete(args []string) {
+	if len(args) < 2 { return }
+	for _, c := range completeWord(args[0], args[1], args[2:]) { fmt.Println(c) }
+}
+
+func completeWord(cur, prev string, words []string) []string {
+	var opt options
+	fs := defineFlags(&opt)
+	addSubcommandFlags(fs, words)
+
+	if strings.HasPrefix(cur, "-") && strings.Contains(cur, "=") {
+		eq := strings.Index(cur, "=")
+		name := strings.TrimLeft(cur[:eq], "-")
+		var out []string
+		for _, v := range flagCandidates(fs, name, cur[eq+1:], words) { out = append(out, cur[:eq+1]+v) }
+		return out
+	}
+	if name := strings.TrimLeft(prev, "-"); strings.HasPrefix(prev, "-") && !strings.Contains(prev, "=") {
+		if f := fs.Lookup(name); f != nil && !isBoolFlag(f) {
+			if vals := flagCandidates(fs, name, cur, words); len(vals) > 0 { return vals }
+			if dirFlags[name] { return []string{completeDirs} }
+			return []string{completeFiles}
+		}
+	}
+	if strings.HasPrefix(cur, "-") {
+		var out []string
+		fs.VisitAll(func(f *flag.Flag) {
+			if strings.HasPrefix("-"+f.Name, cur) || strings.HasPrefix("--"+f.Name, cur) { out = append(out, "-"+f.Name) }
+		})
+		return out
+	}
+	if len(words) == 0 {
+		var names []string
+		for _, c := range subcommandModel { names = append(names, c.name) }
+		return withPrefix(names, cur)
+	}
+	switch words[0] {
+	case "completion": return withPrefix([]string{"bash", "zsh", "fish"}, cur)
+	case "help":
+		names := []string{"man", "topics"}
+		for _, t := range helpTopics { names = append(names, t.name) }
+		for _, c := range subcommandModel { names = append(names, c.name) }
+		return withPrefix(names, cur)
+	}
+	return []string{completeFiles}
+}
+
+// addSubcommandFlags registers the flags subcommands take in front of the
+// merge flags, so they complete too.
+func addSubcommandFlags(fs *flag.FlagSet, words []string) {
+	if len(words) == 0 { return }
+	switch words[0] {
+	case "plan":
+		fs.String("o", "", "")
+		fs.String("compare", "", "")
+	case "list":
+		fs.Bool("json", false, "")
+		fs.String("top", "", "")
+	case "extract":
+		fs.String("dest", "", "")
+		fs.String("on-existing", "", "")
+	case "verify-signature":
+		fs.String("key", "", "")
+	}
+}
+
+func flagCandidates(fs *flag.FlagSet, name, cur string, words []string) []string {
+	switch name {
+	case "profile":
+		cf := completionConfig(words)
+		if cf == nil { return nil }
+		return withPrefix(sectionNames(cf.profiles), cur)
+	case "recipe":
+		cf := completionConfig(words)
+		if cf == nil { return nil }
+		done, last := "", cur // complete the last name of a comma list
+		if i := strings.LastIndex(cur, ","); i >= 0 { done, last = cur[:i+1], cur[i+1:] }
+		var out []string
+		for _, n := range withPrefix(sectionNames(cf.recipes), last) { out = append(out, done+n) }
+		return out
+	case "remote":
+		return withPrefix(configuredRemotes(words), cur)
+	case "filter":
+		return withPrefix(inputSources(words), cur)
+	}
+	if s := optionSpec(name); s != nil { return withPrefix(s.values, cur) }
+	return withPrefix(extractValues[name], cur)
+}
+
+// completionConfig loads the config file the command line being typed
+// would use, or nil.
+func completionConfig(words []string) *configFile {
+	explicit, _, _ := takeArg(words, "config")
+	path := findConfigFile(explicit)
+	if path == "" { return nil }
+	cf, err := loadConfigFile(path)
+	if err != nil { return nil }
+	return cf
+}
+
+// configuredRemotes collects -remote values from the config file (top level
+// and every profile) and MERGEZIP_REMOTE.
+func configuredRemotes(words []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(list string) {
+		for _, r := range strings.Split(list, ",") {
+			if r = strings.TrimSpace(r); r != "" && !seen[r] { seen[r] = true; out = append(out, r) }
+		}
+	}
+	if cf := completionConfig(words); cf != nil {
+		add(cf.values["remote"])
+		for _, p := range cf.profiles { add(p["remote"]) }
+	}
+	add(os.Getenv(envName("remote")))
+	sort.Strings(out)
+	return out
+}
+
+// inputSources lists the archives in the -input directory of the command
+// line (or config file / MERGEZIP_INPUT).
+func inputSources(words []string) []string {
+	dir, _, _ := takeArg(words, "input")
+	if dir == "" { dir = os.Getenv(envName("input")) }
+	if dir == "" {
+		if cf := completionConfig(words); cf != nil {
+			dir = cf.values["input"]
+			if p, _, _ := takeArg(words, "profile"); p != "" && cf.profiles[p]["input"] != "" { dir = cf.profiles[p]["input"] }
+		}
+	}
+	if dir == "" { return nil }
+	ents, err := os.ReadDir(dir)
+	if err != nil { return nil }
+	var out []string
+	for _, e := range ents {
+		if !e.IsDir() && isSourceName(e.Name()) { out = append(out, e.Name()) }
+	}
+	return out
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func withPrefix(list []string, prefix string) []string {
+	var out []string
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) { out = append(out, s) }
+	}
+	return out
+}
+
+const bashCompletion = `# bash completion for PROG: source <(PROG completion bash)
+_PROG_complete() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" n=$((COMP_CWORD-1))
+	# bash splits -flag=value at "=": complete the value of -flag
+	if [[ "$prev" == "=" ]]; then prev="${COMP_WORDS[COMP_CWORD-2]}"; n=$((COMP_CWORD-2)); fi
+	[[ "$cur" == "=" ]] && { prev="${COMP_WORDS[COMP_CWORD-1]}"; cur=""; }
+	local IFS=$'\n'
+	local out=($(PROG __complete "$cur" "$prev" "${COMP_WORDS[@]:1:n}" 2>/dev/null))
+	case "${out[0]}" in
+	:files) COMPREPLY=($(compgen -f -- "$cur")) ;;
+	:dirs) COMPREPLY=($(compgen -d -- "$cur")) ;;
+	*) COMPREPLY=("${out[@]}") ;;
+	esac
+}
+complete -o filenames -F _PROG_complete PROG
+`
+
+const zshCompletion = `#compdef PROG
+# zsh completion for PROG: PROG completion zsh > "${fpath[1]}/_PROG"
+_PROG() {
+	local -a out
+	out=("${(@f)$(PROG __complete "${words[CURRENT]}" "${words[CURRENT-1]}" "${(@)words[2,CURRENT-1]}" 2>/dev/null)}")
+	case "${out[1]}" in
+	:files) _files ;;
+	:dirs) _files -/ ;;
+	*) [[ -n "${out[1]}" ]] && compadd -a out ;;
+	esac
+}
+compdef _PROG PROG
+`
+
+const fishCompletion = `# fish completion for PROG: PROG completion fish > ~/.config/fish/completions/PROG.fish
+function __PROG_complete
+	set -l words (commandline -opc)
+	set -l cur (commandline -ct)
+	set -l out (PROG __complete "$cur" "$words[-1]" $words[2..-1] 2>/dev/null)
+	switch "$out[1]"
+	case :files
+		__fish_complete_path "$cur"
+	case :dirs
+		__fish_complete_directories "$cur"
+	case '*'
+		printf '%s\n' $out
+	end
+end
+complete -c PROG -f -a '(__PROG_complete)'
+`
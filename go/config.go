@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Where a resolved setting came from, lowest precedence first.
+const (
+	srcDefault = "default"
+	srcFile    = "file"    // -config / .mergezip.yaml top-level keys
+	srcRecipe  = "recipe"  // -recipe sections of that file
+	srcProfile = "profile" // -profile section of that file
+	srcEnv     = "env"
+	srcFlag    = "flag"
+	srcDerived = "derived" // computed from other settings (e.g. -outdir from -input)
+)
+
+const envPrefix = "MERGEZIP_"
+
+// envName maps a flag to its environment variable: -rm-after-split -> MERGEZIP_RM_AFTER_SPLIT.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// resolveFlags parses the command line, then fills every flag that was not
+// given there from the config file (and -recipe, -profile), then from its
+// MERGEZIP_* environment variable.
+func resolveFlags(fs *flag.FlagSet, args []string) (map[string]string, error) {
+	if err := fs.Parse(args); err != nil { return nil, err }
+	sources := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) { sources[f.Name] = srcDefault })
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = srcFlag })
+
+	if fs.Lookup("config") != nil {
+		if err := applyConfigFile(fs, sources); err != nil { return nil, err }
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || sources[f.Name] == srcFlag || f.Name == "config" || f.Name == "profile" { return }
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok { return }
+		if sErr := fs.Set(f.Name, v); sErr != nil {
+			err = fmt.Errorf("%s=%q: %v", envName(f.Name), v, sErr)
+			return
+		}
+		sources[f.Name] = srcEnv
+	})
+	return sources, err
+}
+
+func applyConfigFile(fs *flag.FlagSet, sources map[string]string) error {
+	path := findConfigFile(fs.Lookup("config").Value.String())
+	profile := fs.Lookup("profile").Value.String()
+	if profile == "" { profile = os.Getenv(envName("profile")) }
+	recipe := fs.Lookup("recipe").Value.String()
+	if recipe == "" { recipe = os.Getenv(envName("recipe")) }
+	if path == "" {
+		if profile != "" { return fmt.Errorf("-profile %s cần file cấu hình (-config hoặc %s)", profile, defaultConfigFile) }
+		if recipe != "" { return fmt.Errorf("-recipe %s cần file cấu hình (-config hoặc %s)", recipe, defaultConfigFile) }
+		return nil
+	}
+	cf, err := loadConfigFile(path)
+	if err != nil { return fmt.Errorf("config: %v", err) }
+	if sources["config"] == srcDefault {
+		sources["config"] = srcDerived
+		if os.Getenv(envName("config")) != "" { sources["config"] = srcEnv }
+		_ = fs.Set("config", path)
+	}
+	if sources["profile"] == srcDefault && profile != "" {
+		sources["profile"] = srcEnv
+		_ = fs.Set("profile", profile)
+	}
+
+	apply := func(values map[string]string, src string) error {
+		keys := make([]string, 0, len(values))
+		for k := range values { keys = append(keys, k) }
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == "config" || k == "profile" || fs.Lookup(k) == nil || (src == srcRecipe && k == "recipe") { return fmt.Errorf("%s: key không hợp lệ: %q", path, k) }
+			if sources[k] == srcFlag { continue }
+			if err := fs.Set(k, values[k]); err != nil { return fmt.Errorf("%s: %s=%q: %v", path, k, values[k], err) }
+			sources[k] = src
+		}
+		return nil
+	}
+	if err := apply(cf.values, srcFile); err != nil { return err }
+
+	// The recipe list itself may come from any level; the recipes it names
+	// rank just above the file's top-level keys.
+	switch {
+	case sources["recipe"] == srcFlag:
+	case os.Getenv(envName("recipe")) != "": // set as usual by resolveFlags
+	case cf.profiles[profile]["recipe"] != "":
+		recipe = cf.profiles[profile]["recipe"]
+	default:
+		recipe = cf.values["recipe"]
+	}
+	if recipe != "" {
+		values, err := composeRecipes(fs, cf, splitList(recipe))
+		if err != nil { return err }
+		if err := apply(values, srcRecipe); err != nil { return err }
+	}
+
+	if profile == "" { return nil }
+	p, ok := cf.profiles[profile]
+	if !ok { return fmt.Errorf("%s: không có profile %q (có: %s)", path, profile, strings.Join(sectionNames(cf.profiles), ", ")) }
+	return apply(p, srcProfile)
+}
+
+// composeRecipes merges the named recipes in order. List flags accumulate
+// (-collect-meta of one recipe plus that of the next); any other flag that two recipes
+// set differently is an error, so the order of -recipe never hides a conflict.
+func composeRecipes(fs *flag.FlagSet, cf *configFile, names []string) (map[string]string, error) {
+	values := map[string]string{}
+	from := map[string]string{}
+	for _, name := range names {
+		r, ok := cf.recipes[name]
+		if !ok { return nil, fmt.Errorf("%s: không có recipe %q (có: %s)", cf.path, name, strings.Join(sectionNames(cf.recipes), ", ")) }
+		keys := make([]string, 0, len(r))
+		for k := range r { keys = append(keys, k) }
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := r[k]
+			prev, seen := values[k]
+			f := fs.Lookup(k)
+			switch {
+			case !seen:
+			case f != nil && isListFlag(f):
+				v = strings.Join(appendNew(splitList(prev), splitList(v)...), ",")
+			case prev != v:
+				return nil, fmt.Errorf("%s: recipe %q và %q đặt -%s khác nhau (%q, %q); đặt giá trị trên dòng lệnh hoặc trong profile", cf.path, from[k], name, k, prev, v)
+			}
+			values[k], from[k] = v, name
+		}
+	}
+	return values, nil
+}
+
+func isListFlag(f *flag.Flag) bool {
+	_, ok := f.Value.(*listFlag)
+	return ok
+}
+
+func appendNew(list []string, items ...string) []string {
+	for _, it := range items {
+		dup := false
+		for _, l := range list { dup = dup || l == it }
+		if !dup { list = append(list, it) }
+	}
+	return list
+}
+
+func sectionNames(section map[string]map[string]string) []string {
+	names := make([]string, 0, len(section))
+	for n := range section { names = append(names, n) }
+	sort.Strings(names)
+	return names
+}
+
+// runConfig implements `config show [-o yaml|json] [merge flags...]`.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "show" { return errors.New("dùng: config show [-o yaml|json] [flags...]") }
+	args = args[1:]
+	format := "yaml"
+	if len(args) > 0 && (args[0] == "-o" || args[0] == "--o") {
+		if len(args) < 2 { return errors.New("-o cần giá trị yaml|json") }
+		format, args = args[1], args[2:]
+	} else if len(args) > 0 && (strings.HasPrefix(args[0], "-o=") || strings.HasPrefix(args[0], "--o=")) {
+		format, args = args[0][strings.Index(args[0], "=")+1:], args[1:]
+	}
+
+	opt, err := parseFlags(args)
+	if err != nil { return err }
+	settings := effectiveSettings(opt)
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil { return err }
+		fmt.Println(string(b))
+	case "yaml":
+		fmt.Print(settingsYAML(settings))
+	default:
+		return fmt.Errorf("-o không hợp lệ: %q (yaml|json)", format)
+	}
+	return nil
+}
+
+type setting struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// effectiveSettings lists every flag with its final value; derived values
+// (outdir, corrected chunk sizes, ...) are read back from opt.
+func effectiveSettings(opt options) map[string]setting {
+	derived := map[string]interface{}{
+		"outdir": opt.outDir, "format": opt.format, "chunk": opt.chunkMB, "spool-mb": opt.spoolMB,
+	}
+	out := map[string]setting{}
+	opt.settings.VisitAll(func(f *flag.Flag) {
+		var v interface{} = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok { v = g.Get() }
+		if d, ok := derived[f.Name]; ok && fmt.Sprint(d) != fmt.Sprint(v) {
+			v = d
+			if opt.sources[f.Name] == srcDefault { opt.sources[f.Name] = srcDerived }
+		}
+		out[f.Name] = setting{Value: v, Source: opt.sources[f.Name]}
+	})
+	return out
+}
+
+func settingsYAML(settings map[string]setting) string {
+	names := make([]string, 0, len(settings))
+	for n := range settings { names = append(names, n) }
+	sort.Strings(names)
+	var b strings.Builder
+	for _, n := range names {
+		s := settings[n]
+		fmt.Fprintf(&b, "%s: %s  # %s\n", n, yamlScalar(s.Value), s.Source)
+	}
+	return b.String()
+}
+
+func yamlScalar(v interface{}) string {
+	switch x := v.(type) {
+	case bool: return strconv.FormatBool(x)
+	case int: return strconv.Itoa(x)
+	case []string:
+		q := make([]string, len(x))
+		for i, s := range x { q[i] = strconv.Quote(s) }
+		return "[" + strings.Join(q, ", ") + "]"
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config files hold flag defaults plus named profiles and recipes, in a
+// small YAML subset (no external dependency):
+//
+//	input: ./parts
+//	collect-meta: [LICENSE*, NOTICE*]
+//	profiles:
+//	  nightly:
+//	    out: nightly
+//	    checksum: sha256
+//	    recipe: media,legal
+//	recipes:
+//	  media:
+//	    store-ext: [jpg, mp4, mov]
+//	  legal:
+//	    collect-meta: [LICENSE*, NOTICE*]
+//
+// Keys are flag names. Lists may be inline ([a, b]) or "- item" lines.
+// Precedence: default < file < recipes < profile < MERGEZIP_* env < command line.
+
+const defaultConfigFile = ".mergezip.yaml"
+
+type configFile struct {
+	path     string
+	values   map[string]string
+	profiles map[string]map[string]string
+	recipes  map[string]map[string]string
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	cf := &configFile{path: path, values: map[string]string{}, profiles: map[string]map[string]string{}, recipes: map[string]map[string]string{}}
+	if err := cf.parse(data); err != nil { return nil, err }
+	return cf, nil
+}
+
+func (cf *configFile) parse(data []byte) error {
+	var (
+		section    map[string]map[string]string // profiles or recipes; nil at top level
+		named      map[string]string
+		namedInd   int
+		listKey    string
+		listInto   map[string]string
+		listIndent int
+	)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		raw := strings.TrimRight(sc.Text(), " \t\r")
+		line := stripYAMLComment(raw)
+		if strings.TrimSpace(line) == "" { continue }
+		if strings.Contains(line, "\t") && strings.TrimLeft(line, "\t") != line { return fmt.Errorf("%s:%d: không dùng tab để thụt lề", cf.path, n) }
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		text := strings.TrimSpace(line)
+
+		if listKey != "" && indent >= listIndent && strings.HasPrefix(text, "- ") {
+			item := yamlUnquote(strings.TrimSpace(text[2:]))
+			if listInto[listKey] != "" { item = listInto[listKey] + "," + item }
+			listInto[listKey] = item
+			continue
+		}
+		listKey = ""
+
+		key, val, ok := strings.Cut(text, ":")
+		if !ok { return fmt.Errorf("%s:%d: cần dạng 'key: value'", cf.path, n) }
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch {
+		case indent == 0 && (key == "profiles" || key == "recipes") && val == "":
+			section, named = cf.profiles, nil
+			if key == "recipes" { section = cf.recipes }
+			continue
+		case indent == 0:
+			section = nil
+			listInto = cf.values
+		case section != nil && (named == nil || indent <= namedInd):
+			if val != "" { return fmt.Errorf("%s:%d: '%s' cần các key thụt lề bên dưới", cf.path, n, key) }
+			named, namedInd = map[string]string{}, indent
+			section[key] = named
+			continue
+		case section != nil:
+			listInto = named
+		default:
+			return fmt.Errorf("%s:%d: thụt lề không hợp lệ", cf.path, n)
+		}
+		if val == "" {
+			listKey, listIndent = key, indent+1
+			listInto[key] = ""
+			continue
+		}
+		if strings.HasPrefix(val, "[") {
+			if !strings.HasSuffix(val, "]") { return fmt.Errorf("%s:%d: list thiếu ']'", cf.path, n) }
+			var items []string
+			for _, it := range strings.Split(val[1:len(val)-1], ",") {
+				if it = strings.TrimSpace(it); it != "" { items = append(items, yamlUnquote(it)) }
+			}
+			val = strings.Join(items, ",")
+		} else {
+			val = yamlUnquote(val)
+		}
+		listInto[key] = val
+	}
+	return sc.Err()
+}
+
+// stripYAMLComment drops a trailing "# ..." that is not inside quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote { quote = 0 }
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func yamlUnquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if u, err := strconv.Unquote(s); err == nil { return u }
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' { return strings.ReplaceAll(s[1:len(s)-1], "''", "'") }
+	return s
+}
+
+// findConfigFile returns -config / MERGEZIP_CONFIG, else .mergezip.yaml in
+// the working directory if present, else "".
+func findConfigFile(explicit string) string {
+	if explicit == "" { explicit = os.Getenv(envName("config")) }
+	if explicit != "" { return explicit }
+	if _, err := os.Stat(defaultConfigFile); err == nil { return defaultConfigFile }
+	return ""
+}
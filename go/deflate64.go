@@ -0,0 +1,274 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"io"
+)
+
+// Deflate64 ("enhanced deflate", zip method 9) is what Windows Explorer's
+// "Send to > Compressed folder" writes for large files. The stream format is
+// deflate with a 64 KiB window, length code 285 meaning 3 + 16 extra bits
+// instead of 258, and distance codes 30/31 (14 extra bits). archive/zip has
+// no decoder for it, so such entries used to fail with zip.ErrAlgorithm.
+
+const zipDeflate64 = 9
+
+func init() { zip.RegisterDecompressor(zipDeflate64, newDeflate64Reader) }
+
+const (
+	d64Window  = 1 << 16
+	d64MaxBits = 15
+	d64Fast    = 9 // bits resolved by the lookup table; longer codes are walked
+)
+
+const (
+	blockNone = iota
+	blockStored
+	blockHuffman
+)
+
+var (
+	errDeflate64    = malformed("luồng deflate64 không hợp lệ") // warned as malformed, like flate.CorruptInputError
+	d64LenBase      = [29]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 3}
+	d64LenExtra     = [29]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 16}
+	d64DistBase     = [32]uint32{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577, 32769, 49153}
+	d64DistExtra    = [32]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14}
+	d64CodeLenOrder = [19]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+// huffman is a canonical code: count/symbol as in zlib's puff, plus a table
+// for codes of up to d64Fast bits (entry: symbol<<4 | length, 0 = walk).
+type huffman struct {
+	count  [d64MaxBits + 1]uint16
+	symbol []uint16
+	fast   [1 << d64Fast]uint16
+}
+
+func (h *huffman) build(lengths []uint8) error {
+	*h = huffman{symbol: make([]uint16, 0, len(lengths))}
+	for _, l := range lengths { h.count[l]++ }
+	h.count[0] = 0
+	left := 1
+	for l := 1; l <= d64MaxBits; l++ {
+		left = left<<1 - int(h.count[l])
+		if left < 0 { return errDeflate64 } // over-subscribed
+	}
+	var next [d64MaxBits + 2]uint16
+	code := uint16(0)
+	for l := 1; l <= d64MaxBits; l++ {
+		code = (code + h.count[l-1]) << 1
+		next[l] = code
+	}
+	for l := 1; l <= d64MaxBits; l++ {
+		for sym, sl := range lengths {
+			if int(sl) == l { h.symbol = append(h.symbol, uint16(sym)) }
+		}
+	}
+	for sym, l := range lengths {
+		if l == 0 || l > d64Fast { continue }
+		c := next[l]
+		next[l]++
+		var rev uint16
+		for i := uint8(0); i < l; i++ { rev |= (c >> i & 1) << (l - 1 - i) }
+		for i := rev; i < 1<<d64Fast; i += 1 << l { h.fast[i] = uint16(sym)<<4 | uint16(l) }
+	}
+	return nil
+}
+
+type deflate64Reader struct {
+	r    *bufio.Reader
+	bits uint64
+	nb   uint
+	eof  int // bytes of zero padding fed after the input ran out
+
+	win  [d64Window]byte
+	wpos int
+
+	final   bool
+	block   int // blockNone, blockStored or blockHuffman
+	stored  int // bytes left in a stored block
+	lit     huffman
+	dist    huffman
+	copyLen int
+	copyDst int
+	err     error
+}
+
+func newDeflate64Reader(r io.Reader) io.ReadCloser {
+	return &deflate64Reader{r: bufio.NewReaderSize(r, 64<<10)}
+}
+
+func (d *deflate64Reader) Close() error { return nil }
+
+// need makes sure n (<= 32) bits are buffered. Past the end of input it
+// pads with zeros so the last code can be peeked; consuming the padding is
+// an error.
+func (d *deflate64Reader) need(n uint) {
+	for d.nb < n {
+		b, err := d.r.ReadByte()
+		if err != nil { d.eof++; b = 0 }
+		d.bits |= uint64(b) << d.nb
+		d.nb += 8
+	}
+}
+
+func (d *deflate64Reader) take(n uint) uint32 {
+	d.need(n)
+	v := uint32(d.bits & (1<<n - 1))
+	d.bits >>= n
+	d.nb -= n
+	return v
+}
+
+func (d *deflate64Reader) overrun() bool { return d.eof > 0 && int(d.nb) < 8*d.eof }
+
+func (d *deflate64Reader) decode(h *huffman) (int, error) {
+	d.need(d64MaxBits)
+	if e := h.fast[d.bits&(1<<d64Fast-1)]; e != 0 {
+		l := uint(e & 15)
+		d.bits >>= l
+		d.nb -= l
+		return int(e >> 4), nil
+	}
+	code, first, index := 0, 0, 0
+	for l := uint(1); l <= d64MaxBits; l++ {
+		code |= int(d.bits>>(l-1)) & 1
+		count := int(h.count[l])
+		if code-count < first {
+			d.bits >>= l
+			d.nb -= l
+			return int(h.symbol[index+code-first]), nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, errDeflate64
+}
+
+func (d *deflate64Reader) header() error {
+	d.final = d.take(1) == 1
+	switch d.take(2) {
+	case 0:
+		d.bits >>= d.nb % 8 // stored: skip to a byte boundary
+		d.nb -= d.nb % 8
+		n, nn := d.take(16), d.take(16)
+		if n != ^nn&0xffff { return errDeflate64 }
+		d.stored, d.block = int(n), blockStored
+		return nil
+	case 1:
+		var l [288 + 32]uint8
+		for i := range l {
+			switch {
+			case i < 144: l[i] = 8
+			case i < 256: l[i] = 9
+			case i < 280: l[i] = 7
+			case i < 288: l[i] = 8
+			default: l[i] = 5
+			}
+		}
+		if err := d.lit.build(l[:288]); err != nil { return err }
+		if err := d.dist.build(l[288:]); err != nil { return err }
+	case 2:
+		if err := d.readTables(); err != nil { return err }
+	default:
+		return errDeflate64
+	}
+	d.block = blockHuffman
+	return nil
+}
+
+func (d *deflate64Reader) readTables() error {
+	nlen, ndist, ncode := int(d.take(5))+257, int(d.take(5))+1, int(d.take(4))+4
+	if nlen > 286 { return errDeflate64 }
+	var cl [19]uint8
+	for i := 0; i < ncode; i++ { cl[d64CodeLenOrder[i]] = uint8(d.take(3)) }
+	var ch huffman
+	if err := ch.build(cl[:]); err != nil { return err }
+	lengths := make([]uint8, nlen+ndist)
+	for i := 0; i < len(lengths); {
+		sym, err := d.decode(&ch)
+		if err != nil { return err }
+		if sym < 16 { lengths[i] = uint8(sym); i++; continue }
+		var rep int
+		var val uint8
+		switch sym {
+		case 16:
+			if i == 0 { return errDeflate64 }
+			val, rep = lengths[i-1], 3+int(d.take(2))
+		case 17: rep = 3 + int(d.take(3))
+		default: rep = 11 + int(d.take(7))
+		}
+		if i+rep > len(lengths) { return errDeflate64 }
+		for ; rep > 0; rep-- { lengths[i] = val; i++ }
+	}
+	if lengths[256] == 0 { return errDeflate64 } // no end-of-block code
+	if err := d.lit.build(lengths[:nlen]); err != nil { return err }
+	return d.dist.build(lengths[nlen:])
+}
+
+func (d *deflate64Reader) emit(b byte) {
+	d.win[d.wpos&(d64Window-1)] = b
+	d.wpos++
+}
+
+func (d *deflate64Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && d.err == nil {
+		switch {
+		case d.copyLen > 0:
+			b := d.win[(d.wpos-d.copyDst)&(d64Window-1)]
+			d.copyLen--
+			d.emit(b)
+			p[n] = b
+			n++
+		case d.block == blockNone:
+			if d.final { d.err = io.EOF } else { d.err = d.header() }
+		case d.block == blockStored:
+			if d.stored == 0 { d.block = blockNone; continue }
+			m := min(d.stored, len(p)-n)
+			if d.nb == 0 { // aligned and drained: bulk copy
+				k, err := io.ReadFull(d.r, p[n:n+m])
+				if err != nil { d.err = io.ErrUnexpectedEOF }
+				for _, b := range p[n : n+k] { d.emit(b) }
+				n, d.stored = n+k, d.stored-k
+				continue
+			}
+			b := byte(d.take(8))
+			d.stored--
+			d.emit(b)
+			p[n] = b
+			n++
+		default:
+			sym, err := d.decode(&d.lit)
+			switch {
+			case err != nil: d.err = err
+			case sym < 256:
+				d.emit(byte(sym))
+				p[n] = byte(sym)
+				n++
+			case sym == 256: d.block = blockNone
+			default: d.err = d.match(sym)
+			}
+		}
+	}
+	if d.err == nil && d.overrun() { d.err = io.ErrUnexpectedEOF }
+	if n > 0 { return n, nil } // a pending error is reported by the next call
+	return 0, d.err
+}
+
+// match reads the length extra bits and distance that follow length
+// symbol sym and arms the copy.
+func (d *deflate64Reader) match(sym int) error {
+	i := sym - 257
+	if i >= len(d64LenBase) { return errDeflate64 }
+	length := int(d64LenBase[i]) + int(d.take(uint(d64LenExtra[i])))
+	ds, err := d.decode(&d.dist)
+	if err != nil { return err }
+	if ds >= len(d64DistBase) { return errDeflate64 }
+	dist := int(d64DistBase[ds]) + int(d.take(uint(d64DistExtra[ds])))
+	if dist > d.wpos { return errDeflate64 } // before the start of the stream
+	d.copyLen, d.copyDst = length, dist
+	return nil
+}
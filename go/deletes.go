@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files deleted because a flag asked for it (-rm-after-split, -prune) go
+// through removeByOption. -simulate-deletes logs each of them instead and
+// the run carries on as if they were gone. The first interactive run that
+// may delete files in an output directory asks first; the answer is kept in
+// <outdir>/.mergezip-delete-consent, per flag.
+
+const deleteConsentFile = ".mergezip-delete-consent"
+
+var errDeleteDeclined = errors.New("không đồng ý xoá file, dừng (xem trước bằng -simulate-deletes)")
+
+// deletingFlags lists the deletion flags in effect, with what they delete.
+func deletingFlags(opt options) [][2]string {
+	var out [][2]string
+	if opt.rmAfterSplit && opt.splitSize != "" { out = append(out, [2]string{"rm-after-split", "xoá file output gốc sau khi split xong"}) }
+	if opt.prune {
+		out = append(out, [2]string{"prune", fmt.Sprintf("xoá output cũ nhất bắt đầu bằng %q trong %s (kèm part) khi vượt -quota hoặc thiếu dung lượng, giữ %d bản mới nhất", opt.outBase, opt.outDir, opt.keep)})
+	}
+	return out
+}
+
+func removeByOption(opt options, path, why string) error {
+	if opt.simDeletes {
+		logf("SIMULATE: sẽ xoá %s (%s)", path, why)
+		record(slog.LevelInfo, "simulate-delete", "path", path, "reason", why)
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// confirmDeletes asks on the terminal before deletion flags are used for the
+// first time in opt.outDir. Non-interactive runs (cron, CI) are not asked.
+func confirmDeletes(opt options) error {
+	flags := deletingFlags(opt)
+	if len(flags) == 0 || opt.simDeletes { return nil }
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 { return nil }
+
+	path := filepath.Join(opt.outDir, deleteConsentFile)
+	agreed := map[string]bool{}
+	if b, err := os.ReadFile(path); err == nil {
+		for _, l := range strings.Fields(string(b)) { agreed[l] = true }
+	}
+	var ask [][2]string
+	for _, f := range flags {
+		if !agreed[f[0]] { ask = append(ask, f) }
+	}
+	if len(ask) == 0 { return nil }
+
+	printErr("Lần đầu chạy với flag xoá file trong %s:", opt.outDir)
+	for _, f := range ask { printErr("  -%s: %s", f[0], f[1]) }
+	fmt.Fprint(os.Stderr, "Tiếp tục? (xem trước bằng -simulate-deletes) [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes", "c", "co", "có":
+	default:
+		return errDeleteDeclined
+	}
+	if err := os.MkdirAll(opt.outDir, 0o755); err != nil { return err }
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil { return err }
+	for _, a := range ask { fmt.Fprintln(f, a[0]) }
+	return f.Close()
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// -upload-delta: re-uploading a regenerated output to s3:// sends only what
+// changed. The block signature of every uploaded file is kept in
+// <outdir>/.mergezip-delta/. On the next run the new file is scanned against
+// it with a rolling hash, so blocks are found again even when an earlier
+// entry grew and shifted everything behind it. The object is then rebuilt
+// with a multipart upload: unchanged ranges are UploadPartCopy'd from the
+// previous object on the server, the rest is uploaded. Plain https has no
+// such operation and gets a full PUT.
+
+const (
+	deltaDir     = ".mergezip-delta"
+	deltaBlock   = 1 << 20 // signature block
+	deltaPart    = 64 << 20 // literal ranges are uploaded in parts of about this size
+	deltaCopyMax = 1 << 30
+	s3MinPart    = 5 << 20 // S3 minimum for every part but the last
+	s3MaxParts   = 10000
+	s3MaxPut     = 5 << 30 // S3 maximum for a single PUT
+	rollPrime    = 0x100000001b3
+)
+
+type deltaSig struct {
+	URL    string   `json:"url"`
+	ETag   string   `json:"etag"`
+	Size   int64    `json:"size"`
+	Block  int64    `json:"block"`
+	Weak   []uint64 `json:"weak"`   // rolling hash of each block
+	Strong []string `json:"strong"` // first 16 bytes of its sha256, hex
+}
+
+// deltaSeg is a range of the new file: copied from offset src of the
+// previous object, or uploaded (src < 0).
+type deltaSeg struct{ off, n, src int64 }
+
+func rollHash(b []byte) uint64 {
+	var h uint64
+	for _, c := range b { h = h*rollPrime + uint64(c) }
+	return h
+}
+
+func strongHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
+
+func fileSignature(path string, block int64) (*deltaSig, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+	sig := &deltaSig{Block: block}
+	buf := make([]byte, block)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sig.Weak = append(sig.Weak, rollHash(buf[:n]))
+			sig.Strong = append(sig.Strong, strongHash(buf[:n]))
+			sig.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF { return sig, nil }
+		if err != nil { return nil, err }
+	}
+}
+
+func deltaSigPath(opt options, target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return filepath.Join(opt.outDir, deltaDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+func loadDeltaSig(opt options, target string) *deltaSig {
+	b, err := os.ReadFile(deltaSigPath(opt, target))
+	if err != nil { return nil }
+	var sig deltaSig
+	if json.Unmarshal(b, &sig) != nil || sig.URL != target || sig.Block <= 0 || len(sig.Weak) != len(sig.Strong) { return nil }
+	return &sig
+}
+
+func saveDeltaSig(opt options, sig *deltaSig) error {
+	path := deltaSigPath(opt, sig.URL)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { return err }
+	b, err := json.Marshal(sig)
+	if err != nil { return err }
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil { return err }
+	return os.Rename(tmp, path)
+}
+
+// matchDelta finds old's full blocks anywhere in path (rsync's rolling
+// search) and returns path as a list of copy and literal segments.
+func matchDelta(path string, old *deltaSig) ([]deltaSeg, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+	B := int(old.Block)
+	index := map[uint64][]int{}
+	var filter [1 << 18]uint64 // 2^24-bit prefilter on the top bits, so most bytes skip the map
+	for i, w := range old.Weak {
+		if int64(i+1)*old.Block > old.Size { break } // the short last block never matches a full window
+		index[w] = append(index[w], i)
+		filter[w>>46] |= 1 << (w >> 40 & 63)
+	}
+	var pow uint64 = 1
+	for i := 1; i < B; i++ { pow *= rollPrime }
+
+	var segs []deltaSeg
+	var base, litStart int64 // file offset of buf[0]; start of the pending literal
+	buf := make([]byte, 0, 8*B)
+	p, eof := 0, false
+	// ensure makes buf[p:p+n] available, sliding the buffer; false at EOF.
+	ensure := func(n int) (bool, error) {
+		if p+n <= len(buf) { return true, nil }
+		if eof { return false, nil }
+		base += int64(p)
+		buf = buf[:copy(buf[:cap(buf)], buf[p:])]
+		p = 0
+		for len(buf) < cap(buf) {
+			m, err := f.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+m]
+			if err == io.EOF { eof = true; break }
+			if err != nil { return false, err }
+		}
+		return p+n <= len(buf), nil
+	}
+	literal := func(end int64) {
+		if end > litStart { segs = append(segs, deltaSeg{off: litStart, n: end - litStart, src: -1}) }
+	}
+
+	want := -1 // block expected next after a match
+	var h uint64
+	fresh := true
+	for {
+		ok, err := ensure(B)
+		if err != nil { return nil, err }
+		if !ok { break }
+		if fresh { h, fresh = rollHash(buf[p:p+B]), false }
+		for p+B < len(buf) && filter[h>>46]&(1<<(h>>40&63)) == 0 {
+			h = (h-uint64(buf[p])*pow)*rollPrime + uint64(buf[p+B])
+			p++
+		}
+		if filter[h>>46]&(1<<(h>>40&63)) != 0 {
+			if i := lookupBlock(old, index[h], buf[p:p+B], want); i >= 0 {
+				off := base + int64(p)
+				literal(off)
+				segs = appendCopy(segs, deltaSeg{off: off, n: old.Block, src: int64(i) * old.Block})
+				p += B
+				litStart, want, fresh = off+old.Block, i+1, true
+				continue
+			}
+		}
+		if ok, err := ensure(B + 1); err != nil {
+			return nil, err
+		} else if !ok {
+			break
+		}
+		h = (h-uint64(buf[p])*pow)*rollPrime + uint64(buf[p+B])
+		p++
+	}
+	info, err := f.Stat()
+	if err != nil { return nil, err }
+	literal(info.Size())
+	return segs, nil
+}
+
+// lookupBlock confirms a rolling-hash hit with the strong hash, preferring
+// the block that follows the previous match.
+func lookupBlock(old *deltaSig, cands []int, window []byte, want int) int {
+	if len(cands) == 0 { return -1 }
+	strong := strongHash(window)
+	for _, i := range cands {
+		if i == want && old.Strong[i] == strong { return i }
+	}
+	for _, i := range cands {
+		if old.Strong[i] == strong { return i }
+	}
+	return -1
+}
+
+func appendCopy(segs []deltaSeg, s deltaSeg) []deltaSeg {
+	if n := len(segs); n > 0 {
+		if last := &segs[n-1]; last.src >= 0 && last.off+last.n == s.off && last.src+last.n == s.src { last.n += s.n; return segs }
+	}
+	return append(segs, s)
+}
+
+// planParts turns segments into S3 parts: copies too short to be a part are
+// uploaded instead, short literals borrow from the copy behind them, and long
+// ranges are cut. nil when the result would exceed S3's part count.
+func planParts(segs []deltaSeg, size int64) []deltaSeg {
+	var merged []deltaSeg
+	add := func(s deltaSeg) {
+		if n := len(merged); n > 0 && merged[n-1].src < 0 && s.src < 0 { merged[n-1].n += s.n; return }
+		merged = append(merged, s)
+	}
+	for _, s := range segs {
+		if s.src >= 0 && s.n < s3MinPart && s.off+s.n < size { s.src = -1 }
+		add(s)
+	}
+	for i := 0; i+1 < len(merged); {
+		lit, next := &merged[i], &merged[i+1]
+		if lit.src >= 0 || lit.n >= s3MinPart { i++; continue }
+		need := s3MinPart - lit.n
+		if next.n-need >= s3MinPart || i+2 == len(merged) && next.n > need {
+			lit.n += need
+			next.off, next.src, next.n = next.off+need, next.src+need, next.n-need
+			i++
+			continue
+		}
+		lit.n += next.n // next becomes part of the literal
+		merged = append(merged[:i+1], merged[i+2:]...)
+		if i+1 < len(merged) && merged[i+1].src < 0 { lit.n += merged[i+1].n; merged = append(merged[:i+1], merged[i+2:]...) }
+	}
+
+	partSize := int64(deltaPart)
+	if min := size / (s3MaxParts - 100); partSize < min { partSize = min }
+	var parts []deltaSeg
+	for _, s := range merged {
+		max := partSize
+		if s.src >= 0 { max = deltaCopyMax }
+		k := (s.n + max - 1) / max
+		if k < 1 { k = 1 }
+		for j := int64(0); j < k; j++ { // even cuts keep every piece of a >= 5 MB range >= 5 MB
+			from, to := s.n*j/k, s.n*(j+1)/k
+			p := deltaSeg{off: s.off + from, n: to - from, src: -1}
+			if s.src >= 0 { p.src = s.src + from }
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) > s3MaxParts { return nil }
+	return parts
+}
+
+// uploadDelta uploads one file to s3 as described above; n counts the
+// bytes actually sent.
+func uploadDelta(ctx context.Context, t *uploadTuner, opt options, path string) (int64, error) {
+	name := filepath.Base(path)
+	target := uploadURL(opt.upload, name)
+	info, err := os.Stat(path)
+	if err != nil { return 0, err }
+	if !strings.HasPrefix(strings.ToLower(target), "s3://") || info.Size() == 0 { return uploadFile(ctx, t, opt.upload, path) }
+
+	old := loadDeltaSig(opt, target)
+	if old != nil {
+		if etag, size, err := s3Head(target); err != nil || etag != old.ETag || size != old.Size {
+			logf("Upload delta %s: object trên s3 khác lần upload trước, gửi toàn bộ", name)
+			old = nil
+		}
+	}
+	segs := []deltaSeg{{off: 0, n: info.Size(), src: -1}}
+	if old != nil {
+		if segs, err = matchDelta(path, old); err != nil { return 0, err }
+	}
+	parts := planParts(segs, info.Size())
+	if parts == nil {
+		logf("Upload delta %s: quá %d part, gửi toàn bộ", name, s3MaxParts)
+		parts = planParts([]deltaSeg{{off: 0, n: info.Size(), src: -1}}, info.Size())
+	}
+
+	var oldETag string
+	if old != nil { oldETag = old.ETag }
+	etag, sent, copied, err := s3PutParts(ctx, t, target, path, parts, oldETag)
+	if err != nil { return sent, err }
+	sig, err := fileSignature(path, deltaBlock)
+	if err == nil {
+		sig.URL, sig.ETag = target, etag
+		err = saveDeltaSig(opt, sig)
+	}
+	if err != nil { errorf("WARNING: upload delta %s: không lưu được chữ ký (%v), lần sau sẽ gửi toàn bộ", name, err) }
+	logf("Upload delta %s: gửi %s, sao chép trên server %s (%d part)", name, humanBytes(uint64(sent)), humanBytes(uint64(copied)), len(parts))
+	record(slog.LevelInfo, "upload-delta", "file", path, "url", target, "sent", sent, "copied", copied, "parts", len(parts))
+	return sent, nil
+}
+
+// s3PutParts uploads path to target as one multipart upload: parts with
+// src < 0 are sent from the file under t, the others are copied on the
+// server from the object whose ETag was oldETag. It returns the new ETag and
+// the bytes sent and copied; on failure the upload is aborted.
+func s3PutParts(ctx context.Context, t *uploadTuner, target, path string, parts []deltaSeg, oldETag string) (etag string, sent, copied int64, err error) {
+	name := filepath.Base(path)
+	f, err := os.Open(path)
+	if err != nil { return "", 0, 0, err }
+	defer f.Close()
+	id, err := s3CreateMultipart(ctx, target)
+	if err != nil { return "", 0, 0, fmt.Errorf("upload %s: %v", name, err) }
+	etags := make([]string, len(parts))
+	copies := newUploadTuner(uploadMaxAuto) // server-side copies do not load the uplink
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	pctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for i, p := range parts {
+		wg.Add(1)
+		go func(i int, p deltaSeg) {
+			defer wg.Done()
+			what := fmt.Sprintf("%s part %d/%d", name, i+1, len(parts))
+			var n int64
+			var err error
+			if p.src >= 0 {
+				_, err = withRetry(pctx, copies, what, func() (int64, bool, error) {
+					etag, retry, err := s3CopyPart(pctx, target, id, i+1, p, oldETag)
+					etags[i] = etag
+					return 0, retry, err
+				})
+			} else {
+				n, err = withRetry(pctx, t, what, func() (int64, bool, error) {
+					etag, retry, err := s3UploadPart(pctx, target, id, i+1, io.NewSectionReader(f, p.off, p.n), p.n)
+					etags[i] = etag
+					return p.n, retry, err
+				})
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && first == nil { first = err; cancel() }
+			if p.src >= 0 { copied += p.n } else { sent += n }
+		}(i, p)
+	}
+	wg.Wait()
+	if first == nil { etag, first = s3CompleteMultipart(ctx, target, id, etags) }
+	if first != nil {
+		s3AbortMultipart(target, id)
+		if ctx.Err() != nil { return "", sent, copied, errCanceled }
+		return "", sent, copied, first
+	}
+	return etag, sent, copied, nil
+}
+
+// s3 multipart API. Queries are written in canonical form for signV4.
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3Do sends one request; S3 may report errors (also of CopyPart and
+// Complete) inside a 200 response, so the body is returned checked.
+func s3Do(ctx context.Context, method, target, query string, body io.Reader, size int64, hdr http.Header) (resp *http.Response, data []byte, retry bool, err error) {
+	var rc io.ReadCloser
+	if body != nil { rc = io.NopCloser(body) }
+	req, err := remoteRequestBody(method, target+"?"+query, rc, size, unsignedPayload, hdr)
+	if err != nil { return nil, nil, false, err }
+	resp, err = uploadClient.Do(req.WithContext(ctx))
+	if err != nil { return nil, nil, true, err }
+	defer resp.Body.Close()
+	data, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil { return nil, nil, true, err }
+	var e s3Error
+	if resp.StatusCode/100 != 2 || bytes.Contains(data, []byte("<Error>")) && xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode/100 == 2 || e.Code == "SlowDown" || e.Code == "InternalError"
+		msg := resp.Status
+		if e.Code != "" { msg += " " + e.Code + ": " + e.Message }
+		return nil, nil, retry, fmt.Errorf("%s %s: %s", method, target, msg)
+	}
+	return resp, data, false, nil
+}
+
+func s3Query(uploadID string, part int) string {
+	q := "uploadId=" + strings.ReplaceAll(awsEscapePath(uploadID), "/", "%2F")
+	if part > 0 { q = "partNumber=" + strconv.Itoa(part) + "&" + q }
+	return q
+}
+
+func s3Head(target string) (etag string, size int64, err error) {
+	resp, err := remoteDo(http.MethodHead, target, "")
+	if err != nil { return "", 0, err }
+	_ = resp.Body.Close()
+	return resp.Header.Get("ETag"), resp.ContentLength, nil
+}
+
+func s3CreateMultipart(ctx context.Context, target string) (string, error) {
+	_, data, _, err := s3Do(ctx, http.MethodPost, target, "uploads=", nil, 0, nil)
+	if err != nil { return "", err }
+	var r struct{ UploadID string `xml:"UploadId"` }
+	if err := xml.Unmarshal(data, &r); err != nil || r.UploadID == "" { return "", fmt.Errorf("CreateMultipartUpload: không có UploadId") }
+	return r.UploadID, nil
+}
+
+func s3UploadPart(ctx context.Context, target, id string, part int, body io.Reader, size int64) (string, bool, error) {
+	resp, _, retry, err := s3Do(ctx, http.MethodPut, target, s3Query(id, part), body, size, nil)
+	if err != nil { return "", retry, err }
+	return resp.Header.Get("ETag"), false, nil
+}
+
+// s3CopyPart copies a range of the previous object, only if it is still the
+// one the signature was taken from.
+func s3CopyPart(ctx context.Context, target, id string, part int, p deltaSeg, etag string) (string, bool, error) {
+	u, err := url.Parse(target)
+	if err != nil { return "", false, err }
+	hdr := http.Header{}
+	hdr.Set("x-amz-copy-source", awsEscapePath(u.Host+u.Path))
+	hdr.Set("x-amz-copy-source-range", fmt.Sprintf("bytes=%d-%d", p.src, p.src+p.n-1))
+	hdr.Set("x-amz-copy-source-if-match", etag)
+	_, data, retry, err := s3Do(ctx, http.MethodPut, target, s3Query(id, part), nil, 0, hdr)
+	if err != nil { return "", retry, err }
+	var r struct{ ETag string `xml:"ETag"` }
+	if err := xml.Unmarshal(data, &r); err != nil || r.ETag == "" { return "", true, fmt.Errorf("UploadPartCopy: không có ETag") }
+	return r.ETag, false, nil
+}
+
+func s3CompleteMultipart(ctx context.Context, target, id string, etags []string) (string, error) {
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	body := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, e := range etags { body.Parts = append(body.Parts, part{i + 1, e}) }
+	b, err := xml.Marshal(body)
+	if err != nil { return "", err }
+	var data []byte
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		_, data, retry, err = s3Do(ctx, http.MethodPost, target, s3Query(id, 0), bytes.NewReader(b), int64(len(b)), nil)
+		if err == nil || !retry || attempt >= uploadAttempts || ctx.Err() != nil { break }
+	}
+	if err != nil { return "", err }
+	var r struct{ ETag string `xml:"ETag"` }
+	_ = xml.Unmarshal(data, &r)
+	return r.ETag, nil
+}
+
+// s3AbortMultipart drops the parts of a failed upload, so they are not
+// billed; best effort.
+func s3AbortMultipart(target, id string) {
+	_, _, _, err := s3Do(context.Background(), http.MethodDelete, target, s3Query(id, 0), nil, 0, nil)
+	if err != nil { errorf("WARNING: không huỷ được multipart upload %s (%v)", id, err) }
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// -out may name an existing FIFO or device node (`-out /dev/nst0`,
+// `-out /tmp/to-tape.fifo`). The archive is then streamed into it as is: no
+// extension, no disk space pre-check, nothing removed on cancel, and none of
+// the features that re-read or rename the output file afterwards.
+
+func isSpecialOutput(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&(os.ModeNamedPipe|os.ModeDevice) != 0
+}
+
+func validateDevice(opt *options) error {
+	if opt.outBase == "-" || !isSpecialOutput(opt.outBase) { return nil }
+	opt.outDevice = opt.outBase
+	switch {
+	case opt.appendOut: return errors.New("-out là FIFO/thiết bị: không dùng được -append/-watch")
+	case opt.splitSize != "": return errors.New("-out là FIFO/thiết bị: không dùng được -split")
+	case opt.checksum != "": return errors.New("-out là FIFO/thiết bị: không dùng được -checksum (hãy tính ở phía nhận)")
+	case opt.quota != "" || opt.prune: return errors.New("-out là FIFO/thiết bị: không dùng được -quota/-prune")
+	case opt.budgeted(): return errors.New("-out là FIFO/thiết bị: không dùng được -max-entries/-max-output-bytes")
+	}
+	return nil
+}
+
+// outputPath is where the (first) output archive goes.
+func outputPath(opt options) string {
+	switch {
+	case opt.toStdout: return "-"
+	case opt.outDevice != "": return opt.outDevice
+	}
+	return filepath.Join(opt.outDir, opt.outBase+outputExt(opt.format))
+}
+
+// openDevice opens a FIFO/device for writing without creating or truncating
+// it. Opening a FIFO blocks until a reader is attached.
+func openDevice(path string) (*os.File, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		logf("Chờ tiến trình đọc FIFO %s...", path)
+	}
+	return os.OpenFile(path, os.O_WRONLY, 0)
+}
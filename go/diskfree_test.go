@@ -0,0 +1,20 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDiskFree(t *testing.T) {
+	dir := t.TempDir()
+	n, err := diskFree(dir)
+	if err != nil { t.Fatal(err) }
+	switch runtime.GOOS {
+	case "linux", "darwin", "windows":
+		if n == 0 { t.Errorf("diskFree(%s) = 0", dir) }
+	default:
+		if n != 0 { t.Errorf("diskFree(%s) = %d, want 0 (unknown on %s)", dir, n, runtime.GOOS) }
+	}
+	if _, err := diskFree(filepath.Join(dir, "missing", "dir")); err == nil { t.Error("no error for a missing path") }
+}
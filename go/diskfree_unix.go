@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// diskFree returns the bytes available to an unprivileged user on the
+// filesystem holding path.
+func diskFree(path string) (uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil { return 0, err }
+	return uint64(fs.Bavail) * uint64(fs.Bsize), nil
+}
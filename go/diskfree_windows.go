@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskFree returns the bytes available to the calling user (quota-aware) on
+// the volume holding path.
+func diskFree(path string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil { return 0, err }
+	var avail, total, free uint64
+	r, _, e := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&avail)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&free)))
+	if r == 0 { return 0, e }
+	return avail, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// `extract` runs the merge's source selection, filters, prefixing and
+// __dupN renaming, but writes every entry straight into a directory instead
+// of an intermediate archive.
+
+const (
+	existingSkip      = "skip"
+	existingOverwrite = "overwrite"
+	existingRename    = "rename"
+)
+
+// runExtract implements `extract -dest DIR [-on-existing skip|overwrite|rename] [merge flags...]`.
+// warned reports whether the run finished with warnings.
+func runExtract(args []string) (warned bool, err error) {
+	dest, args, err := takeArg(args, "dest")
+	if err != nil { return false, err }
+	onExisting, args, err := takeArg(args, "on-existing")
+	if err != nil { return false, err }
+	switch onExisting {
+	case "": onExisting = existingSkip
+	case existingSkip, existingOverwrite, existingRename:
+	default:
+		return false, fmt.Errorf("-on-existing không hợp lệ: %q (skip|overwrite|rename)", onExisting)
+	}
+	opt, err := parseFlags(args) // -h/-help print the usage and exit here
+	if err != nil { return false, err }
+	if dest == "" {
+		printSubcommand(os.Stderr, "extract")
+		fmt.Fprintln(os.Stderr)
+		return false, errors.New("extract cần -dest <thư mục>")
+	}
+	switch {
+	case opt.appendOut, opt.splitSize != "", opt.toStdout, opt.checksum != "", opt.manifest != "",
+		opt.budgeted(), opt.spoolDir != "", opt.quota != "", opt.prune, opt.jarMode != jarOff:
+		return false, errors.New("extract không ghi archive: bỏ -append/-split/-out -/-checksum/-manifest/-max-*/-spool-dir/-quota/-prune/-jar-mode")
+	}
+	jobID = opt.jobID
+	closeLog, err := setupLogging(opt)
+	if err != nil { return false, err }
+	defer closeLog()
+	if opt.nice {
+		if err := lowerPriority(); err != nil { errorf("WARNING: -nice: %v", err) }
+	}
+	ctx, stop := cancelOnSignal()
+	defer stop()
+	wl := newWarnLog(opt)
+	err = extractAll(ctx, opt, wl, dest, onExisting)
+	if s := wl.summary(); s != "" { errorf("%s", s) }
+	return wl.total > 0, err
+}
+
+func extractAll(ctx context.Context, opt options, wl *warnLog, dest, onExisting string) error {
+	if err := os.MkdirAll(dest, 0o755); err != nil { return err }
+	root, err := filepath.Abs(dest)
+	if err == nil { root, err = filepath.EvalSymlinks(root) }
+	if err != nil { return err }
+
+	names, paths, err := collectSources(opt)
+	if err != nil { return err }
+	names, paths = filterMinAge(opt, names, paths)
+	if len(names) == 0 { return fmt.Errorf("không tìm thấy .zip khớp '%s' trong %s", opt.filterGlob, opt.inputDir) }
+
+	var overallTotal uint64
+	zipTotals := make([]uint64, len(names))
+	for i := range names {
+		if ctx.Err() != nil { return canceled() }
+		entries, err := listSource(opt, paths[i])
+		if err != nil { continue } // warned when the extract loop gets there
+		zipTotals[i], _ = sumUncompressed(opt, entries)
+		overallTotal += zipTotals[i]
+	}
+	if free, err := diskFree(root); err == nil && free > 0 && free < overallTotal {
+		return fmt.Errorf("%w ở %s: cần ~%.1f GB, còn %.1f GB", errNoSpace, root,
+			float64(overallTotal)/1024/1024/1024, float64(free)/1024/1024/1024)
+	}
+
+	dedup := map[string]int{}
+	start := time.Now()
+	var overallDone uint64
+	var files, skipped int
+	buf := make([]byte, opt.chunkMB*1024*1024)
+	bufs := [][]byte{buf}
+	for len(bufs) < opt.readAhead { bufs = append(bufs, make([]byte, len(buf))) }
+
+	for idx, name := range names {
+		if ctx.Err() != nil { return canceled() }
+		ar, err := openSource(opt, paths[idx])
+		if err != nil {
+			if err := wl.warn(warnCategory(err, warnOpen), "bỏ qua (không mở được): %s (%v)", name, err); err != nil { return err }
+			continue
+		}
+		totalZip := zipTotals[idx]
+		var doneZip uint64
+		lastZipPct, lastAllPct := -1, -1
+		prefix := fmt.Sprintf("[%d/%d] %s", idx+1, len(names), name)
+		progress := func(n uint64) {
+			doneZip += n
+			overallDone += n
+			printZipProgress(prefix, doneZip, totalZip, overallDone, overallTotal, start, &lastZipPct, &lastAllPct)
+		}
+
+		for {
+			if ctx.Err() != nil { _ = ar.close(); return canceled() }
+			f, err := ar.next()
+			if err == io.EOF { break }
+			if err != nil {
+				if err := wl.warn(warnCategory(err, warnList), "lỗi đọc %s: %v", name, err); err != nil { _ = ar.close(); return err }
+				break
+			}
+			if f.invalid != nil {
+				if err := wl.warn(warnMalformed, "bỏ qua entry trong %s: %v", name, f.invalid); err != nil { _ = ar.close(); return err }
+				continue
+			}
+			if !wantEntry(opt, f) { continue }
+			if f.IsDir {
+				target, ok := claimDir(opt, name, f.Name, dedup)
+				if !ok { continue }
+				dst, err := extractPath(root, target, true)
+				if err == nil { err = os.MkdirAll(dst, 0o755) }
+				if err != nil {
+					if err := wl.warn(warnCategory(err, warnCreate), "bỏ qua thư mục '%s' trong %s: %v", f.Name, name, err); err != nil { _ = ar.close(); return err }
+				}
+				continue
+			}
+			target := mapTargetName(opt, name, f.Name, dedup)
+			dst, err := extractPath(root, target, false)
+			if err != nil {
+				if err := wl.warn(warnCategory(err, warnCreate), "bỏ qua entry '%s' trong %s: %v", f.Name, name, err); err != nil { _ = ar.close(); return err }
+				continue
+			}
+			if _, err := os.Lstat(dst); err == nil {
+				switch onExisting {
+				case existingSkip:
+					skipped++
+					progress(f.Size)
+					continue
+				case existingRename:
+					dst = freeName(dst)
+				}
+			}
+
+			err = extractFile(ctx, opt, f, dst, bufs, progress)
+			var rErr *readError
+			switch {
+			case err == nil:
+				files++
+			case errors.As(err, &rErr):
+				if err := wl.warn(warnCategory(rErr.err, warnRead), "lỗi đọc entry '%s' trong %s: %v", f.Name, name, rErr.err); err != nil { _ = ar.close(); return err }
+				if opt.onEntryError == entryErrPlaceholder {
+					if err := extractPlaceholder(dst, name, f, rErr.err); err != nil { _ = ar.close(); return err }
+				}
+			default:
+				_ = ar.close()
+				return err
+			}
+		}
+		printZipProgress(prefix, totalZip, totalZip, overallDone, overallTotal, start, &lastZipPct, &lastAllPct)
+		endProgress()
+		_ = ar.close()
+	}
+
+	logf("Hoàn tất! Giải nén %d file vào %s (bỏ qua %d file đã có)", files, root, skipped)
+	logf("Total time: %s", fmtHMS(time.Since(start)))
+	return nil
+}
+
+// readError marks failures on the source side of extractFile: the entry is
+// skipped with a warning, while errors writing the destination end the run.
+type readError struct{ err error }
+
+func (e *readError) Error() string { return e.err.Error() }
+
+// extractFile copies one entry through a temp file in the destination
+// directory, then restores permission bits and mtime and renames it into
+// place, so an interrupted run never leaves a half-written file under the
+// final name.
+func extractFile(ctx context.Context, opt options, e *sourceEntry, dst string, bufs [][]byte, progress func(uint64)) (err error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil { return err }
+	rc, err := e.open()
+	if err != nil { return &readError{err} }
+	defer rc.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".mergezip-extract-*")
+	if err != nil { return err }
+	defer func() {
+		if err != nil { _ = tmp.Close(); _ = os.Remove(tmp.Name()) }
+	}()
+
+	pf := newPrefetcher(limitReader(rc, opt.readLimit), bufs)
+	defer pf.stop()
+	w := limitWriter(tmp, opt.writeLimit)
+	for {
+		b, rErr := pf.next(ctx)
+		if len(b) > 0 {
+			if _, err := w.Write(b); err != nil { return err }
+			progress(uint64(len(b)))
+		}
+		pf.release(b)
+		if rErr == io.EOF { break }
+		if rErr == errCanceled { return canceled() }
+		if rErr != nil { return &readError{rErr} }
+	}
+	if err := tmp.Close(); err != nil { return err }
+
+	mode := e.Mode
+	if mode == 0 { mode = 0o644 }
+	if err := os.Chmod(tmp.Name(), mode); err != nil { return err }
+	if !e.Modified.IsZero() { _ = os.Chtimes(tmp.Name(), e.Modified, e.Modified) }
+	return os.Rename(tmp.Name(), dst)
+}
+
+// extractPath maps a target name into root and refuses anything that would
+// land outside it (zip-slip): absolute or drive-qualified names, "..", and
+// symlinks already under root that point elsewhere. An existing dst must be
+// a regular file, or a directory when dir is set.
+func extractPath(root, name string, dir bool) (string, error) {
+	rel := filepath.FromSlash(name)
+	if name == "" || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || hasDotDot(name) {
+		return "", malformed("đường dẫn nằm ngoài -dest: %q", name)
+	}
+	dst := filepath.Join(root, rel)
+	if r, err := filepath.Rel(root, dst); err != nil || r == "." || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", malformed("đường dẫn nằm ngoài -dest: %q", name)
+	}
+	for p := filepath.Dir(dst); len(p) > len(root); p = filepath.Dir(p) {
+		if info, err := os.Lstat(p); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", malformed("'%s' đi qua symlink %s", name, p)
+		}
+	}
+	if info, err := os.Lstat(dst); err == nil {
+		switch {
+		case dir && !info.IsDir(): return "", fmt.Errorf("'%s' đã tồn tại và không phải thư mục", dst)
+		case !dir && !info.Mode().IsRegular(): return "", fmt.Errorf("'%s' đã tồn tại và không phải file thường", dst)
+		}
+	}
+	return dst, nil
+}
+
+// freeName returns dst with the first free __dupN suffix, as mapTargetName
+// names duplicates inside an archive.
+func freeName(dst string) string {
+	root, ext := dst, filepath.Ext(dst)
+	root = strings.TrimSuffix(root, ext)
+	for n := 1; ; n++ {
+		cand := fmt.Sprintf("%s__dup%d%s", root, n, ext)
+		if _, err := os.Lstat(cand); os.IsNotExist(err) { return cand }
+	}
+}
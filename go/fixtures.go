@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gen-fixtures writes synthetic source zips for exercising merges: name
+// collisions across zips, compressible and random data, truncated and
+// corrupted archives, entries flagged as encrypted and non-UTF-8 names.
+// The same -seed always produces byte-identical files.
+
+type fixtureSpec struct {
+	dir       string
+	zips      int
+	entries   int
+	size      int64
+	dup       float64
+	broken    int
+	encrypted int
+	weird     int
+	seed      int64
+	zip64     bool
+}
+
+// cp437Names are stored as raw CP437 bytes without the UTF-8 flag, the way
+// old Windows tools write them.
+var cp437Names = [][]byte{
+	[]byte("caf\x82.txt"),                // café
+	[]byte("na\x8bve/r\x82sum\x82.txt"), // naïve/résumé
+	[]byte("\x9a\x81ber.txt"),            // Üüber
+}
+
+func runGenFixtures(args []string) error {
+	fs := flag.NewFlagSet("gen-fixtures", flag.ExitOnError)
+	var sp fixtureSpec
+	var size string
+	fs.StringVar(&sp.dir, "o", "fixtures", "Thư mục ghi các zip")
+	fs.IntVar(&sp.zips, "zips", 5, "Số zip hợp lệ")
+	fs.IntVar(&sp.entries, "entries", 20, "Số entry mỗi zip")
+	fs.StringVar(&size, "size", "16k", "Kích thước trung bình mỗi entry")
+	fs.Float64Var(&sp.dup, "dup", 0.2, "Tỉ lệ entry trùng đường dẫn giữa các zip (0..1)")
+	fs.IntVar(&sp.broken, "broken", 1, "Số zip hỏng thêm vào (xen kẽ: bị cắt cụt / dữ liệu sai CRC)")
+	fs.IntVar(&sp.encrypted, "encrypted", 1, "Số entry gắn cờ mã hoá mỗi zip")
+	fs.IntVar(&sp.weird, "weird-names", 1, "Số entry tên CP437 (không UTF-8) mỗi zip")
+	fs.Int64Var(&sp.seed, "seed", 1, "Seed; cùng seed cho ra cùng file")
+	fs.BoolVar(&sp.zip64, "zip64", false, "Thêm zip64-huge.zip (1 entry > 4 GiB) và zip64-many.zip (70.000 entry) để thử Zip64")
+	_ = fs.Parse(args)
+	var err error
+	if sp.size, err = parseSize(size); err != nil || sp.size <= 0 || sp.size > maxBufferBytes/2 { return fmt.Errorf("-size không hợp lệ: %q", size) }
+	if sp.zips < 0 || sp.entries <= 0 || sp.dup < 0 || sp.dup > 1 { return errors.New("-zips/-entries/-dup ngoài miền hợp lệ") }
+	if err := os.MkdirAll(sp.dir, 0o755); err != nil { return err }
+	if err := genFixtures(sp); err != nil { return err }
+	if sp.zip64 { return genZip64Fixtures(sp) }
+	return nil
+}
+
+func genFixtures(sp fixtureSpec) error {
+	rng := rand.New(rand.NewSource(sp.seed))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	total := sp.zips + sp.broken
+	for z := 1; z <= total; z++ {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for i := 0; i < sp.entries; i++ {
+			name := fmt.Sprintf("z%d/dir%d/file-%03d.%s", z, i%4, i, []string{"txt", "bin", "log"}[i%3])
+			if rng.Float64() < sp.dup { name = fmt.Sprintf("shared/file-%03d.txt", i) }
+			data := fixtureData(rng, sp.size, i%3 != 1)
+			hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: base.Add(time.Duration(rng.Intn(365*24)) * time.Hour)}
+			w, err := zw.CreateHeader(hdr)
+			if err != nil { return err }
+			if _, err := w.Write(data); err != nil { return err }
+		}
+		for i := 0; i < sp.weird; i++ {
+			raw := cp437Names[i%len(cp437Names)]
+			hdr := &zip.FileHeader{Name: fmt.Sprintf("z%d/", z) + string(raw), NonUTF8: true, Method: zip.Store, Modified: base}
+			w, err := zw.CreateHeader(hdr)
+			if err != nil { return err }
+			if _, err := w.Write(fixtureData(rng, 256, true)); err != nil { return err }
+		}
+		for i := 0; i < sp.encrypted; i++ {
+			// Stdlib cannot encrypt; a stored entry with the encryption bit and
+			// random "ciphertext" is what readers have to cope with anyway.
+			data := fixtureData(rng, 12+sp.size/4, false)
+			hdr := &zip.FileHeader{Name: fmt.Sprintf("z%d/secret-%d.dat", z, i), Method: zip.Store, Flags: 0x1, Modified: base,
+				CRC32: crc32.ChecksumIEEE(data), CompressedSize64: uint64(len(data)), UncompressedSize64: uint64(len(data) - 12)}
+			w, err := zw.CreateRaw(hdr)
+			if err != nil { return err }
+			if _, err := w.Write(data); err != nil { return err }
+		}
+		if err := zw.Close(); err != nil { return err }
+
+		b := buf.Bytes()
+		kind := "ok"
+		if z > sp.zips {
+			if (z-sp.zips)%2 == 1 {
+				b, kind = b[:len(b)*2/3], "truncated"
+			} else {
+				b, kind = corruptFirstEntry(b), "bad-crc"
+			}
+		}
+		name := filepath.Join(sp.dir, fmt.Sprintf("part-%d.zip", z))
+		if err := os.WriteFile(name, b, 0o644); err != nil { return err }
+		if err := os.Chtimes(name, base, base); err != nil { return err }
+		fmt.Printf("%s  %-9s %s\n", name, kind, humanBytes(uint64(len(b))))
+	}
+	return nil
+}
+
+// genZip64Fixtures writes the archives that need Zip64 records: a stored
+// entry just over 4 GiB (so the archive and any -split part boundary lie
+// beyond 4 GiB too) and an archive with more than 65,535 entries. Data is
+// streamed; nothing of that size is held in memory.
+func genZip64Fixtures(sp fixtureSpec) error {
+	rng := rand.New(rand.NewSource(sp.seed))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	block := make([]byte, 1<<20)
+	rng.Read(block)
+	write := func(name string, fill func(zw *zip.Writer) error) error {
+		path := filepath.Join(sp.dir, name)
+		f, err := os.Create(path)
+		if err != nil { return err }
+		bw := bufio.NewWriterSize(f, 1<<20)
+		zw := zip.NewWriter(bw)
+		err = fill(zw)
+		if err == nil { err = zw.Close() }
+		if err == nil { err = bw.Flush() }
+		if cErr := f.Close(); err == nil { err = cErr }
+		if err != nil { return err }
+		if err := os.Chtimes(path, base, base); err != nil { return err }
+		info, err := os.Stat(path)
+		if err != nil { return err }
+		fmt.Printf("%s  %-9s %s\n", path, "zip64", humanBytes(uint64(info.Size())))
+		return nil
+	}
+	err := write("zip64-huge.zip", func(zw *zip.Writer) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "zip64/huge.bin", Method: zip.Store, Modified: base})
+		if err != nil { return err }
+		for i := 0; i < 4096+1; i++ {
+			if _, err := w.Write(block); err != nil { return err }
+		}
+		return nil
+	})
+	if err != nil { return err }
+	return write("zip64-many.zip", func(zw *zip.Writer) error {
+		for i := 0; i < 70000; i++ {
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("zip64/many/%05d.txt", i), Method: zip.Store, Modified: base})
+			if err != nil { return err }
+			if _, err := fmt.Fprintf(w, "%d\n", i); err != nil { return err }
+		}
+		return nil
+	})
+}
+
+// fixtureData returns n±50% bytes: repetitive text or random bytes.
+func fixtureData(rng *rand.Rand, n int64, text bool) []byte {
+	size := n/2 + rng.Int63n(n+1)
+	out := make([]byte, size)
+	if !text {
+		rng.Read(out)
+		return out
+	}
+	words := strings.Fields("lorem ipsum dolor sit amet merge zip suite part chunk deflate store entry")
+	var b bytes.Buffer
+	for int64(b.Len()) < size { b.WriteString(words[rng.Intn(len(words))] + " ") }
+	copy(out, b.Bytes())
+	return out
+}
+
+// corruptFirstEntry flips bytes inside the first entry's data so the
+// archive opens fine but reading that entry fails its CRC check.
+func corruptFirstEntry(b []byte) []byte {
+	out := append([]byte(nil), b...)
+	if len(out) < 30 { return out }
+	start := 30 + int(out[26]) | int(out[27])<<8
+	start += int(out[28]) | int(out[29])<<8
+	for i := start; i < start+16 && i < len(out); i++ { out[i] ^= 0xFF }
+	return out
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/flate"
+	"flag"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The options model: every merge flag is declared once here, with the help
+// topic it belongs to and its fixed choices. defineFlags builds the FlagSet
+// from it (so the config file, MERGEZIP_* and `config show` follow), and
+// help.go renders -help, `help <topic>` and the man page from the same
+// table; shell completion takes its value lists from it.
+
+type optSpec struct {
+	name   string
+	topic  string
+	def    interface{}                  // default; a string list for []string fields
+	field  func(o *options) interface{} // pointer into options; nil: read by resolveFlags only
+	values []string                     // fixed choices (help, completion)
+	usage  string
+}
+
+var optionModel = []optSpec{
+	{name: "config", topic: "config", def: "", usage: "File cấu hình YAML (mặc định: " + defaultConfigFile + " trong thư mục hiện tại nếu có)"},
+	{name: "profile", topic: "config", def: "", usage: "Profile trong file cấu hình, vd: nightly"},
+	{name: "recipe", topic: "config", def: "", usage: "Recipe trong file cấu hình (mục recipes:), ghép theo thứ tự, vd: sanitize,dedupe,partition-monthly"},
+
+	{name: "input", topic: "sources", def: "abcxyz", field: func(o *options) interface{} { return &o.inputDir }, usage: "Thư mục chứa .zip nguồn"},
+	{name: "remote", topic: "sources", field: func(o *options) interface{} { return &o.remote }, usage: "Nguồn từ xa (https://..., s3://bucket/key), phân cách bởi dấu phẩy; đọc bằng HTTP Range, không tải về"},
+	{name: "filter", topic: "sources", def: "*.zip", field: func(o *options) interface{} { return &o.filterGlob }, usage: "Glob lọc (vd: 'part-*.zip')"},
+	{name: "src-encoding", topic: "sources", def: encAuto, field: func(o *options) interface{} { return &o.srcEncoding }, values: append([]string{encAuto, encRaw}, nameEncodings...), usage: "Bảng mã của tên entry zip không có cờ UTF-8: auto (đoán theo từng archive) | raw (giữ nguyên byte) | cp437 | cp1258 | shift-jis | gbk"},
+	{name: "sort", topic: "sources", def: sortNatural, field: func(o *options) interface{} { return &o.sortBy }, values: []string{sortNatural, sortName, sortMtime, sortSize, sortNone}, usage: "Thứ tự zip nguồn: natural (part-2 trước part-10) | name | mtime | size | none"},
+	{name: "reverse", topic: "sources", def: false, field: func(o *options) interface{} { return &o.reverse }, usage: "Đảo ngược thứ tự -sort"},
+	{name: "priority", topic: "sources", field: func(o *options) interface{} { return &o.priority }, usage: "Glob nguồn/entry ưu tiên (vd: 'reports-*.zip,*.csv'): các nguồn này (hoặc chứa entry khớp) được merge trước, entry khớp trong zip được chép trước"},
+	{name: "min-age", topic: "sources", def: time.Duration(0), field: func(o *options) interface{} { return &o.minAge }, usage: "Chỉ lấy zip nguồn không bị sửa trong khoảng này (vd: 5m), tránh file đang upload"},
+	{name: "on-changed", topic: "sources", def: changedSkip, field: func(o *options) interface{} { return &o.onChanged }, values: []string{changedSkip, changedWait, changedFail}, usage: "Zip nguồn đổi size/mtime sau pre-scan: skip | wait (chờ ổn định rồi merge) | fail"},
+
+	{name: "newer-than", topic: "filters", def: "", field: func(o *options) interface{} { return &o.newerThan }, usage: "Chỉ lấy entry sửa đổi sau thời điểm này (RFC3339, 2006-01-02 hoặc tương đối: 30d, 2w, 12h)"},
+	{name: "older-than", topic: "filters", def: "", field: func(o *options) interface{} { return &o.olderThan }, usage: "Chỉ lấy entry sửa đổi trước thời điểm này (cùng cú pháp -newer-than)"},
+	{name: "min-size", topic: "filters", def: "", field: func(o *options) interface{} { return &o.minSize }, usage: "Bỏ entry nhỏ hơn kích thước này, vd: 1k"},
+	{name: "max-size", topic: "filters", def: "", field: func(o *options) interface{} { return &o.maxSize }, usage: "Bỏ entry lớn hơn kích thước này, vd: 100m"},
+	{name: "keep-dirs", topic: "filters", def: false, field: func(o *options) interface{} { return &o.keepDirs }, usage: "Giữ entry thư mục của nguồn (kể cả thư mục rỗng)"},
+
+	{name: "outdir", topic: "output", def: "", field: func(o *options) interface{} { return &o.outDir }, usage: "Thư mục output (mặc định: <input>_out)"},
+	{name: "out", topic: "output", def: "merged", field: func(o *options) interface{} { return &o.outBase }, usage: "Tên file đầu ra (không kèm phần mở rộng); '-' = ghi ra stdout; đường dẫn FIFO/thiết bị có sẵn = ghi thẳng vào đó"},
+	{name: "format", topic: "output", def: "zip", field: func(o *options) interface{} { return &o.format }, values: []string{"zip", "tar", "tgz", "tzst"}, usage: "Định dạng đầu ra: zip | tar | tgz | tzst (tzst cần lệnh zstd)"},
+	{name: "store", topic: "output", def: false, field: func(o *options) interface{} { return &o.store }, usage: "Ghi không nén (nhanh hơn, file to hơn)"},
+	{name: "level", topic: "output", def: flate.DefaultCompression, field: func(o *options) interface{} { return &o.deflateLevel }, values: []string{"-2", "-1", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, usage: "Mức nén Deflate (-2..9)"},
+	{name: "store-ext", topic: "output", def: defaultStoreExt, field: func(o *options) interface{} { return &o.storeExt }, usage: "Đuôi file lưu không nén (đã nén sẵn), phân cách bởi dấu phẩy; '' để tắt"},
+	{name: "store-entropy", topic: "output", def: false, field: func(o *options) interface{} { return &o.storeEntropy }, usage: "Đo entropy 64KB đầu mỗi entry, dữ liệu gần ngẫu nhiên thì lưu không nén"},
+	{name: "append", topic: "output", def: false, field: func(o *options) interface{} { return &o.appendOut }, usage: "Ghi nối vào file .zip đầu ra đã có (chỉ thêm entry của zip nguồn mới)"},
+	{name: "keep-comments", topic: "output", def: false, field: func(o *options) interface{} { return &o.keepComments }, usage: "Giữ comment của từng entry (zip)"},
+	{name: "comment", topic: "output", def: "", field: func(o *options) interface{} { return &o.comment }, usage: "Comment của zip đầu ra; thay {sources} {count} {time} {job}, \\n = xuống dòng"},
+	{name: "checksum", topic: "output", def: "", field: func(o *options) interface{} { return &o.checksum }, values: []string{"sha256", "sha512", "sha1", "md5"}, usage: "Ghi file checksum (SHA256SUMS...) cho output và từng part, tính ngay khi ghi: sha256 | sha512 | sha1 | md5"},
+	{name: "manifest", topic: "output", def: "", field: func(o *options) interface{} { return &o.manifest }, usage: "Ghi manifest từng entry (nguồn, đường dẫn, size, CRC32, phương thức/size nén trước và sau, ...): .json hoặc .csv"},
+	{name: "sign-key", topic: "output", def: "", field: func(o *options) interface{} { return &o.signKey }, usage: "Khoá riêng ed25519 (PEM PKCS#8): nhúng " + signManifestName + " (sha256 từng entry) và " + signatureName + " vào zip; kiểm tra bằng verify-signature"},
+	{name: "block-size", topic: "output", def: "", field: func(o *options) interface{} { return &o.blockSize }, usage: "Ghi output theo block cố định (vd: 256k) và kết thúc archive đúng biên block, cho tape (zip|tar)"},
+	{name: "checkpoint", topic: "output", def: "", field: func(o *options) interface{} { return &o.checkpoint }, usage: "Mỗi N bytes output (vd: 10g) fsync và ghi một dòng checkpoint vào log"},
+	{name: "spool-dir", topic: "output", def: "", field: func(o *options) interface{} { return &o.spoolDir }, usage: "Đệm output qua thư mục local nhanh, ghi dồn sang đích ở nền (cho đích chậm/mạng)"},
+	{name: "spool-mb", topic: "output", def: 64, field: func(o *options) interface{} { return &o.spoolMB }, usage: "Kích thước mỗi segment spool (MB)"},
+
+	{name: "upload", topic: "upload", def: "", field: func(o *options) interface{} { return &o.upload }, usage: "Sau khi xong, PUT output (các part nếu -split, rồi file checksum/manifest) lên tiền tố này: https://host/dir/ hoặc s3://bucket/prefix/"},
+	{name: "upload-concurrency", topic: "upload", def: 0, field: func(o *options) interface{} { return &o.uploadConc }, usage: "Số PUT song song khi -upload (0: tự điều chỉnh theo thông lượng và lỗi, tối đa 8)"},
+	{name: "upload-delta", topic: "upload", def: false, field: func(o *options) interface{} { return &o.uploadDelta }, usage: "s3://: chỉ gửi phần thay đổi so với lần upload trước (multipart, phần giống được sao chép trên server)"},
+
+	{name: "prefix-by-zip", topic: "conflicts", def: false, field: func(o *options) interface{} { return &o.prefixByZip }, usage: "Lồng theo tên zip gốc (mặc định: giữ root)"},
+	{name: "jar-mode", topic: "conflicts", def: jarOff, field: func(o *options) interface{} { return &o.jarMode }, values: []string{jarOff, jarFirst, jarMerge}, usage: "Nguồn .jar/.war/.ear: gộp nối các file META-INF/services, MANIFEST.MF lấy của nguồn đầu (first) hoặc gộp thuộc tính (merge), bỏ file chữ ký; -filter mặc định thành '" + jarFilter + "'"},
+	{name: "collect-meta", topic: "conflicts", field: func(o *options) interface{} { return &o.collectMeta }, usage: "Gom các file metadata (glob theo tên, vd: 'LICENSE*,metadata.json') vào " + metadataDir + "/<zip>/..."},
+
+	{name: "split", topic: "split", def: "", field: func(o *options) interface{} { return &o.splitSize }, usage: "Chia nhỏ file đầu ra (raw split), vd: 1900m, 1.5g, 2GB (= 2000000000 bytes)"},
+	{name: "splitmode", topic: "split", def: "raw", field: func(o *options) interface{} { return &o.splitMode }, values: []string{"raw"}, usage: "Chế độ split: raw (mặc định)"},
+	{name: "rm-after-split", topic: "split", def: false, field: func(o *options) interface{} { return &o.rmAfterSplit }, usage: "Xoá file .zip lớn sau khi split"},
+	{name: "split-verify", topic: "split", def: false, field: func(o *options) interface{} { return &o.splitVerify }, usage: "fsync rồi đọc lại từng part để so sha256 trước khi sang part kế (USB/media không tin cậy)"},
+	{name: "split-meta", topic: "split", def: false, field: func(o *options) interface{} { return &o.splitMeta }, usage: "Gắn trailer metadata (index/total/tên/size/sha256) vào mỗi part; ghép bằng lệnh join"},
+
+	{name: "max-entries", topic: "limits", def: 0, field: func(o *options) interface{} { return &o.maxEntries }, usage: "Số entry tối đa mỗi file output (0: không giới hạn)"},
+	{name: "max-output-bytes", topic: "limits", def: "", field: func(o *options) interface{} { return &o.maxOutput }, usage: "Dung lượng tối đa mỗi file output, vd: 4g (ước tính bi quan: coi như không nén được)"},
+	{name: "on-overflow", topic: "limits", def: overflowFail, field: func(o *options) interface{} { return &o.onOverflow }, values: []string{overflowFail, overflowRollover, overflowTruncate}, usage: "Khi vượt -max-entries/-max-output-bytes: fail | rollover (sang <out>-2, <out>-3...) | truncate-report (bỏ entry, ghi báo cáo)"},
+	{name: "quota", topic: "limits", def: "", field: func(o *options) interface{} { return &o.quota }, usage: "Giới hạn tổng dung lượng thư mục output, vd: 200g"},
+	{name: "prune", topic: "limits", def: false, field: func(o *options) interface{} { return &o.prune }, usage: "Xoá output cũ nhất (cùng tiền tố -out, kèm các part) khi vượt -quota hoặc thiếu dung lượng"},
+	{name: "simulate-deletes", topic: "limits", def: false, field: func(o *options) interface{} { return &o.simDeletes }, usage: "Không xoá gì: chỉ log các file -rm-after-split/-prune sẽ xoá"},
+	{name: "keep", topic: "limits", def: 1, field: func(o *options) interface{} { return &o.keep }, usage: "Số output cũ mới nhất luôn giữ lại khi -prune"},
+	{name: "zip-timeout", topic: "limits", def: time.Duration(0), field: func(o *options) interface{} { return &o.zipTimeout }, usage: "Thời gian tối đa cho một zip nguồn (vd: 30m); quá thì bỏ phần còn lại của nó với WARNING timeout (0: không giới hạn)"},
+	{name: "deadline", topic: "limits", def: time.Duration(0), field: func(o *options) interface{} { return &o.deadline }, usage: "Hạn chót cho cả merge (vd: 6h): hết hạn thì đóng output hợp lệ với những gì đã gộp và liệt kê nguồn còn thiếu"},
+	{name: "min-free", topic: "limits", def: "", field: func(o *options) interface{} { return &o.minFree }, usage: "Dung lượng trống tối thiểu giữ lại trên volume output trong lúc merge, vd: 5g; kiểm tra trước mỗi entry (xem -on-low-disk)"},
+	{name: "on-low-disk", topic: "limits", def: lowDiskWait, field: func(o *options) interface{} { return &o.onLowDisk }, values: []string{lowDiskWait, lowDiskRollover, lowDiskFinish}, usage: "Khi dung lượng trống sắp dưới -min-free: wait (tạm dừng chờ) | rollover (đóng output, sang <out>-N trong -overflow-dir) | finish (đóng output hợp lệ, liệt kê nguồn còn thiếu)"},
+	{name: "overflow-dir", topic: "limits", def: "", field: func(o *options) interface{} { return &o.overflowDir }, usage: "-on-low-disk rollover: thư mục (volume khác) cho các output tiếp theo"},
+	{name: "low-disk-wait", topic: "limits", def: time.Duration(0), field: func(o *options) interface{} { return &o.lowDiskWait }, usage: "-on-low-disk wait: chờ tối đa bao lâu rồi dừng với exit 8 (0: không giới hạn)"},
+
+	{name: "strict", topic: "errors", def: false, field: func(o *options) interface{} { return &o.strict }, usage: "Dừng ngay ở lỗi đầu tiên (zip/entry không đọc được) thay vì chỉ WARNING"},
+	{name: "max-warnings", topic: "errors", def: -1, field: func(o *options) interface{} { return &o.maxWarnings }, usage: "Dừng khi số WARNING vượt quá N (-1: không giới hạn)"},
+	{name: "on-entry-error", topic: "errors", def: entryErrSkip, field: func(o *options) interface{} { return &o.onEntryError }, values: []string{entryErrSkip, entryErrPlaceholder}, usage: "Entry nguồn không đọc được: skip (chỉ WARNING) | placeholder (ghi thêm <path>" + placeholderSuffix + " chứa lỗi)"},
+	{name: "secret-scan", topic: "errors", def: secretOff, field: func(o *options) interface{} { return &o.secretScan }, values: []string{secretOff, secretReport, secretBlock}, usage: "Quét entry text tìm secret (AWS key, private key, chuỗi entropy cao): off | report (WARNING secret) | block (dừng, không để lại output)"},
+	{name: "idempotency-key", topic: "errors", def: "", field: func(o *options) interface{} { return &o.idemKey }, usage: "Khoá của lần submit: chạy lại với cùng khoá không gộp lần nữa mà báo job đã có (output, hoặc exit 7 nếu còn đang chạy)"},
+
+	{name: "watch", topic: "watch", def: false, field: func(o *options) interface{} { return &o.watch }, usage: "Chạy liên tục: theo dõi thư mục input và -append mỗi zip mới vào output khi nó đã ổn định"},
+	{name: "watch-interval", topic: "watch", def: 10 * time.Second, field: func(o *options) interface{} { return &o.watchEvery }, usage: "-watch: chu kỳ quét thư mục input"},
+	{name: "stable-for", topic: "watch", def: 30 * time.Second, field: func(o *options) interface{} { return &o.stableFor }, usage: "-watch: zip mới phải giữ nguyên size/mtime trong khoảng này mới được gộp"},
+
+	{name: "v", topic: "logging", def: false, field: func(o *options) interface{} { return &o.verbose }, usage: "Log chi tiết: entry bị lọc/đổi tên, tóm tắt từng zip nguồn"},
+	{name: "q", topic: "logging", def: false, field: func(o *options) interface{} { return &o.quiet }, usage: "Chỉ in WARNING/ERROR (không progress, trừ -progress summary)"},
+	{name: "progress", topic: "logging", def: progressBar, field: func(o *options) interface{} { return &o.progress }, values: []string{progressBar, progressSummary, progressNone}, usage: "Hiển thị tiến độ: bar (dòng \\r tương tác) | summary (mỗi -summary-interval một dòng ASCII, kể cả khi -q) | none"},
+	{name: "summary-interval", topic: "logging", def: 60 * time.Second, field: func(o *options) interface{} { return &o.summaryEvery }, usage: "-progress summary: khoảng cách giữa hai dòng trạng thái"},
+	{name: "log-file", topic: "logging", def: "", field: func(o *options) interface{} { return &o.logFile }, usage: "Ghi log có cấu trúc (JSON lines, có timestamp) vào file này, tách khỏi progress"},
+	{name: "job-id", topic: "logging", def: "", field: func(o *options) interface{} { return &o.jobID }, usage: "ID của lần chạy, gắn vào mọi dòng log và manifest (mặc định: tự sinh)"},
+
+	{name: "chunk", topic: "performance", def: 4, field: func(o *options) interface{} { return &o.chunkMB }, usage: "Block I/O (MB)"},
+	{name: "read-ahead", topic: "performance", def: 2, field: func(o *options) interface{} { return &o.readAhead }, usage: "Số block -chunk đọc trước song song với ghi (2: double buffering, 1: tuần tự)"},
+	{name: "max-read-mbps", topic: "performance", def: 0.0, field: func(o *options) interface{} { return &o.readMBps }, usage: "Giới hạn tốc độ đọc nguồn, MB/s (0: không giới hạn)"},
+	{name: "max-write-mbps", topic: "performance", def: 0.0, field: func(o *options) interface{} { return &o.writeMBps }, usage: "Giới hạn tốc độ ghi output, MB/s (0: không giới hạn)"},
+	{name: "nice", topic: "performance", def: false, field: func(o *options) interface{} { return &o.nice }, usage: "Chạy ưu tiên thấp: nice 19 + ionice idle (Linux)"},
+}
+
+// defineFlags registers every merge flag of optionModel on a new FlagSet
+// bound to opt.
+func defineFlags(opt *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ExitOnError)
+	fs.Usage = func() { printUsage(fs.Output()) }
+	for _, s := range optionModel {
+		var p interface{}
+		if s.field != nil { p = s.field(opt) }
+		switch p := p.(type) {
+		case nil: fs.String(s.name, s.def.(string), s.usage)
+		case *string: fs.StringVar(p, s.name, s.def.(string), s.usage)
+		case *bool: fs.BoolVar(p, s.name, s.def.(bool), s.usage)
+		case *int: fs.IntVar(p, s.name, s.def.(int), s.usage)
+		case *float64: fs.Float64Var(p, s.name, s.def.(float64), s.usage)
+		case *time.Duration: fs.DurationVar(p, s.name, s.def.(time.Duration), s.usage)
+		case *[]string:
+			if d, _ := s.def.(string); d != "" { *p = splitList(d) }
+			fs.Var((*listFlag)(p), s.name, s.usage)
+		default:
+			panic("optionModel: kiểu không hỗ trợ cho -" + s.name)
+		}
+	}
+	return fs
+}
+
+func optionSpec(name string) *optSpec {
+	for i := range optionModel {
+		if optionModel[i].name == name { return &optionModel[i] }
+	}
+	return nil
+}
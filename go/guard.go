@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Defensive checks on source archives. archive/zip and archive/tar already
+// reject most broken input; these add limits a long unattended run needs
+// (entry counts, names, compression ratios) and turn panics and decoder
+// errors into one error class, reported as warning category "malformed".
+
+const (
+	maxSourceEntries = 1 << 22 // per source archive
+	maxEntryName     = 4096
+	maxRatio         = 1000    // uncompressed/compressed, for entries above ratioMinSize
+	ratioMinSize     = 1 << 26 // 64 MiB
+)
+
+var errMalformed = errors.New("archive hỏng")
+
+func malformed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errMalformed, fmt.Sprintf(format, args...))
+}
+
+// warnCategory files decoder/format errors under warnMalformed.
+func warnCategory(err error, fallback string) string {
+	var corrupt flate.CorruptInputError
+	switch {
+	case errors.Is(err, errMalformed), errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrChecksum),
+		errors.Is(err, zip.ErrAlgorithm), errors.Is(err, tar.ErrHeader), errors.As(err, &corrupt),
+		errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return warnMalformed
+	}
+	return fallback
+}
+
+// checkZipReader validates a zip's directory as a whole against its size.
+func checkZipReader(zr *zip.Reader, size int64) error {
+	if len(zr.File) > maxSourceEntries { return malformed("%d entries (giới hạn %d)", len(zr.File), maxSourceEntries) }
+	for _, f := range zr.File {
+		if f.CompressedSize64 > uint64(size) {
+			return malformed("entry '%s' khai báo %d bytes nén, lớn hơn cả archive (%d)", cleanName(f.Name), f.CompressedSize64, size)
+		}
+	}
+	return nil
+}
+
+// checkEntry reports why one entry must not be copied, or nil.
+func checkEntry(e *sourceEntry) error {
+	switch {
+	case len(e.Name) > maxEntryName:
+		return malformed("tên entry dài %d bytes (giới hạn %d)", len(e.Name), maxEntryName)
+	case strings.ContainsRune(e.Name, 0):
+		return malformed("tên entry chứa NUL: %q", cleanName(e.Name))
+	case hasDotDot(e.Name):
+		return malformed("tên entry thoát ra ngoài thư mục gốc: %q", e.Name)
+	case !e.IsDir && e.Size > ratioMinSize && e.CompressedSize > 0 && e.Size/e.CompressedSize > maxRatio:
+		return malformed("'%s' nén %d:1 (%s -> %s), nghi zip bomb", e.Name, e.Size/e.CompressedSize,
+			humanBytes(e.CompressedSize), humanBytes(e.Size))
+	}
+	return nil
+}
+
+func hasDotDot(name string) bool {
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." { return true }
+	}
+	return false
+}
+
+func cleanName(s string) string {
+	if len(s) > 80 { s = s[:80] + "..." }
+	return strings.ToValidUTF8(strings.ReplaceAll(s, "\x00", `\0`), "?")
+}
+
+// guardedSource converts panics inside a source reader into errMalformed.
+type guardedSource struct{ archiveReader }
+
+func (g guardedSource) next() (e *sourceEntry, err error) {
+	defer func() {
+		if r := recover(); r != nil { e, err = nil, malformed("panic khi đọc header: %v", r) }
+	}()
+	e, err = g.archiveReader.next()
+	if err != nil || e == nil { return e, err }
+	e.invalid = checkEntry(e)
+	if open := e.open; open != nil {
+		e.open = func() (rc io.ReadCloser, err error) {
+			defer func() {
+				if r := recover(); r != nil { rc, err = nil, malformed("panic khi mở entry: %v", r) }
+			}()
+			rc, err = open()
+			if err != nil { return nil, err }
+			return guardedReader{rc}, nil
+		}
+	}
+	return e, nil
+}
+
+type guardedReader struct{ io.ReadCloser }
+
+func (g guardedReader) Read(p []byte) (n int, err error) {
+	defer func() {
+		if r := recover(); r != nil { n, err = 0, malformed("panic khi giải nén: %v", r) }
+	}()
+	return g.ReadCloser.Read(p)
+}
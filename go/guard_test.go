@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fuzzers check that no input, however broken, gets past the guards as
+// a panic; errors are fine. Plain `go test` runs the seeds only.
+
+// fixtureZips returns the gen-fixtures archives: two good ones, then a
+// truncated and a bad-CRC one.
+func fixtureZips(f *testing.F) [][]byte {
+	dir := f.TempDir()
+	if err := genFixtures(fixtureSpec{dir: dir, zips: 2, entries: 6, size: 512, dup: 0.3, broken: 2, encrypted: 1, weird: 1, seed: 3}); err != nil { f.Fatal(err) }
+	var out [][]byte
+	for i := 1; i <= 4; i++ {
+		b, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("part-%d.zip", i)))
+		if err != nil { f.Fatal(err) }
+		out = append(out, b)
+	}
+	return out
+}
+
+// drain reads every entry the way a merge does, capped per entry so a
+// header that lies about its size cannot stall the fuzzer.
+func drain(path string) {
+	ar, err := openSource(options{srcEncoding: encAuto}, path)
+	if err != nil { return }
+	defer ar.close()
+	for i := 0; i < 1000; i++ {
+		e, err := ar.next()
+		if err != nil { return }
+		if e.invalid != nil || e.open == nil { continue }
+		rc, err := e.open()
+		if err != nil { continue }
+		_, _ = io.CopyN(io.Discard, rc, 1<<20)
+		_ = rc.Close()
+	}
+}
+
+func writeSource(t *testing.T, name string, b []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, b, 0o644); err != nil { t.Fatal(err) }
+	return path
+}
+
+func FuzzOpenZip(f *testing.F) {
+	for _, b := range fixtureZips(f) {
+		f.Add(b)
+		f.Add(b[:len(b)/2])
+		f.Add(corruptFirstEntry(b))
+	}
+	f.Add([]byte("PK\x05\x06"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		zr, err := openZipReader(bytes.NewReader(b), int64(len(b)))
+		if err == nil { _ = checkZipReader(zr, int64(len(b))) }
+		drain(writeSource(t, "fuzz.zip", b))
+	})
+}
+
+// tarOf repacks a zip's entries as a tarball, gzipped if gz is set.
+func tarOf(f *testing.F, zipData []byte, gz bool) []byte {
+	zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil { f.Fatal(err) }
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if gz { zw = gzip.NewWriter(&buf); w = zw }
+	tw := tar.NewWriter(w)
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil { f.Fatal(err) }
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil { continue } // the encrypted fixture entry
+		if err := tw.WriteHeader(&tar.Header{Name: zf.Name, Mode: 0o644, Size: int64(len(data)), ModTime: zf.Modified}); err != nil { f.Fatal(err) }
+		if _, err := tw.Write(data); err != nil { f.Fatal(err) }
+	}
+	if err := tw.Close(); err != nil { f.Fatal(err) }
+	if zw != nil {
+		if err := zw.Close(); err != nil { f.Fatal(err) }
+	}
+	return buf.Bytes()
+}
+
+// FuzzTarSource covers the in-process readers, plain tar and gzip; the
+// other compressors run as external tools.
+func FuzzTarSource(f *testing.F) {
+	zips := fixtureZips(f)
+	for _, gz := range []bool{false, true} {
+		b := tarOf(f, zips[0], gz)
+		f.Add(b, gz)
+		f.Add(b[:len(b)*2/3], gz)
+		f.Add(zips[2], gz) // the truncated zip, as garbage
+	}
+	f.Fuzz(func(t *testing.T, b []byte, gz bool) {
+		name := "fuzz.tar"
+		if gz { name += ".gz" }
+		drain(writeSource(t, name, b))
+	})
+}
+
+const sevenZipListing = "7-Zip [64] 16.02 : Copyright (c) 1999-2016 Igor Pavlov : 2016-05-21\n\n" +
+	"Listing archive: a.7z\n\n--\nPath = a.7z\nType = 7z\nPhysical Size = 312\n\n----------\n" +
+	"Path = docs\nSize = 0\nModified = 2024-01-01 00:00:00\nAttributes = D\nFolder = +\n\n" +
+	"Path = docs\\readme.txt\nSize = 1234\nModified = 2024-01-01 12:30:00\nCRC = 3610A686\nEncrypted = -\n\n"
+
+func FuzzSevenZipListing(f *testing.F) {
+	f.Add([]byte(sevenZipListing))
+	f.Add(bytes.ReplaceAll([]byte(sevenZipListing), []byte("\n"), []byte("\r\n")))
+	f.Add([]byte(sevenZipListing[:len(sevenZipListing)/2]))
+	f.Add(bytes.Replace([]byte(sevenZipListing), []byte("Size = 1234"), []byte("Size = -1"), 1))
+	f.Add(bytes.Replace([]byte(sevenZipListing), []byte("Encrypted = -"), []byte("Encrypted = +"), 1))
+	f.Add([]byte("----------\nPath = " + string(bytes.Repeat([]byte("x"), maxEntryName+2048)) + "\n"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		entries, err := parseSevenZipListing(b)
+		if err != nil { return }
+		for _, e := range entries {
+			if e.Name == "" { t.Fatal("entry without a name") }
+			if e.Size != e.CompressedSize { t.Fatalf("%q: size %d, compressed %d", e.Name, e.Size, e.CompressedSize) }
+		}
+	})
+}
+
+func TestSevenZipListing(t *testing.T) {
+	entries, err := parseSevenZipListing([]byte(sevenZipListing))
+	if err != nil { t.Fatal(err) }
+	if len(entries) != 2 || !entries[0].IsDir || entries[1].Name != "docs/readme.txt" || entries[1].Size != 1234 || entries[1].CRC32 != 0x3610A686 {
+		t.Fatalf("parsed %+v", entries)
+	}
+}
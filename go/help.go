@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// -help, `help [topic|subcommand]` and `help man` all render optionModel
+// (flags.go) plus the topic and subcommand tables below.
+
+type helpTopic struct {
+	name, title string
+	text        []string // paragraphs
+}
+
+var helpTopics = []helpTopic{
+	{"sources", "Chọn nguồn", []string{
+		"Nguồn là các archive trong -input khớp -filter: .zip, .7z (cần lệnh 7z) và tarball (.tar, .tar.gz/.tgz, .tar.zst/.tzst, .tar.xz/.txz), cộng các URL -remote đọc bằng HTTP Range.",
+		"Thứ tự gộp theo -sort (natural: part-2 trước part-10). Nguồn đổi size/mtime giữa pre-scan và lúc gộp được xử lý theo -on-changed; -min-age bỏ qua file còn đang được ghi.",
+		"-priority GLOB,... đưa nguồn khớp (theo tên, hoặc chứa entry khớp) lên trước, và chép entry khớp trước trong mỗi zip nguồn.",
+		"Tên entry zip không có cờ UTF-8 được đoán bảng mã theo từng archive (cp437, cp1258, shift-jis, gbk; hai bảng sau qua lệnh iconv) và chuyển sang UTF-8; đoán không chắc thì giữ nguyên byte. -src-encoding chỉ định hẳn một bảng mã cho mọi nguồn.",
+	}},
+	{"filters", "Lọc entry", []string{
+		"Các bộ lọc áp dụng cho từng entry, giống nhau ở merge, plan, list và extract. __MACOSX/ và .DS_Store luôn bị bỏ.",
+	}},
+	{"output", "Output", []string{
+		"Output là <outdir>/<out>.<định dạng>, được ghi qua file tạm rồi rename. -out - ghi ra stdout; -out trỏ tới FIFO/thiết bị có sẵn thì ghi thẳng vào đó.",
+		"File đã nén sẵn (-store-ext, -store-entropy) được lưu không nén. -checksum và -manifest được tính ngay khi ghi, không đọc lại output.",
+		"-sign-key KEY.pem băm sha256 từng entry khi ghi và thêm vào cuối zip " + signManifestName + " cùng chữ ký ed25519 " + signatureName + "; `verify-signature -key PUB.pem` kiểm tra chữ ký, nội dung từng entry và entry bị thêm/bớt.",
+	}},
+	{"conflicts", "Trùng tên & flag xung đột", []string{
+		"Entry trùng đường dẫn với entry đã ghi được đổi tên thành <tên>__dupN<đuôi> (N từ 2); extract -on-existing rename và -append dùng cùng quy tắc. Thư mục (-keep-dirs) không bao giờ bị đổi tên. -prefix-by-zip lồng mỗi nguồn vào <zip>/ nên các nguồn không đè tên nhau; -collect-meta tách file metadata ra " + metadataDir + "/<zip>/.",
+		"-jar-mode first|merge cho nguồn .jar/.war/.ear: mỗi file META-INF/services/* là nội dung nối của file đó từ mọi nguồn; MANIFEST.MF lấy của nguồn đầu (first) hoặc thêm thuộc tính và mục Name: còn thiếu từ các nguồn sau (merge); file chữ ký (*.SF, *.RSA, *.DSA, *.EC) bị bỏ. Các file này được ghi đầu tiên trong output.",
+		"Các tổ hợp bị từ chối (exit 2): -out - với -append, -split, -quota/-prune, -checksum, -watch hay -idempotency-key; -append hoặc -watch với định dạng khác zip; -append với -spool-dir, -max-entries/-max-output-bytes hay -block-size; -watch với -split; -keep-comments/-comment với tar; -jar-mode với -append/-watch, -prefix-by-zip hay định dạng khác zip; -v với -q.",
+	}},
+	{"split", "Split", []string{
+		"-split cắt output theo byte (raw) thành <out>.<đuôi>.part-000, .part-001...; ghép lại bằng cat hoặc `join`. -split-meta gắn trailer để `join` kiểm tra thứ tự và sha256; -split-verify đọc lại từng part trước khi sang part kế.",
+	}},
+	{"upload", "Upload", []string{
+		"-upload gửi output đã xong lên https:// (PUT) hoặc s3:// (ký SigV4 bằng AWS_* trong môi trường; AWS_ENDPOINT_URL cho MinIO...). Với -split chỉ các part được gửi; file checksum và manifest được gửi sau cùng.",
+		"Mặc định số PUT song song tự điều chỉnh: bắt đầu từ 2, tăng thêm một khi một lượt upload ở mức hiện tại cho thông lượng cao hơn rõ rệt, giảm khi thông lượng tụt, và giảm một nửa mỗi lần gặp lỗi mạng, 5xx hay 429. Mỗi file được thử lại tối đa 4 lần. -upload-concurrency N cố định số luồng.",
+		"-upload-delta (s3://) giữ chữ ký khối của lần upload trước trong <outdir>/" + deltaDir + "/ và dò lại các khối đó trong output mới bằng rolling hash (kể cả khi bị dịch chỗ); object mới được ghép bằng multipart: đoạn giống được UploadPartCopy từ object cũ ngay trên server, chỉ đoạn khác được gửi. Object trên s3 đã bị thay bởi ai khác thì gửi toàn bộ.",
+	}},
+	{"limits", "Giới hạn dung lượng", []string{
+		"Kích thước (-split, -max-output-bytes, -quota, -min-free, -min-size/-max-size, -block-size, -checkpoint) là số, có thể lẻ (1.5g), kèm đơn vị: không có hoặc b = byte; k/m/g/t và KiB/MiB/GiB/TiB là nhị phân (1g = 1024³); KB/MB/GB/TB là thập phân (2GB = 2000000000). Phần lẻ làm tròn xuống byte.",
+		"-max-entries/-max-output-bytes giới hạn từng file output, -on-overflow quyết định khi vượt. -quota giới hạn cả thư mục output; -prune xoá output cũ nhất (giữ -keep bản) để vừa quota hoặc dung lượng trống.",
+		"Mọi file bị xoá vì flag (-prune, -rm-after-split) có thể xem trước bằng -simulate-deletes: chỉ log, không xoá, phần còn lại chạy như thật. Lần đầu chạy tương tác với flag xoá trong một thư mục output sẽ phải xác nhận; câu trả lời được nhớ trong <outdir>/" + deleteConsentFile + ".",
+		"-min-free giữ lại dung lượng trống trên volume output, kiểm tra trước mỗi entry; sắp chạm ngưỡng thì -on-low-disk: wait (chờ, tối đa -low-disk-wait), rollover (sang <out>-N trong -overflow-dir) hoặc finish (đóng output hợp lệ, liệt kê nguồn còn thiếu). Khi đó pre-check chỉ cảnh báo.",
+		"-zip-timeout bỏ phần còn lại của một nguồn chạy quá lâu (WARNING timeout) rồi sang nguồn kế; -deadline hết hạn thì không bắt đầu nguồn nào nữa, đóng output hợp lệ và liệt kê nguồn còn thiếu.",
+	}},
+	{"errors", "Lỗi, warning & chạy lại", []string{
+		"Nguồn hoặc entry không đọc được chỉ là WARNING (exit 4 khi xong) trừ khi -strict hay vượt -max-warnings. Exit code: 0 ok, 1 lỗi, 2 sai tham số, 3 split lỗi (output gộp vẫn còn), 4 có warning, 5 vượt -max-entries/-max-output-bytes, 6 plan khác -compare, 7 job cùng -idempotency-key đang chạy, 8 thiếu dung lượng, 130 bị huỷ.",
+		"Lỗi ghi giữa một entry của output zip (file thường) chỉ bỏ entry đó (WARNING write) và chạy tiếp; 3 lần liên tiếp thì dừng.",
+		"-secret-scan report|block quét entry text tìm AWS key, private key và chuỗi entropy cao: report chỉ WARNING secret, block dừng run và xoá output mới (-append: bỏ entry đó).",
+	}},
+	{"watch", "Watch mode", []string{
+		"-watch quét -input mỗi -watch-interval và -append từng nguồn mới vào output khi nó đã giữ nguyên -stable-for.",
+	}},
+	{"logging", "Log & tiến độ", []string{
+		"Log người đọc ra stdout (stderr khi stdout mang dữ liệu); -log-file ghi thêm JSON lines. Mọi dòng mang -job-id.",
+	}},
+	{"performance", "Hiệu năng", []string{
+		"Mỗi entry được chép theo block -chunk, đọc trước -read-ahead block. -max-read-mbps/-max-write-mbps và -nice giữ cho job nền không chiếm máy.",
+	}},
+	{"config", "File cấu hình", []string{
+		"Thứ tự ưu tiên: flag > biến môi trường MERGEZIP_<FLAG> (chữ hoa, - thành _) > profile > recipe > file cấu hình > mặc định. File YAML có key là tên flag và mục profiles: <tên>: {...}. `config show` in giá trị cuối cùng kèm nguồn của nó.",
+		"Mục recipes: <tên>: {...} đặt tên cho một nhóm flag (lọc, nén, xử lý trùng, output...). -recipe a,b ghép các recipe theo thứ tự: flag kiểu list được cộng dồn, flag khác mà hai recipe đặt khác nhau là lỗi. -recipe cũng đặt được trong file hoặc profile.",
+	}},
+}
+
+type subcommandHelp struct {
+	name, synopsis, text string
+}
+
+var subcommandModel = []subcommandHelp{
+	{"plan", "plan [-o FILE] [-compare FILE] [flags]", "Ghi kế hoạch merge (JSON) mà không đọc dữ liệu; -compare so với kế hoạch trước, exit 6 nếu khác."},
+	{"list", "list [-json] [-top N] [flags]", "Thống kê từng nguồn, thư mục cấp 1, đường dẫn trùng và file lớn nhất; chỉ đọc header."},
+	{"extract", "extract -dest DIR [-on-existing skip|overwrite|rename] [flags]", "Giải nén thẳng các nguồn vào thư mục, cùng cách chọn nguồn, lọc và đặt tên như merge."},
+	{"verify-signature", "verify-signature -key PUB.pem ARCHIVE", "Kiểm tra chữ ký -sign-key nhúng trong zip và từng entry theo manifest đã ký."},
+	{"check", "check [-dir DIR] [-algo ALGO] SUMS", "Kiểm tra các file theo file checksum, như sha256sum -c."},
+	{"join", "join [-o FILE] PART...", "Ghép các part có trailer -split-meta, kiểm tra thứ tự và sha256."},
+	{"gen-fixtures", "gen-fixtures [-o DIR] [...]", "Sinh bộ zip thử nghiệm (trùng tên, zip hỏng, entry mã hoá...)."},
+	{"config", "config show [-o yaml|json] [flags]", "In giá trị cuối cùng của mọi flag và nơi nó được đặt."},
+	{"schema", "schema list | schema print [NAME]", "In JSON Schema (v1) của manifest, plan, list -json, config show, trailer split và -log-file."},
+	{"completion", "completion bash|zsh|fish", "In script completion cho shell."},
+	{"help", "help [TOPIC|SUBCOMMAND|man]", "Trợ giúp theo chủ đề; `help man` in man page (troff)."},
+}
+
+// runHelp implements `help [topic|subcommand|man]`.
+func runHelp(args []string) error {
+	if len(args) == 0 { printUsage(os.Stdout); return nil }
+	if len(args) > 1 { return fmt.Errorf("dùng: help [chủ đề|subcommand|man]") }
+	name := args[0]
+	if name == "man" { printMan(os.Stdout); return nil }
+	if name == "topics" { printTopicList(os.Stdout); return nil }
+	for _, t := range helpTopics {
+		if t.name == name { printTopic(os.Stdout, t); return nil }
+	}
+	if printSubcommand(os.Stdout, name) { return nil }
+	if s := optionSpec(strings.TrimLeft(name, "-")); s != nil {
+		for _, t := range helpTopics {
+			if t.name == s.topic { printTopic(os.Stdout, t); return nil }
+		}
+	}
+	return fmt.Errorf("không có chủ đề %q (xem: %s help topics)", name, progName())
+}
+
+// printSubcommand prints the synopsis and description of subcommand name;
+// false if there is none.
+func printSubcommand(w io.Writer, name string) bool {
+	for _, c := range subcommandModel {
+		if c.name == name { fmt.Fprintf(w, "%s %s\n\n%s\n", progName(), c.synopsis, wrap(c.text, 78, "")); return true }
+	}
+	return false
+}
+
+func progName() string { return filepath.Base(os.Args[0]) }
+
+func printUsage(w io.Writer) {
+	prog := progName()
+	fmt.Fprintf(w, "Dùng: %s [flags]\n       %s <subcommand> [...]\n\nSubcommand:\n", prog, prog)
+	for _, c := range subcommandModel { fmt.Fprintf(w, "  %-16s %s\n", c.name, c.text) }
+	for _, t := range helpTopics {
+		fmt.Fprintf(w, "\n%s (help %s):\n", t.title, t.name)
+		printFlags(w, t.name)
+	}
+	fmt.Fprintf(w, "\nChi tiết: %s help <chủ đề>, %s help man\n", prog, prog)
+}
+
+func printTopicList(w io.Writer) {
+	for _, t := range helpTopics { fmt.Fprintf(w, "  %-12s %s\n", t.name, t.title) }
+}
+
+func printTopic(w io.Writer, t helpTopic) {
+	fmt.Fprintf(w, "%s\n\n", t.title)
+	for _, p := range t.text { fmt.Fprintf(w, "%s\n\n", wrap(p, 78, "")) }
+	printFlags(w, t.name)
+}
+
+func printFlags(w io.Writer, topic string) {
+	fs := defineFlags(&options{})
+	for _, s := range optionModel {
+		if s.topic != topic { continue }
+		fmt.Fprintf(w, "  %s\n%s\n", flagSynopsis(fs.Lookup(s.name), s), wrap(s.usage, 72, "      "))
+	}
+}
+
+// flagSynopsis renders "-name type (default x)" the way flag.PrintDefaults
+// names the value type.
+func flagSynopsis(f *flag.Flag, s optSpec) string {
+	typ, _ := flag.UnquoteUsage(f)
+	if _, ok := f.Value.(*listFlag); ok { typ = "list" }
+	if len(s.values) > 0 && len(s.values) <= 5 { typ = strings.Join(s.values, "|") }
+	out := "-" + f.Name
+	if typ != "" { out += " " + typ }
+	if d := f.DefValue; d != "" && d != "false" && d != "0" && d != "0s" && d != "[]" {
+		out += fmt.Sprintf(" (mặc định %s)", d)
+	}
+	return out
+}
+
+// wrap fills text to width, each line prefixed with indent.
+func wrap(text string, width int, indent string) string {
+	var b strings.Builder
+	line := indent
+	for _, word := range strings.Fields(text) {
+		if len([]rune(line)) > len(indent) && len([]rune(line))+1+len([]rune(word)) > width {
+			b.WriteString(line + "\n")
+			line = indent
+		}
+		if len(line) > len(indent) { line += " " }
+		line += word
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// printMan writes a man(7) page.
+func printMan(w io.Writer) {
+	prog := progName()
+	fmt.Fprintf(w, ".TH %s 1 %q\n", strings.ToUpper(roffEscape(prog)), time.Now().Format("2006-01-02"))
+	fmt.Fprintf(w, ".SH NAME\n%s \\- gộp nhiều archive thành một zip/tar\n", roffEscape(prog))
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B %s\n[\\fIflags\\fR]\n.br\n", roffEscape(prog))
+	for _, c := range subcommandModel { fmt.Fprintf(w, ".B %s\n%s\n.br\n", roffEscape(prog), roffEscape(c.synopsis)) }
+	fmt.Fprintln(w, ".SH SUBCOMMANDS")
+	for _, c := range subcommandModel { fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roffEscape(c.name), roffEscape(c.text)) }
+	fs := defineFlags(&options{})
+	for _, t := range helpTopics {
+		fmt.Fprintf(w, ".SH %s\n", roffEscape(strings.ToUpper(t.title)))
+		for _, p := range t.text { fmt.Fprintf(w, ".PP\n%s\n", roffEscape(p)) }
+		for _, s := range optionModel {
+			if s.topic != t.name { continue }
+			fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roffEscape(flagSynopsis(fs.Lookup(s.name), s)), roffEscape(s.usage))
+		}
+	}
+	fmt.Fprintf(w, ".SH ENVIRONMENT\nMọi flag đọc được từ \\fBMERGEZIP_<FLAG>\\fR, vd %s.\n", roffEscape(envName("max-warnings")))
+}
+
+// roffEscape escapes backslashes and lines starting with a control
+// character.
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") { s = `\&` + s }
+	return s
+}
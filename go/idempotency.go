@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// -idempotency-key lets a scheduler or wrapper script retry a submission
+// without starting the same merge twice. The first run with a key records
+// itself in <outdir>/.mergezip-jobs/; a retry with the same key reports that
+// job instead: its outputs once it is done, exitRunning while its process is
+// still alive. Failed, cancelled and crashed runs may be retried.
+
+const jobsDir = ".mergezip-jobs"
+
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+var errJobRunning = errors.New("job cùng -idempotency-key đang chạy")
+
+type jobRecord struct {
+	Key      string     `json:"key"`
+	Job      string     `json:"job"`
+	Settings string     `json:"settings"` // hash of the resolved flags, see settingsHash
+	Status   string     `json:"status"`
+	Host     string     `json:"host"`
+	PID      int        `json:"pid"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Outputs  []string   `json:"outputs,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// jobClaim is held by the run that owns a key; nil without -idempotency-key.
+type jobClaim struct {
+	path string
+	rec  jobRecord
+}
+
+// claimJob registers this run under opt.idemKey. prev is set (and claim nil)
+// when an earlier run with the key already finished.
+func claimJob(opt options) (claim *jobClaim, prev *jobRecord, err error) {
+	if opt.idemKey == "" { return nil, nil, nil }
+	switch {
+	case opt.toStdout, opt.outDevice != "": return nil, nil, errors.New("-idempotency-key cần output là file (không dùng với -out - hay FIFO/thiết bị)")
+	case opt.watch: return nil, nil, errors.New("-idempotency-key không dùng chung được với -watch")
+	}
+	dir := filepath.Join(opt.outDir, jobsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil { return nil, nil, err }
+	sum := sha256.Sum256([]byte(opt.idemKey))
+	path := filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+	host, _ := os.Hostname()
+	rec := jobRecord{
+		Key: opt.idemKey, Job: opt.jobID, Settings: settingsHash(opt), Status: jobRunning,
+		Host: host, PID: os.Getpid(), Started: time.Now(),
+	}
+
+	c := &jobClaim{path: path, rec: rec}
+	created, err := c.create()
+	if err != nil { return nil, nil, err }
+	if !created {
+		b, err := os.ReadFile(path)
+		if err != nil { return nil, nil, err }
+		var old jobRecord
+		if err := json.Unmarshal(b, &old); err != nil { return nil, nil, fmt.Errorf("%s hỏng: %v (xoá file để chạy lại)", path, err) }
+		if old.Settings != rec.Settings {
+			return nil, nil, fmt.Errorf("-idempotency-key %q đã dùng cho job %s với tham số khác", opt.idemKey, old.Job)
+		}
+		switch {
+		case old.Status == jobDone:
+			return nil, &old, nil
+		case old.Status == jobRunning && (old.Host != host || processAlive(old.PID)):
+			return nil, nil, fmt.Errorf("%w: job %s (pid %d trên %s, từ %s); nếu chắc chắn nó đã chết, xoá %s",
+				errJobRunning, old.Job, old.PID, old.Host, old.Started.Format(time.RFC3339), path)
+		}
+		logf("Idempotency: job %s trước đó %s, chạy lại", old.Job, old.Status)
+		if err := c.save(); err != nil { return nil, nil, err }
+	}
+	return c, nil, nil
+}
+
+// create writes the record only if none exists yet. It is linked into place
+// complete, so two first submissions racing for a key see either nothing or
+// the winner's full record.
+func (c *jobClaim) create() (bool, error) {
+	tmp, err := c.writeTemp()
+	if err != nil { return false, err }
+	defer os.Remove(tmp)
+	err = os.Link(tmp, c.path)
+	if os.IsExist(err) { return false, nil }
+	return err == nil, err
+}
+
+// finish records the outcome; a nil claim is a no-op.
+func (c *jobClaim) finish(outputs []string, runErr error) {
+	if c == nil { return }
+	now := time.Now()
+	c.rec.Finished, c.rec.Outputs, c.rec.Status = &now, outputs, jobDone
+	if runErr != nil { c.rec.Status, c.rec.Error = jobFailed, runErr.Error() }
+	if err := c.save(); err != nil { errorf("WARNING: không ghi được trạng thái job %s: %v", c.path, err) }
+}
+
+func (c *jobClaim) save() error {
+	tmp, err := c.writeTemp()
+	if err != nil { return err }
+	if err := os.Rename(tmp, c.path); err != nil { _ = os.Remove(tmp); return err }
+	return nil
+}
+
+func (c *jobClaim) writeTemp() (string, error) {
+	b, err := json.MarshalIndent(c.rec, "", "  ")
+	if err != nil { return "", err }
+	f, err := os.CreateTemp(filepath.Dir(c.path), ".job-*")
+	if err != nil { return "", err }
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil { err = cerr }
+	if err != nil { _ = os.Remove(f.Name()); return "", err }
+	return f.Name(), nil
+}
+
+// reportJob answers a retry of a finished job.
+func reportJob(rec *jobRecord) {
+	logf("Idempotency: -idempotency-key %q đã xong ở job %s (%s), không gộp lại", rec.Key, rec.Job, rec.Finished.Format(time.RFC3339))
+	for _, o := range rec.Outputs { logf("Output: %s", o) }
+	record(slog.LevelInfo, "job-reused", "key", rec.Key, "previous", rec.Job, "outputs", rec.Outputs)
+}
+
+// settingsHash fingerprints the resolved flags, so a key reused for a
+// different merge is refused. Flags that only change logging or naming of
+// the run are left out: a retry may well add -v.
+func settingsHash(opt options) string {
+	h := sha256.New()
+	opt.settings.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "idempotency-key", "job-id", "v", "q", "log-file", "progress", "summary-interval":
+			return
+		}
+		fmt.Fprintf(h, "%s=%s\x00", f.Name, f.Value.String())
+	})
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil { return false }
+	if runtime.GOOS == "windows" { return true } // FindProcess already opened it
+	return p.Signal(syscall.Signal(0)) == nil
+}
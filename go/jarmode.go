@@ -0,0 +1,259 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// -jar-mode makes a merge of .jar/.war/.ear sources a working Java artifact.
+// Plain path-collision handling keeps one META-INF/MANIFEST.MF and renames
+// the others (MANIFEST__dup2.MF), and does the same to the provider lists
+// under META-INF/services/, so all but one jar's services are lost. With
+// -jar-mode each services file is the concatenation of that file from every
+// source, in merge order, and MANIFEST.MF is the first source's (first) or
+// the first source's with the attributes and Name: sections of the others
+// added where they are new (merge; the first value of an attribute wins).
+// Signature files (META-INF/*.SF, *.RSA, *.DSA, *.EC, SIG-*) are dropped:
+// the merged jar no longer matches them.
+//
+// These files are read in a pass before the copy and written as the first
+// entries of the output, where JarInputStream expects the manifest. A source
+// later left out (-deadline, -zip-timeout) has still added its lines.
+
+const (
+	jarOff   = "off"
+	jarFirst = "first"
+	jarMerge = "merge"
+
+	jarFilter       = "*.[jwe]ar" // -filter when -jar-mode leaves it at its default
+	jarManifestPath = "META-INF/MANIFEST.MF"
+	jarServicesDir  = "META-INF/services/"
+	jarMaxHeld      = 1 << 20 // a manifest or services file larger than this is not one
+)
+
+const (
+	jarOther = iota
+	jarManifest
+	jarService
+	jarSignature
+)
+
+func validateJarMode(opt *options) error {
+	switch opt.jarMode {
+	case jarOff: return nil
+	case jarFirst, jarMerge:
+	default: return fmt.Errorf("-jar-mode không hợp lệ: %q (off|first|merge)", opt.jarMode)
+	}
+	switch {
+	case opt.format != "zip": return errors.New("-jar-mode chỉ hỗ trợ -format zip")
+	case opt.appendOut: return errors.New("-jar-mode không dùng chung được với -append/-watch")
+	case opt.prefixByZip: return errors.New("-jar-mode không dùng chung được với -prefix-by-zip")
+	}
+	if opt.sources["filter"] == srcDefault {
+		opt.filterGlob = jarFilter
+		opt.sources["filter"] = srcDerived
+	}
+	return nil
+}
+
+// jarKind tells which META-INF files -jar-mode handles itself.
+func jarKind(name string) int {
+	name = strings.TrimLeft(name, "/")
+	dir, base := path.Split(name)
+	switch {
+	case strings.EqualFold(name, jarManifestPath): return jarManifest
+	case strings.EqualFold(dir, jarServicesDir) && base != "": return jarService
+	case strings.EqualFold(dir, "META-INF/"):
+		upper := strings.ToUpper(base)
+		if strings.HasPrefix(upper, "SIG-") { return jarSignature }
+		switch path.Ext(upper) {
+		case ".SF", ".RSA", ".DSA", ".EC": return jarSignature
+		}
+	}
+	return jarOther
+}
+
+// heldName is the output name of a MANIFEST.MF or services file: one file
+// whatever case the source gives the directory.
+func heldName(name string) string {
+	if jarKind(name) == jarManifest { return jarManifestPath }
+	return jarServicesDir + path.Base(name)
+}
+
+type heldFile struct {
+	body     []byte
+	modified time.Time
+}
+
+// jarMeta holds the merged MANIFEST.MF and services files.
+type jarMeta struct {
+	manifest   *heldFile
+	manifests  int // sources that had one
+	services   map[string]*heldFile
+	order      []string // services in the order first seen
+	signatures int      // dropped by the copy loop
+}
+
+// collectJarMeta reads the manifests and services files of the sources, in
+// merge order. Sources that cannot be read are left to the merge loop, which
+// warns about them.
+func collectJarMeta(ctx context.Context, opt options, wl *warnLog, names, paths []string, order []int, skip []bool) (*jarMeta, error) {
+	m := &jarMeta{services: map[string]*heldFile{}}
+	for _, idx := range order {
+		if skip[idx] { continue }
+		if ctx.Err() != nil { return nil, canceled() }
+		ar, err := openSource(opt, paths[idx])
+		if err != nil { continue }
+		err = m.addSource(opt, wl, names[idx], ar)
+		_ = ar.close()
+		if err != nil { return nil, err }
+	}
+	return m, nil
+}
+
+func (m *jarMeta) addSource(opt options, wl *warnLog, source string, ar archiveReader) error {
+	for {
+		f, err := ar.next()
+		if err != nil { return nil } // io.EOF, or a listing error the merge loop reports
+		if selectEntry(opt, f) != entryJarHeld { continue }
+		body, err := readHeld(f)
+		if err != nil {
+			if err := wl.warn(warnCategory(err, warnRead), "-jar-mode: không đọc được '%s' trong %s: %v", f.Name, source, err); err != nil { return err }
+			continue
+		}
+		if jarKind(f.Name) == jarManifest {
+			m.manifests++
+			switch {
+			case m.manifest == nil: m.manifest = &heldFile{body, f.Modified}
+			case opt.jarMode == jarMerge: m.manifest.body = mergeManifest(m.manifest.body, body)
+			}
+			continue
+		}
+		name := heldName(f.Name)
+		h := m.services[name]
+		if h == nil {
+			h = &heldFile{modified: f.Modified}
+			m.services[name] = h
+			m.order = append(m.order, name)
+		}
+		if n := len(h.body); n > 0 && h.body[n-1] != '\n' { h.body = append(h.body, '\n') }
+		h.body = append(h.body, body...)
+	}
+}
+
+func readHeld(f *sourceEntry) ([]byte, error) {
+	rc, err := f.open()
+	if err != nil { return nil, err }
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, jarMaxHeld+1))
+	if err == nil && len(b) > jarMaxHeld { err = fmt.Errorf("lớn hơn %s", humanBytes(jarMaxHeld)) }
+	return b, err
+}
+
+// dropSignature counts and logs a signature file the copy loop leaves out.
+func (m *jarMeta) dropSignature(source string, f *sourceEntry) {
+	m.signatures++
+	logEvent("entry-skip", "  bỏ qua "+f.Name+" (chữ ký jar)", "source", source, "path", f.Name, "size", f.Size)
+}
+
+// write adds the held files as the first entries of out.
+func (m *jarMeta) write(opt options, out *outputFile, dedup map[string]int) error {
+	if m.manifest != nil {
+		if err := addHeld(opt, out, dedup, jarManifestPath, m.manifest); err != nil { return err }
+	}
+	for _, name := range m.order {
+		if err := addHeld(opt, out, dedup, name, m.services[name]); err != nil { return err }
+	}
+	logf("-jar-mode %s: MANIFEST.MF từ %d nguồn, %d file META-INF/services", opt.jarMode, m.manifests, len(m.order))
+	return nil
+}
+
+func addHeld(opt options, out *outputFile, dedup map[string]int, name string, h *heldFile) error {
+	dedup[name]++
+	if limit := out.overBudget(opt, uint64(len(h.body)), name, ""); limit != "" {
+		return fmt.Errorf("-jar-mode: không ghi được %s: vượt %s", name, limit)
+	}
+	method := zip.Deflate
+	if opt.store { method = zip.Store }
+	hdr := &zip.FileHeader{Name: name, Method: method, Modified: h.modified, UncompressedSize64: uint64(len(h.body))}
+	if hdr.Modified.IsZero() { hdr.Modified = time.Now() }
+	w, err := out.create(hdr)
+	if err != nil { return err }
+	out.added(opt, name, "")
+	_, err = w.Write(h.body)
+	return err
+}
+
+// A manifest is sections separated by blank lines; the first is the main
+// section, the others start with "Name:". An attribute keeps its raw lines
+// (continuations start with a space), so nothing has to be re-wrapped at
+// 72 bytes.
+type mfAttr struct {
+	key string // lower case
+	raw string
+}
+
+type mfSection []mfAttr
+
+func (s mfSection) has(key string) bool {
+	for _, a := range s {
+		if a.key == key { return true }
+	}
+	return false
+}
+
+func parseManifest(b []byte) []mfSection {
+	text := strings.ReplaceAll(string(b), "\r\n", "\n")
+	var secs []mfSection
+	var cur mfSection
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", "\n"), "\n") {
+		switch {
+		case line == "":
+			if len(cur) > 0 { secs = append(secs, cur); cur = nil }
+		case line[0] == ' ' && len(cur) > 0:
+			cur[len(cur)-1].raw += "\r\n" + line
+		default:
+			key, _, _ := strings.Cut(line, ":")
+			cur = append(cur, mfAttr{strings.ToLower(strings.TrimSpace(key)), line})
+		}
+	}
+	if len(cur) > 0 { secs = append(secs, cur) }
+	if len(secs) > 0 && secs[0][0].key == "name" { secs = append([]mfSection{nil}, secs...) } // no main section
+	return secs
+}
+
+// mergeManifest adds to into what from has and into lacks: main attributes,
+// attributes of a Name: section both have, and whole Name: sections.
+func mergeManifest(into, from []byte) []byte {
+	a, b := parseManifest(into), parseManifest(from)
+	if len(a) == 0 { return from }
+	for i, sec := range b {
+		j := 0
+		if i > 0 {
+			if j = findSection(a, sec[0].raw); j < 0 { a = append(a, sec); continue }
+		}
+		for _, at := range sec {
+			if !a[j].has(at.key) { a[j] = append(a[j], at) }
+		}
+	}
+	var sb strings.Builder
+	for i, sec := range a {
+		if i == 0 && len(sec) == 0 { continue }
+		for _, at := range sec { sb.WriteString(at.raw + "\r\n") }
+		sb.WriteString("\r\n")
+	}
+	return []byte(sb.String())
+}
+
+func findSection(secs []mfSection, name string) int {
+	for i := 1; i < len(secs); i++ {
+		if len(secs[i]) > 0 && secs[i][0].raw == name { return i }
+	}
+	return -1
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// `list` is the look-before-you-merge overview: the merge's source
+// selection and entry filters, but only headers are read and nothing is
+// written. Paths are reported as the merge would name them (-prefix-by-zip,
+// -collect-meta) so the duplicate count is the number of __dupN renames
+// the merge would make.
+
+type listSourceStat struct {
+	Name         string `json:"name"`
+	Entries      int    `json:"entries"`
+	Compressed   uint64 `json:"compressed"`
+	Uncompressed uint64 `json:"uncompressed"`
+	Error        string `json:"error,omitempty"`
+}
+
+type listDirStat struct {
+	Dir          string `json:"dir"`
+	Entries      int    `json:"entries"`
+	Uncompressed uint64 `json:"uncompressed"`
+}
+
+type listDuplicate struct {
+	Path    string   `json:"path"`
+	Sources []string `json:"sources"`
+}
+
+type listFile struct {
+	Source string `json:"source"`
+	Path   string `json:"path"`
+	Size   uint64 `json:"size"`
+}
+
+type listReport struct {
+	Sources      []listSourceStat `json:"sources"`
+	Entries      int              `json:"entries"`
+	Compressed   uint64           `json:"compressed"`
+	Uncompressed uint64           `json:"uncompressed"`
+	TopDirs      []listDirStat    `json:"top_dirs"`
+	Duplicates   int              `json:"duplicate_paths"`  // paths that occur more than once
+	DupExtra     int              `json:"duplicate_copies"` // entries the merge would rename __dupN
+	DupSamples   []listDuplicate  `json:"duplicates,omitempty"`
+	Largest      []listFile       `json:"largest"`
+}
+
+// runList implements `list [-json] [-top N] [merge flags...]`.
+func runList(args []string) error {
+	asJSON, args := takeFlag(args, "json")
+	topArg, args, err := takeArg(args, "top")
+	if err != nil { return err }
+	top := 10
+	if topArg != "" {
+		if top, err = strconv.Atoi(topArg); err != nil || top < 0 { return fmt.Errorf("-top không hợp lệ: %q", topArg) }
+	}
+	logOut = os.Stderr // stdout carries the report
+	opt, err := parseFlags(args)
+	if err != nil { return err }
+	jobID = opt.jobID
+
+	r, err := buildList(opt, top)
+	if err != nil { return err }
+	if asJSON {
+		body, err := json.MarshalIndent(r, "", "  ")
+		if err != nil { return err }
+		fmt.Println(string(body))
+		return nil
+	}
+	printList(r)
+	return nil
+}
+
+func buildList(opt options, top int) (*listReport, error) {
+	names, paths, err := collectSources(opt)
+	if err != nil { return nil, err }
+	names, paths = filterMinAge(opt, names, paths)
+	if len(names) == 0 { return nil, fmt.Errorf("không tìm thấy nguồn khớp '%s' trong %s", opt.filterGlob, opt.inputDir) }
+
+	r := &listReport{}
+	dirs := map[string]*listDirStat{}
+	seen := map[string][]string{} // mapped path -> sources, in order
+	for i, name := range names {
+		st := listSourceStat{Name: name}
+		entries, err := listSource(opt, paths[i])
+		if err != nil {
+			st.Error = err.Error()
+			r.Sources = append(r.Sources, st)
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir || !wantEntry(opt, e) { continue }
+			st.Entries++
+			st.Compressed += e.CompressedSize
+			st.Uncompressed += e.Size
+			target := targetBase(opt, name, e.Name)
+			dir := "(root)"
+			if slash := strings.IndexByte(target, '/'); slash >= 0 { dir = target[:slash] + "/" }
+			d := dirs[dir]
+			if d == nil { d = &listDirStat{Dir: dir}; dirs[dir] = d }
+			d.Entries++
+			d.Uncompressed += e.Size
+			seen[target] = append(seen[target], name)
+			r.Largest = appendLargest(r.Largest, listFile{Source: name, Path: e.Name, Size: e.Size}, top)
+		}
+		r.Entries += st.Entries
+		r.Compressed += st.Compressed
+		r.Uncompressed += st.Uncompressed
+		r.Sources = append(r.Sources, st)
+		logf("[%d/%d] %s: %d entries", i+1, len(names), name, st.Entries)
+	}
+
+	for _, d := range dirs { r.TopDirs = append(r.TopDirs, *d) }
+	sort.Slice(r.TopDirs, func(i, j int) bool {
+		if r.TopDirs[i].Uncompressed != r.TopDirs[j].Uncompressed { return r.TopDirs[i].Uncompressed > r.TopDirs[j].Uncompressed }
+		return r.TopDirs[i].Dir < r.TopDirs[j].Dir
+	})
+	for path, srcs := range seen {
+		if len(srcs) < 2 { continue }
+		r.Duplicates++
+		r.DupExtra += len(srcs) - 1
+		r.DupSamples = append(r.DupSamples, listDuplicate{Path: path, Sources: srcs})
+	}
+	sort.Slice(r.DupSamples, func(i, j int) bool {
+		a, b := r.DupSamples[i], r.DupSamples[j]
+		if len(a.Sources) != len(b.Sources) { return len(a.Sources) > len(b.Sources) }
+		return a.Path < b.Path
+	})
+	if len(r.DupSamples) > top { r.DupSamples = r.DupSamples[:top] }
+	return r, nil
+}
+
+// appendLargest keeps the n largest files seen so far, largest first.
+func appendLargest(list []listFile, f listFile, n int) []listFile {
+	if n == 0 || len(list) == n && f.Size <= list[n-1].Size { return list }
+	i := sort.Search(len(list), func(i int) bool { return list[i].Size < f.Size })
+	list = append(list, listFile{})
+	copy(list[i+1:], list[i:])
+	list[i] = f
+	if len(list) > n { list = list[:n] }
+	return list
+}
+
+func printList(r *listReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tENTRIES\tCOMPRESSED\tUNCOMPRESSED\t")
+	for _, s := range r.Sources {
+		if s.Error != "" { fmt.Fprintf(tw, "%s\t-\t-\t-\t  (lỗi: %s)\n", s.Name, s.Error); continue }
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t\n", s.Name, s.Entries, humanBytes(s.Compressed), humanBytes(s.Uncompressed))
+	}
+	fmt.Fprintf(tw, "TOTAL (%d)\t%d\t%s\t%s\t\n", len(r.Sources), r.Entries, humanBytes(r.Compressed), humanBytes(r.Uncompressed))
+	_ = tw.Flush()
+
+	fmt.Println()
+	fmt.Fprintln(tw, "TOP-LEVEL\tENTRIES\tUNCOMPRESSED\t")
+	for _, d := range r.TopDirs { fmt.Fprintf(tw, "%s\t%d\t%s\t\n", d.Dir, d.Entries, humanBytes(d.Uncompressed)) }
+	_ = tw.Flush()
+
+	fmt.Printf("\nĐường dẫn trùng: %d (%d bản sẽ bị đổi tên __dupN)\n", r.Duplicates, r.DupExtra)
+	for _, d := range r.DupSamples { fmt.Printf("  %s  x%d  (%s)\n", d.Path, len(d.Sources), strings.Join(d.Sources, ", ")) }
+
+	if len(r.Largest) > 0 {
+		fmt.Println()
+		fmt.Fprintln(tw, "LARGEST\tSIZE\tSOURCE\t")
+		for _, f := range r.Largest { fmt.Fprintf(tw, "%s\t%s\t%s\t\n", f.Path, humanBytes(f.Size), f.Source) }
+		_ = tw.Flush()
+	}
+}
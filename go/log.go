@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// jobID tags every line a merge run prints (and its manifest), so parallel
+// batch runs can be told apart once their logs are aggregated.
+var jobID string
+
+func newJobID() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(b[:])
+}
+
+func jobTag() string {
+	if jobID == "" { return "" }
+	return "[" + jobID + "] "
+}
+
+// logOut receives logs and progress; it is stderr when the archive itself
+// goes to stdout (-out -).
+var logOut io.Writer = os.Stdout
+
+// Console verbosity: -q keeps warnings and errors only (no progress line),
+// -v adds per-entry skips/renames and per-source summaries.
+const (
+	levelQuiet = iota
+	levelNormal
+	levelVerbose
+)
+
+var logLevel = levelNormal
+
+// progressOpen is set while the \r progress line has no newline yet; any
+// other output ends it first so nothing is overwritten.
+var progressOpen bool
+
+func endProgress() {
+	if progressOpen { fmt.Fprint(logOut, "\n"); progressOpen = false }
+}
+
+// fileLog writes -log-file: one JSON record per line, timestamped, with the
+// job ID, independent of the console verbosity and the progress display.
+var fileLog *slog.Logger
+
+func setupLogging(opt options) (closeLog func(), err error) {
+	if err := setVerbosity(opt); err != nil { return nil, err }
+	if opt.logFile == "" { return func() {}, nil }
+	f, err := os.OpenFile(opt.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil { return nil, err }
+	fileLog = slog.New(slog.NewJSONHandler(f, &slog.HandlerOptions{Level: slog.LevelDebug})).With("job", jobID)
+	return func() { fileLog = nil; _ = f.Close() }, nil
+}
+
+// setVerbosity applies -q/-v/-progress/-summary-interval to the console.
+// mergeZIP calls it as well, so a merge started from options alone (tests,
+// -watch) prints what its options ask for.
+func setVerbosity(opt options) error {
+	switch {
+	case opt.verbose && opt.quiet: return errors.New("-v và -q không dùng chung được")
+	case opt.quiet: logLevel = levelQuiet
+	case opt.verbose: logLevel = levelVerbose
+	default: logLevel = levelNormal
+	}
+	switch opt.progress {
+	case progressBar, progressSummary, progressNone: progressMode = opt.progress
+	default: return fmt.Errorf("-progress không hợp lệ: %q (bar|summary|none)", opt.progress)
+	}
+	if opt.summaryEvery <= 0 { return errors.New("-summary-interval phải > 0") }
+	summaryEvery = opt.summaryEvery
+	return nil
+}
+
+// record adds one structured event to -log-file; args are key/value pairs.
+func record(level slog.Level, event string, args ...interface{}) {
+	if fileLog != nil { fileLog.Log(context.Background(), level, event, args...) }
+}
+
+// logf prints an informational line to logOut (not with -q).
+func logf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	record(slog.LevelInfo, "log", "text", msg)
+	if logLevel == levelQuiet { return }
+	endProgress()
+	fmt.Fprint(logOut, jobTag()+msg+"\n")
+}
+
+// logEvent prints text with -v only and records event with its fields in
+// -log-file either way.
+func logEvent(event, text string, args ...interface{}) {
+	record(slog.LevelInfo, event, args...)
+	if logLevel < levelVerbose { return }
+	endProgress()
+	fmt.Fprint(logOut, jobTag()+text+"\n")
+}
+
+// errorf prints to stderr, after ending an open progress line.
+func errorf(format string, args ...interface{}) {
+	msg := printErr(format, args...)
+	record(slog.LevelError, "log", "text", msg)
+}
+
+func printErr(format string, args ...interface{}) string {
+	msg := fmt.Sprintf(format, args...)
+	endProgress()
+	fmt.Fprint(os.Stderr, jobTag()+msg+"\n")
+	return msg
+}
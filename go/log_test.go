@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// -q -progress none must hold for a merge started from options alone, not
+// only once main has set up logging.
+func TestMergeVerbosity(t *testing.T) {
+	defer func(w io.Writer) { logOut = w }(logOut)
+	var buf bytes.Buffer
+	logOut = &buf
+	in, out := t.TempDir(), t.TempDir()
+	writeTestZip(t, filepath.Join(in, "a.zip"), 3, 0)
+	if err := setVerbosity(options{progress: progressBar, summaryEvery: 1}); err != nil { t.Fatal(err) }
+	runMerge(t, "-input", in, "-outdir", out, "-out", "m")
+	if buf.Len() != 0 { t.Fatalf("-q -progress none printed %q", buf.String()) }
+}
+
+// A failing run still leaves its error in -log-file: run returns the exit
+// code instead of calling os.Exit past the deferred closeLog.
+func TestRunLogsFailure(t *testing.T) {
+	defer func(w io.Writer) { logOut = w }(logOut)
+	defer func(id string) { jobID = id }(jobID)
+	logOut = io.Discard
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "run.log")
+	code := run([]string{"-input", filepath.Join(dir, "missing"), "-outdir", dir, "-q", "-progress", "none", "-log-file", logFile})
+	if code != exitFatal { t.Fatalf("exit code %d, want %d", code, exitFatal) }
+	b, err := os.ReadFile(logFile)
+	if err != nil { t.Fatal(err) }
+	if !strings.Contains(string(b), "ERROR") { t.Fatalf("-log-file has no ERROR line:\n%s", b) }
+}
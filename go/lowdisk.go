@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The pre-check guesses from compression ratios; the disk can still fill up
+// hours in (a ratio worse than hoped, another job writing to the volume).
+// With -min-free the free space of the output volume is checked before each
+// entry, counting that entry's size as if it did not compress, and when it
+// would drop below the threshold -on-low-disk decides:
+//   - wait: pause until space is freed (polled), at most -low-disk-wait;
+//   - rollover: close the output and go on in <out>-N in -overflow-dir;
+//   - finish: close the output as a valid archive of what made it, and list
+//     the sources left out, like -deadline does.
+// The pre-check itself then only warns.
+
+const (
+	lowDiskWait     = "wait"
+	lowDiskRollover = "rollover"
+	lowDiskFinish   = "finish"
+
+	lowDiskPoll  = 15 * time.Second // re-check while waiting
+	lowDiskEvery = time.Second      // between two statfs while merging
+)
+
+var errLowDisk = errors.New("output sắp đầy")
+
+func validateLowDisk(opt *options) error {
+	switch opt.onLowDisk {
+	case lowDiskWait, lowDiskRollover, lowDiskFinish:
+	default: return fmt.Errorf("-on-low-disk không hợp lệ: %q (wait|rollover|finish)", opt.onLowDisk)
+	}
+	if opt.minFree == "" {
+		if opt.overflowDir != "" { return errors.New("-overflow-dir cần -min-free và -on-low-disk rollover") }
+		return nil
+	}
+	var err error
+	if opt.minFreeBytes, err = parseSize(opt.minFree); err != nil || opt.minFreeBytes <= 0 { return fmt.Errorf("-min-free không hợp lệ: %q", opt.minFree) }
+	switch {
+	case opt.toStdout || opt.outDevice != "": return errors.New("-min-free chỉ dùng được khi output là file trong -outdir")
+	case opt.lowDiskWait < 0: return fmt.Errorf("-low-disk-wait không hợp lệ: %v", opt.lowDiskWait)
+	case opt.onLowDisk == lowDiskRollover && opt.overflowDir == "": return errors.New("-on-low-disk rollover cần -overflow-dir")
+	case opt.onLowDisk == lowDiskRollover && opt.appendOut: return errors.New("-on-low-disk rollover không dùng chung được với -append/-watch")
+	case opt.overflowDir != "" && opt.onLowDisk != lowDiskRollover: return errors.New("-overflow-dir chỉ dùng với -on-low-disk rollover")
+	}
+	return nil
+}
+
+// diskWatch follows the free space of the volume the output is on.
+type diskWatch struct {
+	dir     string
+	min     int64
+	free    int64     // at the last statfs
+	written int64     // the output's size then
+	at      time.Time // of the last statfs
+	full    bool      // -on-low-disk finish: no further entries
+}
+
+// newDiskWatch returns nil without -min-free.
+func newDiskWatch(opt options) *diskWatch {
+	if opt.minFreeBytes <= 0 { return nil }
+	return &diskWatch{dir: opt.outDir, min: opt.minFreeBytes}
+}
+
+// low reports whether writing need more bytes to out would leave less than
+// -min-free. Between two statfs calls the free space is estimated from what
+// out has grown by.
+func (d *diskWatch) low(out *outputFile, need uint64) (bool, error) {
+	est := d.free - (out.count.n - d.written)
+	if time.Since(d.at) >= lowDiskEvery || out.count.n < d.written || est-int64(need) < d.min {
+		free, err := diskFree(d.dir)
+		if err != nil { return false, fmt.Errorf("không đọc được dung lượng trống của %s: %v", d.dir, err) }
+		d.free, d.written, d.at = int64(free), out.count.n, time.Now()
+		est = d.free
+	}
+	return est-int64(need) < d.min, nil
+}
+
+// wait polls until need more bytes fit above -min-free again.
+func (d *diskWatch) wait(ctx context.Context, opt options, out *outputFile, need uint64) error {
+	start := time.Now()
+	errorf("WARNING: %s còn %s trống, dưới -min-free %s: tạm dừng chờ giải phóng dung lượng", d.dir, humanBytes(uint64(max(d.free, 0))), opt.minFree)
+	record(slog.LevelWarn, "low-disk", "dir", d.dir, "free", d.free, "min_free", d.min, "action", lowDiskWait)
+	for {
+		select {
+		case <-ctx.Done(): return canceled()
+		case <-time.After(lowDiskPoll):
+		}
+		d.at = time.Time{}
+		low, err := d.low(out, need)
+		if err != nil { return err }
+		if !low {
+			logf("Đủ dung lượng trống ở %s (%s) sau %s, tiếp tục", d.dir, humanBytes(uint64(d.free)), fmtHMS(time.Since(start)))
+			return nil
+		}
+		if opt.lowDiskWait > 0 && time.Since(start) >= opt.lowDiskWait {
+			return fmt.Errorf("%w ở %s: còn %s sau %s chờ (-low-disk-wait), cần giữ -min-free %s", errNoSpace, d.dir, humanBytes(uint64(max(d.free, 0))), opt.lowDiskWait, opt.minFree)
+		}
+	}
+}
+
+// overflowPath is output number n in -overflow-dir.
+func overflowPath(opt options, n int) (string, error) {
+	if err := os.MkdirAll(opt.overflowDir, 0o755); err != nil { return "", err }
+	return filepath.Join(opt.overflowDir, filepath.Base(rolloverPath(opt, n))), nil
+}
@@ -4,18 +4,26 @@ import (
 	"archive/zip"
 	"bufio"
 	"compress/flate"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
 	"errors"
 	"flag"
+	"hash"
+	"hash/crc32"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/big"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
-	"syscall"
-	"runtime"
 )
 
 type options struct {
@@ -26,35 +34,176 @@ type options struct {
 	store         bool
 	deflateLevel  int
 	chunkMB       int
+	readAhead     int
 	prefixByZip   bool
 	splitSize     string
 	splitMode     string
 	rmAfterSplit  bool
+	splitMeta     bool
+	splitVerify   bool
+	format        string
+	collectMeta   []string
+	remote        []string
+	srcEncoding   string // -src-encoding, passed on to openSource
+	appendOut     bool
+	toStdout      bool   // -out -
+	outDevice     string // -out names a FIFO/device (device.go)
+	manifest      string
+	checksum      string
+	maxEntries    int
+	maxOutput     string
+	maxOutBytes   int64
+	onOverflow    string
+	spoolDir      string
+	spoolMB       int
+	strict        bool
+	maxWarnings   int
+	jobID         string
+	idemKey       string
+	onChanged     string
+	minAge        time.Duration
+	quota         string
+	quotaBytes    int64
+	prune         bool
+	keep          int
+	storeExt      []string
+	storeEntropy  bool
+	sortBy        string
+	newerThan     string
+	olderThan     string
+	newerTime     time.Time
+	olderTime     time.Time
+	minSize       string
+	maxSize       string
+	minBytes      int64
+	maxBytes      int64 // -1: no limit
+	reverse       bool
+	watch         bool
+	watchEvery    time.Duration
+	stableFor     time.Duration
+	only          map[string]bool // -watch: merge just these source paths
+	verbose       bool
+	quiet         bool
+	logFile       string
+	progress      string
+	summaryEvery  time.Duration
+	onEntryError  string
+	readMBps      float64
+	writeMBps     float64
+	readLimit     *rateLimiter // shared by the whole run; nil = unlimited
+	writeLimit    *rateLimiter
+	nice          bool
+	keepDirs      bool
+	keepComments  bool
+	comment       string // template until mergeZIP renders it
+	blockSize     string
+	blockBytes    int64
+	checkpoint    string
+	ckptBytes     int64
+	upload        string
+	uploadConc    int
+	uploadDelta   bool
+	simDeletes    bool
+	zipTimeout    time.Duration
+	deadline      time.Duration
+	secretScan    string
+	priority      []string
+	jarMode       string
+	signKey       string
+	signPriv      ed25519.PrivateKey // loaded from signKey
+	minFree       string
+	minFreeBytes  int64
+	onLowDisk     string
+	overflowDir   string
+	lowDiskWait   time.Duration
+
+	settings *flag.FlagSet     // resolved flag values, for `config show`
+	sources  map[string]string // flag name -> where its value came from
 }
 
-func parseFlags() (options, error) {
+func parseFlags(args []string) (options, error) {
 	var opt options
-	flag.StringVar(&opt.inputDir, "input", "abcxyz", "Thư mục chứa .zip nguồn")
-	flag.StringVar(&opt.outDir, "outdir", "", "Thư mục output (mặc định: <input>_out)")
-	flag.StringVar(&opt.outBase, "out", "merged", "Tên file đầu ra (không kèm .zip)")
-	flag.StringVar(&opt.filterGlob, "filter", "*.zip", "Glob lọc (vd: 'part-*.zip')")
-	flag.BoolVar(&opt.store, "store", false, "Ghi không nén (nhanh hơn, file to hơn)")
-	flag.IntVar(&opt.deflateLevel, "level", flate.DefaultCompression, "Mức nén Deflate (-2..9)")
-	flag.IntVar(&opt.chunkMB, "chunk", 4, "Block I/O (MB)")
-	flag.BoolVar(&opt.prefixByZip, "prefix-by-zip", false, "Lồng theo tên zip gốc (mặc định: giữ root)")
-	flag.StringVar(&opt.splitSize, "split", "", "Chia nhỏ file đầu ra (raw split), vd: 1900m, 2g")
-	flag.StringVar(&opt.splitMode, "splitmode", "raw", "Chế độ split: raw (mặc định)")
-	flag.BoolVar(&opt.rmAfterSplit, "rm-after-split", false, "Xoá file .zip lớn sau khi split")
-	flag.Parse()
+	fs := defineFlags(&opt)
+	sources, err := resolveFlags(fs, args)
+	if err != nil { return opt, err }
+	opt.settings, opt.sources = fs, sources
 
 	if opt.outBase == "" {
 		return opt, errors.New("out basename rỗng")
 	}
+	if opt.outBase == "-" {
+		opt.toStdout = true
+		switch {
+		case opt.appendOut: return opt, errors.New("-out - không dùng chung được với -append")
+		case opt.splitSize != "": return opt, errors.New("-out - không dùng chung được với -split")
+		case opt.quota != "" || opt.prune: return opt, errors.New("-out - không dùng chung được với -quota/-prune")
+		case opt.checksum != "": return opt, errors.New("-out - không dùng chung được với -checksum (hãy tính ở phía nhận)")
+		}
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return opt, errors.New("-out -: stdout là terminal, hãy pipe hoặc chuyển hướng sang file/lệnh khác")
+		}
+	}
+	opt.checksum = strings.ToLower(opt.checksum)
+	if !validChecksum(opt.checksum) {
+		return opt, fmt.Errorf("-checksum không hợp lệ: %q (sha256|sha512|sha1|md5)", opt.checksum)
+	}
+	opt.format = strings.ToLower(opt.format)
+	if !validFormat(opt.format) {
+		return opt, fmt.Errorf("format không hợp lệ: %q (zip|tar|tgz|tzst)", opt.format)
+	}
+	if err := validateWatch(&opt); err != nil { return opt, err }
+	if opt.appendOut && opt.format != "zip" {
+		return opt, errors.New("-append chỉ hỗ trợ -format zip")
+	}
+	if opt.spoolMB <= 0 {
+		opt.spoolMB = 64
+	}
+	if opt.appendOut && opt.spoolDir != "" {
+		return opt, errors.New("-append không dùng chung được với -spool-dir")
+	}
+	if opt.format == "tzst" {
+		if _, err := exec.LookPath("zstd"); err != nil { return opt, errors.New("-format tzst cần lệnh zstd trong PATH") }
+	}
 	if opt.chunkMB <= 0 {
 		opt.chunkMB = 4
 	}
+	if opt.readAhead < 1 { opt.readAhead = 1 }
+	if int64(opt.chunkMB)<<20*int64(opt.readAhead) > maxBufferBytes {
+		return opt, fmt.Errorf("-chunk %dMB × -read-ahead %d vượt %s bộ đệm cho phép trên bản build này", opt.chunkMB, opt.readAhead, humanBytes(maxBufferBytes))
+	}
 	if opt.outDir == "" {
 		opt.outDir = strings.TrimRight(opt.inputDir, string(os.PathSeparator)) + "_output"
+		opt.sources["outdir"] = srcDerived
+	}
+	if opt.quota != "" {
+		if opt.quotaBytes, err = parseSize(opt.quota); err != nil || opt.quotaBytes <= 0 { return opt, fmt.Errorf("-quota không hợp lệ: %q", opt.quota) }
+	}
+	if opt.keep < 0 { opt.keep = 0 }
+	if err := validateBudget(&opt); err != nil { return opt, err }
+	if err := validateFilters(&opt); err != nil { return opt, err }
+	if err := validateDevice(&opt); err != nil { return opt, err }
+	if err := validateTape(&opt); err != nil { return opt, err }
+	if err := validateStructure(&opt); err != nil { return opt, err }
+	if err := validateEntryError(&opt); err != nil { return opt, err }
+	if err := validateUpload(&opt); err != nil { return opt, err }
+	if err := validateSrcEncoding(&opt); err != nil { return opt, err }
+	if err := validateTimeouts(&opt); err != nil { return opt, err }
+	if err := validateSecretScan(&opt); err != nil { return opt, err }
+	if err := validatePriority(&opt); err != nil { return opt, err }
+	if err := validateJarMode(&opt); err != nil { return opt, err }
+	if err := validateSign(&opt); err != nil { return opt, err }
+	if err := validateLowDisk(&opt); err != nil { return opt, err }
+	if opt.readMBps < 0 || opt.writeMBps < 0 { return opt, errors.New("-max-read-mbps/-max-write-mbps phải >= 0") }
+	opt.readLimit, opt.writeLimit = newRateLimiter(opt.readMBps), newRateLimiter(opt.writeMBps)
+	if !validSort(opt.sortBy) { return opt, fmt.Errorf("-sort không hợp lệ: %q (natural|name|mtime|size|none)", opt.sortBy) }
+	switch opt.onChanged {
+	case changedSkip, changedWait, changedFail:
+	default:
+		return opt, fmt.Errorf("-on-changed không hợp lệ: %q (skip|wait|fail)", opt.onChanged)
+	}
+	if opt.jobID == "" {
+		opt.jobID = newJobID()
+		opt.sources["job-id"] = srcDerived
 	}
 	return opt, nil
 }
@@ -68,17 +217,11 @@ func listZipFiles(dir, glob string) ([]string, error) {
 		name := e.Name()
 		match, err := filepath.Match(glob, name)
 		if err != nil { return nil, err }
-		if match && strings.HasSuffix(strings.ToLower(name), ".zip") {
+		if match && isSourceName(name) {
 			out = append(out, name)
 		}
 	}
-	// simple sort
-	for i := 0; i < len(out); i++ {
-		for j := i+1; j < len(out); j++ {
-			if out[j] < out[i] { out[i], out[j] = out[j], out[i] }
-		}
-	}
-	return out, nil
+	return out, nil // ordered later by sortSources (-sort)
 }
 
 func humanBytes(n uint64) string {
@@ -96,13 +239,76 @@ func fmtHMS(d time.Duration) string {
 	return fmt.Sprintf("%02d:%02d:%02d", s/3600, (s%3600)/60, s%60)
 }
 
-func sumUncompressed(zr *zip.ReadCloser) uint64 {
-	var total uint64
-	for _, f := range zr.File {
-		if f.FileInfo().IsDir() { continue }
-		total += f.UncompressedSize64
+func sumUncompressed(opt options, entries []*sourceEntry) (total, compressed uint64) {
+	for _, e := range entries {
+		if selectEntry(opt, e) != entryCopy { continue }
+		total += e.Size
+		compressed += e.CompressedSize
 	}
-	return total
+	return total, compressed
+}
+
+// The merge and plan share what decides where an entry lands, so a plan
+// lists the targets (__dupN numbers included) the merge writes: the source
+// list, the pre-pass over each source, the source order (priorityOrder) and
+// the choice of entries.
+
+// mergeSources lists the sources of a run after -min-age and -watch's choice.
+func mergeSources(opt options) (names, paths []string, err error) {
+	if names, paths, err = collectSources(opt); err != nil { return nil, nil, err }
+	names, paths = filterMinAge(opt, names, paths)
+	if opt.only != nil { names, paths = keepOnly(names, paths, opt.only) }
+	return names, paths, nil
+}
+
+// sourceScan is what the pre-pass finds about a source.
+type sourceScan struct {
+	stamp   sourceStamp
+	entries []*sourceEntry
+	err     error // not listable; entries is nil
+	merged  bool  // already in the -append output
+	hot     bool  // merged first (-priority)
+}
+
+func scanSource(opt options, existing *existingArchive, name, path string) sourceScan {
+	var s sourceScan
+	s.stamp, _ = stampSource(path)
+	if s.entries, s.err = listSource(opt, path); s.err != nil { return s }
+	s.merged = existing != nil && existing.contains(opt, name, s.stamp, s.entries)
+	s.hot = !s.merged && len(opt.priority) > 0 && (isPriority(opt.priority, name) || hasPriorityEntry(opt, s.entries))
+	return s
+}
+
+const (
+	entryCopy      = iota
+	entryMalformed // rejected by the guard (guard.go)
+	entryFiltered  // not wanted by the entry filters
+	entryJarHeld   // MANIFEST.MF or services file, written by -jar-mode
+	entryJarSig    // jar signature file, dropped by -jar-mode
+)
+
+// selectEntry tells what the merge does with f.
+func selectEntry(opt options, f *sourceEntry) int {
+	switch {
+	case f.invalid != nil: return entryMalformed
+	case !wantEntry(opt, f): return entryFiltered
+	case opt.jarMode != jarOff && !f.IsDir:
+		switch jarKind(f.Name) {
+		case jarManifest, jarService: return entryJarHeld
+		case jarSignature: return entryJarSig
+		}
+	}
+	return entryCopy
+}
+
+// claimTarget maps f to its output name and claims it in dedup; base is the
+// name before __dupN. ok is false for a directory the output already has.
+func claimTarget(opt options, zipName string, f *sourceEntry, dedup map[string]int) (target, base string, ok bool) {
+	if f.IsDir {
+		target, ok = claimDir(opt, zipName, f.Name, dedup)
+		return target, target, ok
+	}
+	return mapTargetName(opt, zipName, f.Name, dedup), targetBase(opt, zipName, f.Name), true
 }
 
 func shouldSkipPath(p string) bool {
@@ -112,15 +318,57 @@ func shouldSkipPath(p string) bool {
 	return false
 }
 
-func mapTargetName(prefixByZip bool, zipName, inner string, dedup map[string]int) string {
+const metadataDir = "MERGED_METADATA"
+
+// listFlag is a comma-separated flag value; each Set replaces the list.
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(s string) error { *l = splitList(s); return nil }
+func (l *listFlag) Get() interface{}   { return append([]string{}, *l...) }
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" { out = append(out, p) }
+	}
+	return out
+}
+
+// isMetadataPath reports whether the base name of an entry matches one of the
+// -collect-meta globs.
+func isMetadataPath(globs []string, p string) bool {
+	base := path.Base(filepath.ToSlash(p))
+	for _, g := range globs {
+		if ok, _ := path.Match(g, base); ok { return true }
+	}
+	return false
+}
+
+// metadataPath places a collected metadata file under MERGED_METADATA/<zip>/,
+// so every source keeps its own copy instead of colliding at the same path.
+func metadataPath(zipName, inner string) string {
+	stem := sourceStem(zipName)
+	return path.Join(metadataDir, stem, strings.TrimLeft(filepath.ToSlash(inner), "/"))
+}
+
+// targetBase is the output path of an entry before de-duplication.
+func targetBase(opt options, zipName, inner string) string {
+	if isMetadataPath(opt.collectMeta, inner) { return metadataPath(zipName, inner) }
 	inner = strings.TrimLeft(inner, "/\\")
-	var base string
-	if prefixByZip {
-		prefix := strings.TrimSuffix(zipName, filepath.Ext(zipName))
-		base = filepath.ToSlash(filepath.Join(prefix, inner))
-	} else {
-		base = filepath.ToSlash(inner) // giữ root
+	if opt.prefixByZip {
+		prefix := sourceStem(zipName)
+		return filepath.ToSlash(filepath.Join(prefix, inner))
 	}
+	return filepath.ToSlash(inner) // giữ root
+}
+
+func mapTargetName(opt options, zipName, inner string, dedup map[string]int) string {
+	return claimName(targetBase(opt, zipName, inner), dedup)
+}
+
+// claimName takes base in dedup, or base__dupN.ext when it is already taken.
+func claimName(base string, dedup map[string]int) string {
 	target := base
 	if c, ok := dedup[base]; ok {
 		root, ext := base, ""
@@ -138,147 +386,218 @@ func printZipProgress(prefix string, done, total, overallDone, overallTotal uint
 	if total > 0 { zp = int((done * 100) / total) }
 	ap := 100
 	if overallTotal > 0 { ap = int((overallDone * 100) / overallTotal) }
+	if progressMode == progressSummary {
+		printSummary(prefix, zp, ap, overallDone, overallTotal, start)
+		return
+	}
+	if logLevel == levelQuiet || progressMode == progressNone { return }
 	if zp != *lastZipPct || ap != *lastAllPct {
 		*lastZipPct = zp
 		*lastAllPct = ap
 		elapsed := time.Since(start)
-		etaStr := "--:--:--"
-		if overallDone > 0 && overallDone < overallTotal && elapsed > 0 {
-			speed := float64(overallDone) / elapsed.Seconds()
-			if speed > 0 {
-				remain := float64(overallTotal - overallDone)
-				etaStr = fmtHMS(time.Duration(remain/speed) * time.Second)
-			}
-		}
-		fmt.Printf("\r%s: %3d%% (%s/%s)  |  Overall: %3d%% (%s/%s)  |  Elapsed %s  ETA %s",
-			prefix,
+		etaStr := etaString(overallDone, overallTotal, elapsed)
+		if at := finishString(overallDone, overallTotal, elapsed); at != "" { etaStr += " (~" + at + ")" }
+		fmt.Fprintf(logOut, "\r%s%s: %3d%% (%s/%s)  |  Overall: %3d%% (%s/%s)  |  Elapsed %s  ETA %s",
+			jobTag(), prefix,
 			zp, humanBytes(done), humanBytes(total),
 			ap, humanBytes(overallDone), humanBytes(overallTotal),
 			fmtHMS(elapsed), etaStr,
 		)
+		progressOpen = true
 	}
 }
 
-func registerDeflater(z *zip.Writer, level int) {
-	z.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+// registerCompressors sets the deflate level (none with -store) and lets m
+// measure the compressed entries.
+func registerCompressors(z *zip.Writer, opt options, m *packMeter) {
+	z.RegisterCompressor(zip.Store, m.wrap(func(w io.Writer) (io.WriteCloser, error) { return storeComp{w}, nil }))
+	if opt.store { return }
+	level := opt.deflateLevel
+	z.RegisterCompressor(zip.Deflate, m.wrap(func(w io.Writer) (io.WriteCloser, error) {
 		if level == -2 { return flate.NewWriter(w, flate.HuffmanOnly) }
 		return flate.NewWriter(w, level)
-	})
+	}))
 }
 
-var splitSizeRe = regexp.MustCompile(`(?i)^\s*([0-9]+)\s*([kmgt]?)\s*$`)
+// Sizes are a number, fractions allowed, and a unit. A bare letter and the
+// IEC units are binary, as they have always been here (1g = 1GiB =
+// 1073741824); KB/MB/GB/TB are decimal (2GB = 2000000000), for limits given
+// in bytes such as an upload cap. b or no unit means bytes. Fractions are
+// rounded down to a whole byte.
+var sizeRe = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?|\.[0-9]+)\s*([a-z]*)$`)
+
+var sizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "ki": 1 << 10, "kib": 1 << 10, "kb": 1e3,
+	"m": 1 << 20, "mi": 1 << 20, "mib": 1 << 20, "mb": 1e6,
+	"g": 1 << 30, "gi": 1 << 30, "gib": 1 << 30, "gb": 1e9,
+	"t": 1 << 40, "ti": 1 << 40, "tib": 1 << 40, "tb": 1e12,
+}
 
 func parseSize(s string) (int64, error) {
-	m := splitSizeRe.FindStringSubmatch(strings.TrimSpace(s))
+	m := sizeRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
 	if m == nil { return 0, fmt.Errorf("kích thước không hợp lệ: %q", s) }
-	num, _ := strconv.ParseInt(m[1], 10, 64)
-	mul := int64(1)
-	switch strings.ToLower(m[2]) {
-	case "k": mul = 1024
-	case "m": mul = 1024 * 1024
-	case "g": mul = 1024 * 1024 * 1024
-	case "t": mul = 1024 * 1024 * 1024 * 1024
-	}
-	return num * mul, nil
+	mul, ok := sizeUnits[m[2]]
+	if !ok { return 0, fmt.Errorf("kích thước không hợp lệ: %q (đơn vị: b, k/ki/kib, kb, m..., g..., t...)", s) }
+	r, _ := new(big.Rat).SetString(m[1])
+	r.Mul(r, new(big.Rat).SetInt64(mul))
+	n := new(big.Int).Quo(r.Num(), r.Denom())
+	if !n.IsInt64() { return 0, fmt.Errorf("kích thước quá lớn: %q", s) }
+	return n.Int64(), nil
 }
 
-func rawSplit(path, partSizeStr string, rmAfter bool) error {
-	partSize, err := parseSize(partSizeStr)
-	if err != nil { return err }
-	if partSize <= 0 { return fmt.Errorf("split size phải > 0") }
+// writePart copies up to partSize bytes from in into a new part file.
+// extra, if set, also receives the part's bytes (the -checksum digest).
+func writePart(ctx context.Context, in io.Reader, partName string, partSize int64, buf []byte, whole, extra hash.Hash, sync bool) (int64, hash.Hash, error) {
+	out, err := os.Create(partName)
+	if err != nil { return 0, nil, err }
+	partSum := sha256.New()
+	dst := io.MultiWriter(out, partSum, whole)
+	if extra != nil { dst = io.MultiWriter(dst, extra) }
+
+	var copied int64
+	for copied < partSize {
+		if ctx.Err() != nil { _ = out.Close(); return copied, nil, canceled() }
+		toRead := int64(len(buf))
+		if remain := partSize - copied; toRead > remain { toRead = remain }
+		n, rErr := in.Read(buf[:toRead])
+		if n > 0 {
+			if _, wErr := dst.Write(buf[:n]); wErr != nil { _ = out.Close(); return copied, nil, wErr }
+			copied += int64(n)
+		}
+		if rErr != nil {
+			if rErr == io.EOF { break }
+			_ = out.Close(); return copied, nil, rErr
+		}
+	}
+	if sync {
+		if err := out.Sync(); err != nil { _ = out.Close(); return copied, nil, err }
+	}
+	return copied, partSum, out.Close()
+}
+
+// rawSplit cuts path into .part-NNN files and returns their paths.
+func rawSplit(ctx context.Context, path string, opt options) (_ []string, err error) {
+	partSize, err := parseSize(opt.splitSize)
+	if err != nil { return nil, err }
+	if partSize <= 0 { return nil, fmt.Errorf("split size phải > 0") }
 
 	in, err := os.Open(path)
-	if err != nil { return err }
+	if err != nil { return nil, err }
 	defer in.Close()
 
 	info, err := in.Stat()
-	if err != nil { return err }
+	if err != nil { return nil, err }
 	total := info.Size()
-	if total == 0 { return nil }
+	if total == 0 { return nil, nil }
 
 	prefix := path + ".part-"
 	buf := make([]byte, 4*1024*1024)
 	var partIdx int
 	var written int64
+	var parts []splitPart
+	whole := sha256.New()
+	var created []string
+	defer func() {
+		if errors.Is(err, errCanceled) { removePartial(created...) }
+	}()
 
 	for {
 		partName := fmt.Sprintf("%s%03d", prefix, partIdx)
-		out, err := os.Create(partName)
-		if err != nil { return err }
-
+		created = append(created, partName)
+		partStart := written
+		state, _ := whole.(encoding.BinaryMarshaler).MarshalBinary()
 		var copied int64
-		for copied < partSize {
-			toRead := int64(len(buf))
-			if remain := partSize - copied; toRead > remain { toRead = remain }
-			n, rErr := in.Read(buf[:toRead])
-			if n > 0 {
-				if _, wErr := out.Write(buf[:n]); wErr != nil { _ = out.Close(); return wErr }
-				copied += int64(n)
-				written += int64(n)
-			}
-			if rErr != nil {
-				if rErr == io.EOF { break }
-				_ = out.Close(); return rErr
-			}
+		var partSum, sidecar hash.Hash
+		for attempt := 1; ; attempt++ {
+			if opt.checksum != "" { sidecar = newChecksum(opt.checksum) }
+			copied, partSum, err = writePart(ctx, limitReader(in, opt.readLimit, opt.writeLimit), partName, partSize, buf, whole, sidecar, opt.splitVerify)
+			if err == nil && opt.splitVerify { err = verifyPart(partName, copied, partSum.Sum(nil), buf) }
+			if err == nil { break }
+			if !opt.splitVerify || attempt >= splitVerifyAttempts || errors.Is(err, errCanceled) { return nil, err }
+			errorf("WARNING: part %s lỗi (%v), ghi lại lần %d/%d", partName, err, attempt+1, splitVerifyAttempts)
+			if err := whole.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil { return nil, err }
+			if _, err := in.Seek(partStart, io.SeekStart); err != nil { return nil, err }
 		}
-		_ = out.Close()
-		fmt.Printf("Split part %s (%s)\n", partName, humanBytes(uint64(copied)))
+		written += copied
+		parts = append(parts, splitPart{path: partName, size: copied, sha256: hex.EncodeToString(partSum.Sum(nil)), sum: sidecar})
+		logf("Split part %s (%s)", partName, humanBytes(uint64(copied)))
 		if written >= total { break }
 		partIdx++
 	}
 
-	if rmAfter {
-		if err := os.Remove(path); err != nil { return err }
-		fmt.Printf("Removed original: %s\n", path)
+	if opt.splitMeta {
+		if err := writeSplitTrailers(parts, filepath.Base(path), total, hex.EncodeToString(whole.Sum(nil))); err != nil { return nil, err }
+	}
+
+	if opt.rmAfterSplit {
+		if err := removeByOption(opt, path, "-rm-after-split"); err != nil { return nil, err }
+		if !opt.simDeletes { logf("Removed original: %s", path) }
+	}
+	if opt.checksum != "" {
+		if err := addPartChecksums(opt, path, parts); err != nil { return nil, err }
+	}
+	if opt.splitMeta {
+		logf("Done raw split (with trailers). To join:\n  mergezip_go join %s*", prefix)
+	} else {
+		logf("Done raw split. To join:\n  cat %s* > %s", prefix, filepath.Base(path))
 	}
-	fmt.Printf("Done raw split. To join:\n  cat %s* > %s\n", prefix, filepath.Base(path))
-	return nil
+	return created, nil
 }
 
-func mergeZIP(opt options) (string, error) {
-	if err := os.MkdirAll(opt.outDir, 0o755); err != nil { return "", err }
-	outPath := filepath.Join(opt.outDir, opt.outBase+".zip")
+func mergeZIP(ctx context.Context, opt options, wl *warnLog) (_ []string, err error) {
+	if err := setVerbosity(opt); err != nil { return nil, err }
+	if opt.outDevice == "" || opt.manifest != "" {
+		if err := os.MkdirAll(opt.outDir, 0o755); err != nil { return nil, err }
+	}
+	outPath := outputPath(opt)
+	runCtx, stopRun := deadlineContext(ctx, opt)
+	defer stopRun()
 
-	names, err := listZipFiles(opt.inputDir, opt.filterGlob)
-	if err != nil { return "", err }
-	if len(names) == 0 { return "", fmt.Errorf("không tìm thấy .zip khớp '%s' trong %s", opt.filterGlob, opt.inputDir) }
+	names, paths, err := mergeSources(opt)
+	if err != nil { return nil, err }
+	if len(names) == 0 { return nil, fmt.Errorf("không tìm thấy .zip khớp '%s' trong %s", opt.filterGlob, opt.inputDir) }
+
+	dedup := map[string]int{}
+	var existing *existingArchive
+	if opt.appendOut {
+		if _, err := os.Stat(outPath); err == nil {
+			if existing, err = loadExistingArchive(opt, outPath, dedup); err != nil { return nil, err }
+		}
+	}
 
-	var overallTotal uint64
+	var overallTotal, overallCompressed uint64
 	zipTotals := make([]uint64, len(names))
+	merged := make([]bool, len(names))
+	unreadable := make([]bool, len(names))
+	stamps := make([]sourceStamp, len(names))
+	hot := make([]bool, len(names)) // -priority
 	for i, name := range names {
-		zr, err := zip.OpenReader(filepath.Join(opt.inputDir, name))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "WARNING: bỏ qua (không mở được): %s (%v)\n", name, err)
+		if ctx.Err() != nil { return nil, canceled() }
+		sc := scanSource(opt, existing, name, paths[i])
+		stamps[i], merged[i], hot[i] = sc.stamp, sc.merged, sc.hot
+		if sc.err != nil {
+			unreadable[i] = true
+			if err := wl.warn(warnCategory(sc.err, warnOpen), "bỏ qua (không mở được): %s (%v)", name, sc.err); err != nil { return nil, err }
 			continue
 		}
-		zipTotals[i] = sumUncompressed(zr)
+		if merged[i] { continue }
+		var compressed uint64
+		zipTotals[i], compressed = sumUncompressed(opt, sc.entries)
 		overallTotal += zipTotals[i]
-		_ = zr.Close()
-	}
-
-	
-	// ---- Disk space pre-check ----
-	var overallCompressed uint64 = 0
-	for _, name := range names {
-		p := filepath.Join(opt.inputDir, name)
-		zr, err := zip.OpenReader(p)
-		if err != nil { continue }
-		for _, f := range zr.File {
-			if f.FileInfo().IsDir() { continue }
-			overallCompressed += f.CompressedSize64
-		}
-		_ = zr.Close()
+		overallCompressed += compressed
 	}
-	var freeBytes uint64 = 0
-	if runtime.GOOS != "windows" {
-		var fs syscall.Statfs_t
-		if err := syscall.Statfs(opt.outDir, &fs); err == nil {
-			freeBytes = fs.Bavail * uint64(fs.Bsize)
+
+	// ---- Disk space pre-check (nothing lands on our disk with -out -) ----
+	var freeBytes uint64
+	if !opt.toStdout && opt.outDevice == "" {
+		if freeBytes, err = diskFree(opt.outDir); err != nil {
+			errorf("WARNING: không đọc được dung lượng trống của %s (%v), bỏ qua pre-check", opt.outDir, err)
 		}
 	}
 	var need uint64
 	reason := ""
-	if opt.store {
+	if opt.store || opt.format == "tar" {
 		need = uint64(float64(overallTotal) * 1.05)
 		reason = "store (no compression)"
 	} else {
@@ -287,103 +606,498 @@ func mergeZIP(opt options) (string, error) {
 		need = uint64(float64(candidate) * 1.10)
 		reason = "deflate (recompression)"
 	}
-	if freeBytes > 0 && freeBytes < need {
-		return "", fmt.Errorf("không đủ dung lượng trống ở %s: cần ~%.1f GB (mode=%s), còn %.1f GB",
-			opt.outDir, float64(need)/1024/1024/1024, reason, float64(freeBytes)/1024/1024/1024)
+	freed, err := pruneOutputs(opt, []string{outPath}, int64(need), freeBytes)
+	if err != nil { return nil, err }
+	if freeBytes > 0 { freeBytes += uint64(freed) }
+	if freeBytes > 0 && freeBytes < need && opt.minFreeBytes > 0 {
+		errorf("WARNING: có thể thiếu dung lượng ở %s: cần ~%s, còn %s; -on-low-disk %s khi xuống dưới -min-free %s",
+			opt.outDir, humanBytes(need), humanBytes(freeBytes), opt.onLowDisk, opt.minFree)
+	} else if freeBytes > 0 && freeBytes < need {
+		return nil, fmt.Errorf("%w ở %s: cần ~%.1f GB (mode=%s), còn %.1f GB",
+			errNoSpace, opt.outDir, float64(need)/1024/1024/1024, reason, float64(freeBytes)/1024/1024/1024)
 	}
 
-	outFile, err := os.Create(outPath)
-	if err != nil { return "", err }
-	defer outFile.Close()
+	if opt.comment, err = renderComment(opt.comment, names, opt.jobID); err != nil { return nil, err }
+	var out *outputFile
+	var outputs []string
+	defer func() {
+		if out != nil { out.abort() }
+		if (errors.Is(err, errCanceled) || errors.Is(err, errSecret)) && existing == nil && !opt.toStdout && opt.outDevice == "" { removePartial(outputs...) }
+		if opt.appendOut && out != nil { saveAppendRecord(opt, outPath, existing, names, stamps, merged) } // also after a failure: what made it stays
+	}()
+	if existing != nil {
+		out, err = openAppendOutput(opt, outPath)
+	} else {
+		out, err = createOutput(opt, outPath)
+	}
+	if err != nil { return nil, err }
+	outputs = append(outputs, outPath)
+	var report *overflowReport
+	if opt.onOverflow == overflowTruncate && opt.budgeted() {
+		report = &overflowReport{path: overflowReportPath(opt)}
+		defer report.close()
+	}
 
-	zw := zip.NewWriter(outFile)
-	if !opt.store { registerDeflater(zw, opt.deflateLevel) }
-	defer zw.Close()
+	var mf *manifestWriter
+	if opt.manifest != "" {
+		if mf, err = newManifestWriter(manifestPath(opt), outPath, opt.jobID); err != nil { return nil, err }
+		defer func() {
+			if errors.Is(err, errCanceled) || errors.Is(err, errSecret) { removePartial(manifestPath(opt)) }
+		}()
+		defer mf.close()
+	}
 
 	start := time.Now()
 	var overallDone uint64
-	dedup := map[string]int{}
+	var totalEntries int
 	buf := make([]byte, opt.chunkMB*1024*1024)
 	if len(buf) == 0 { buf = make([]byte, 4*1024*1024) }
+	bufs := [][]byte{buf}
+	for len(bufs) < opt.readAhead { bufs = append(bufs, make([]byte, len(buf))) }
+	var peek []byte
+	if opt.storeEntropy && !opt.store { peek = make([]byte, entropySample) }
+	var missing []string // left out by -deadline or -on-low-disk finish
+	disk := newDiskWatch(opt)
 
-	for idx, name := range names {
-		zr, err := zip.OpenReader(filepath.Join(opt.inputDir, name))
+	order := priorityOrder(hot)
+	if len(opt.priority) > 0 {
+		n := 0
+		for _, h := range hot { if h { n++ } }
+		logf("-priority: %d/%d nguồn được merge trước", n, len(names))
+	}
+	var jar *jarMeta
+	if opt.jarMode != jarOff {
+		if jar, err = collectJarMeta(ctx, opt, wl, names, paths, order, unreadable); err != nil { return nil, err }
+		if err := jar.write(opt, out, dedup); err != nil { return nil, err }
+	}
+
+	for pos, idx := range order {
+		name := names[idx]
+		if merged[idx] {
+			logf("[%d/%d] %s: đã có trong %s, bỏ qua", pos+1, len(names), name, filepath.Base(outPath))
+			continue
+		}
+		if unreadable[idx] { continue }
+		if ctx.Err() != nil { return nil, canceled() }
+		if runCtx.Err() != nil || (disk != nil && disk.full) { missing = append(missing, name); continue }
+		srcPath := paths[idx]
+		ok, stamp, err := settleSource(ctx, opt, wl, srcPath, name, stamps[idx])
+		if err != nil { return nil, err }
+		if !ok { overallTotal -= zipTotals[idx]; continue }
+		if stamp != stamps[idx] {
+			stamps[idx] = stamp
+			entries, err := listSource(opt, srcPath)
+			if err != nil {
+				overallTotal -= zipTotals[idx]
+				if err := wl.warn(warnCategory(err, warnOpen), "bỏ qua (không mở được): %s (%v)", name, err); err != nil { return nil, err }
+				continue
+			}
+			total, _ := sumUncompressed(opt, entries)
+			overallTotal += total - zipTotals[idx]
+			zipTotals[idx] = total
+		}
+		ar, err := openSource(opt, srcPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "WARNING: bỏ qua (không mở được): %s (%v)\n", name, err)
+			if err := wl.warn(warnCategory(err, warnOpen), "bỏ qua (không mở được): %s (%v)", name, err); err != nil { return nil, err }
 			continue
 		}
+		promoteEntries(opt, ar)
+		srcCtx, stopSrc := sourceContext(runCtx, opt)
+		var stop error // -zip-timeout / -deadline hit in this source
 		totalZip := zipTotals[idx]
 		var doneZip uint64
 		lastZipPct, lastAllPct := -1, -1
-		prefix := fmt.Sprintf("[%d/%d] %s", idx+1, len(names), name)
+		prefix := fmt.Sprintf("[%d/%d] %s", pos+1, len(names), name)
+		srcStart := time.Now()
+		var written, skipped int
 
-		for _, f := range zr.File {
-			if f.FileInfo().IsDir() { continue }
-			if shouldSkipPath(f.Name) { continue }
-			target := mapTargetName(opt.prefixByZip, name, f.Name, dedup)
+		for {
+			if ctx.Err() != nil { _ = ar.close(); return nil, canceled() }
+			if stop = stopCause(ctx, runCtx, srcCtx); stop != nil { break }
+			f, err := ar.next()
+			if err == io.EOF { break }
+			if err != nil {
+				if err := wl.warn(warnCategory(err, warnList), "lỗi đọc %s: %v", name, err); err != nil { _ = ar.close(); return nil, err }
+				break
+			}
+			switch selectEntry(opt, f) {
+			case entryMalformed:
+				if err := wl.warn(warnMalformed, "bỏ qua entry trong %s: %v", name, f.invalid); err != nil { _ = ar.close(); return nil, err }
+				continue
+			case entryFiltered:
+				if !f.IsDir {
+					skipped++
+					logEvent("entry-skip", "  bỏ qua "+f.Name, "source", name, "path", f.Name, "size", f.Size)
+				}
+				continue
+			case entryJarHeld:
+				continue
+			case entryJarSig:
+				jar.dropSignature(name, f)
+				continue
+			}
+			if !f.IsDir && existing.has(opt, name, f) {
+				logEvent("entry-skip", "  đã có trong output: "+f.Name, "source", name, "path", f.Name, "size", f.Size)
+				doneZip += f.Size
+				overallDone += f.Size
+				continue
+			}
+			target, base, ok := claimTarget(opt, name, f, dedup)
+			if !ok { continue }
+			var comment string
+			if opt.keepComments { comment = f.Comment }
+
+			if disk != nil {
+				low, err := disk.low(out, f.Size)
+				if err != nil { _ = ar.close(); return nil, err }
+				if low {
+					switch opt.onLowDisk {
+					case lowDiskWait:
+						if err := disk.wait(ctx, opt, out, f.Size); err != nil { _ = ar.close(); return nil, err }
+					case lowDiskRollover:
+						if out.entries == 0 { _ = ar.close(); return nil, fmt.Errorf("%w ở %s: entry '%s' (%s) không vừa trên -min-free %s", errNoSpace, disk.dir, f.Name, humanBytes(f.Size), opt.minFree) }
+						if err := out.finish(opt, buf); err != nil { _ = ar.close(); return nil, err }
+						next, err := overflowPath(opt, len(outputs)+1)
+						if err != nil { _ = ar.close(); return nil, err }
+						errorf("WARNING: %s còn %s trống, dưới -min-free %s: chuyển sang %s", disk.dir, humanBytes(uint64(max(disk.free, 0))), opt.minFree, next)
+						record(slog.LevelWarn, "low-disk", "dir", disk.dir, "free", disk.free, "min_free", disk.min, "action", lowDiskRollover, "next", next)
+						if out, err = createOutput(opt, next); err != nil { _ = ar.close(); return nil, err }
+						outputs = append(outputs, next)
+						disk = &diskWatch{dir: opt.overflowDir, min: disk.min}
+					case lowDiskFinish:
+						disk.full = true
+						stop = errLowDisk
+					}
+				}
+				if stop != nil { break }
+			}
 
-			hdr := &zip.FileHeader{Name: filepath.ToSlash(target), Method: zip.Store}
-			if !opt.store { hdr.Method = zip.Deflate }
-			if !f.Modified.IsZero() { hdr.SetModTime(f.Modified) } else { hdr.SetModTime(time.Now()) }
-			hdr.UncompressedSize64 = f.UncompressedSize64
+			if limit := out.overBudget(opt, f.Size, target, comment); limit != "" {
+				switch opt.onOverflow {
+				case overflowRollover:
+					if out.entries == 0 { _ = ar.close(); return nil, fmt.Errorf("entry '%s' (%s) một mình đã vượt %s", f.Name, humanBytes(f.Size), limit) }
+					if err := out.finish(opt, buf); err != nil { _ = ar.close(); return nil, err }
+					next := rolloverPath(opt, len(outputs)+1)
+					if disk != nil && disk.dir == opt.overflowDir { // already moved by -on-low-disk rollover
+						if next, err = overflowPath(opt, len(outputs)+1); err != nil { _ = ar.close(); return nil, err }
+					}
+					logf("%s đạt %s (%d entries), chuyển sang %s", filepath.Base(out.path), limit, out.entries, filepath.Base(next))
+					if out, err = createOutput(opt, next); err != nil { _ = ar.close(); return nil, err }
+					outputs = append(outputs, next)
+				case overflowTruncate:
+					if err := report.add(name, f.Name, target, f.Size, limit); err != nil { _ = ar.close(); return nil, fmt.Errorf("overflow report: %v", err) }
+					continue
+				default:
+					_ = ar.close()
+					return nil, fmt.Errorf("%w: %s vượt %s ở entry '%s' (-on-overflow fail)", errOverflow, filepath.Base(out.path), limit, f.Name)
+				}
+			}
+
+			if f.IsDir {
+				hdr := dirHeader(target, f)
+				hdr.Comment = comment
+				if _, err := out.create(hdr); err != nil {
+					if err := wl.warn(warnCreate, "không thể tạo entry '%s': %v", hdr.Name, err); err != nil { _ = ar.close(); return nil, err }
+					continue
+				}
+				out.added(opt, hdr.Name, comment)
+				written++
+				totalEntries++
+				continue
+			}
+
+			// placeholder runs after the warning for an unreadable entry
+			// (-on-entry-error placeholder); failing to write it is one more.
+			placeholder := func(cause error) error {
+				if opt.onEntryError != entryErrPlaceholder { return nil }
+				if err := addPlaceholder(opt, out, dedup, name, f, target, cause); err != nil {
+					return wl.warn(warnCreate, "không thể tạo placeholder cho '%s': %v", target, err)
+				}
+				return nil
+			}
 
-			w, err := zw.CreateHeader(hdr)
+			rc, err := f.open()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "\nWARNING: không thể tạo entry '%s': %v\n", hdr.Name, err)
+				if err := wl.warn(warnCategory(err, warnEntry), "không thể đọc '%s' trong %s: %v", f.Name, name, err); err != nil { _ = ar.close(); return nil, err }
+				if err := placeholder(err); err != nil { _ = ar.close(); return nil, err }
 				continue
 			}
-			rc, err := f.Open()
+			method, src, err := entryMethod(opt, f.Name, rc, peek)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "\nWARNING: không thể đọc '%s' trong %s: %v\n", f.Name, name, err)
+				_ = rc.Close()
+				if err := wl.warn(warnCategory(err, warnRead), "lỗi đọc entry '%s' trong %s: %v", f.Name, name, err); err != nil { _ = ar.close(); return nil, err }
+				if err := placeholder(err); err != nil { _ = ar.close(); return nil, err }
 				continue
 			}
 
+			// Modified is kept in the source's own zone (SetModTime would turn
+			// the MS-DOS fields into UTC). Sizes only matter to the tar writer:
+			// zip.Writer takes them from the bytes written and switches to
+			// Zip64 records on its own past 4 GiB / 65,535 entries.
+			hdr := &zip.FileHeader{Name: filepath.ToSlash(target), Method: method, Modified: f.Modified, Comment: comment}
+			if hdr.Modified.IsZero() { hdr.Modified = time.Now() }
+			hdr.UncompressedSize64 = f.Size
+
+			packed := new(uint64)
+			if mf != nil { out.meter.arm(packed) }
+			w, err := out.create(hdr)
+			if err != nil {
+				_ = rc.Close()
+				if err := wl.warn(warnCreate, "không thể tạo entry '%s': %v", hdr.Name, err); err != nil { _ = ar.close(); return nil, err }
+				continue
+			}
+			out.added(opt, hdr.Name, comment)
+			out.inEntry = true
+			if target != base { logEvent("entry-rename", fmt.Sprintf("  %s -> %s (trùng tên)", f.Name, hdr.Name), "source", name, "path", f.Name, "target", hdr.Name) }
+
 			bw := bufio.NewWriter(w)
+			sum := crc32.NewIEEE()
+			scan := newSecretScanner(opt)
+			var copied uint64
+			var readErr, writeErr error
+			pf := newPrefetcher(limitReader(src, opt.readLimit), bufs)
+			entryCtx := ctx
+			if out.canDrop() { entryCtx = srcCtx }
 			for {
-				n, rErr := rc.Read(buf)
-				if n > 0 {
-					if _, wErr := bw.Write(buf[:n]); wErr != nil {
-						_ = rc.Close(); _ = bw.Flush(); _ = zr.Close()
-						return "", wErr
-					}
+				b, rErr := pf.next(entryCtx)
+				if n := len(b); n > 0 {
+					_, _ = sum.Write(b)
+					if scan != nil { _, _ = scan.Write(b) }
+					copied += uint64(n)
+					if _, writeErr = bw.Write(b); writeErr != nil { pf.release(b); break }
 					doneZip += uint64(n)
 					overallDone += uint64(n)
 					printZipProgress(prefix, doneZip, totalZip, overallDone, overallTotal, start, &lastZipPct, &lastAllPct)
 				}
+				pf.release(b)
 				if rErr != nil {
 					if rErr == io.EOF { break }
-					fmt.Fprintf(os.Stderr, "\nWARNING: lỗi đọc entry '%s' trong %s: %v\n", f.Name, name, rErr)
+					if rErr == errCanceled {
+						if stop = stopCause(ctx, runCtx, srcCtx); stop != errCanceled { break }
+						pf.stop(); _ = rc.Close(); _ = bw.Flush(); _ = ar.close()
+						return nil, canceled()
+					}
+					if err := wl.warn(warnCategory(rErr, warnRead), "lỗi đọc entry '%s' trong %s: %v", f.Name, name, rErr); err != nil {
+						pf.stop(); _ = rc.Close(); _ = bw.Flush(); _ = ar.close()
+						return nil, err
+					}
+					readErr = rErr
 					break
 				}
 			}
+			pf.stop()
 			_ = rc.Close()
+			if stop != nil {
+				_ = bw.Flush()
+				if err := out.dropEntry(opt, hdr.Name, comment); err != nil { _ = ar.close(); return nil, err }
+				logEvent("entry-drop", fmt.Sprintf("  bỏ '%s' (%v)", hdr.Name, stop), "source", name, "path", f.Name, "reason", stop.Error())
+				break
+			}
+			if readErr != nil {
+				// A half-copied entry would carry a CRC of what was read, so it
+				// checks out fine: it leaves the output, placeholder or not.
+				_ = bw.Flush()
+				if !out.canDrop() { _ = ar.close(); return nil, fmt.Errorf("lỗi đọc '%s' trong %s sau %d/%d bytes, output %s không bỏ được entry dở: %v", f.Name, name, copied, f.Size, filepath.Base(out.path), readErr) }
+				if err := out.dropEntry(opt, hdr.Name, comment); err != nil { _ = ar.close(); return nil, err }
+				logEvent("entry-drop", fmt.Sprintf("  bỏ '%s' (%v)", hdr.Name, readErr), "source", name, "path", f.Name, "reason", readErr.Error())
+				cause := fmt.Errorf("lỗi sau %d/%d bytes: %v", copied, f.Size, readErr)
+				if err := placeholder(cause); err != nil { _ = ar.close(); return nil, err }
+				continue
+			}
+			if writeErr == nil && copied < f.Size && opt.format != "zip" {
+				_, writeErr = io.CopyN(bw, zeroReader{}, int64(f.Size-copied)) // see zeroReader
+			}
 			_ = bw.Flush()
+			if writeErr == nil { writeErr = out.entryDone() }
+			if writeErr != nil {
+				if err := out.rollback(opt, hdr.Name, comment, writeErr); err != nil { _ = ar.close(); return nil, err }
+				if err := wl.warn(warnWrite, "đã bỏ entry '%s' khỏi output vì lỗi ghi: %v", hdr.Name, writeErr); err != nil { _ = ar.close(); return nil, err }
+				continue
+			}
+			if scan != nil {
+				if err := scan.verdict(opt, wl, name, f.Name); err != nil { _ = ar.close(); return nil, err } // still inEntry: abort drops it
+			}
+			out.inEntry = false
+			if how := sizeMismatch(f.Size, copied); how != "" {
+				if err := wl.warn(warnSize, "entry '%s' trong %s %s: khai báo %d bytes, chép được %d", f.Name, name, how, f.Size, copied); err != nil { _ = ar.close(); return nil, err }
+			}
+			written++
+			totalEntries++
+			if mf != nil {
+				me := manifestEntry{
+					Source: name, Path: f.Name, Target: hdr.Name,
+					Size: copied, CompressedSize: f.CompressedSize, CRC32: fmt.Sprintf("%08x", sum.Sum32()),
+					Modified: hdr.Modified, Renamed: base != strings.TrimLeft(f.Name, "/\\"), Deduped: target != base,
+					OutMethod: opt.format, packed: packed,
+				}
+				if copied != f.Size { me.DeclaredSize = &f.Size }
+				if f.zf != nil { me.Method = zipMethodName(f.zf.Method) }
+				if opt.format == "zip" { me.OutMethod = zipMethodName(hdr.Method) }
+				if (opt.onOverflow == overflowRollover && opt.budgeted()) || opt.overflowDir != "" { me.Output = filepath.Base(out.path) }
+				err := mf.add(me)
+				if err != nil { _ = ar.close(); return nil, fmt.Errorf("manifest: %v", err) }
+			}
 		}
+		stopSrc()
 		printZipProgress(prefix, totalZip, totalZip, overallDone, overallTotal, start, &lastZipPct, &lastAllPct)
-		fmt.Print("\n")
-		_ = zr.Close()
+		endProgress()
+		_ = ar.close()
+		switch stop {
+		case errDeadline, errLowDisk:
+			missing = append(missing, fmt.Sprintf("%s (dở, %d entry đã ghi)", name, written))
+			continue
+		case errZipTimeout:
+			if err := wl.warn(warnTimeout, "%s: quá -zip-timeout %s, bỏ phần còn lại (%d entry đã ghi)", name, opt.zipTimeout, written); err != nil { return nil, err }
+			continue
+		}
+		took := time.Since(srcStart)
+		kv := []interface{}{"source", name, "entries", written, "bytes", doneZip, "skipped", skipped, "seconds", took.Seconds()}
+		if at, ok := finishAt(overallDone, overallTotal, time.Since(start)); ok { kv = append(kv, "expected_finish", at.Format(time.RFC3339)) }
+		logEvent("source-done", fmt.Sprintf("%s: %d entries, %s, bỏ qua %d, %s", name, written, humanBytes(doneZip), skipped, fmtHMS(took)), kv...)
+		if now, err := stampSource(srcPath); err == nil && now != stamps[idx] {
+			if opt.onChanged == changedFail { return nil, fmt.Errorf("%s thay đổi trong lúc merge; bản sao có thể không đầy đủ", name) }
+			if err := wl.warn(warnChanged, "%s thay đổi trong lúc merge; entry của nó có thể không đầy đủ", name); err != nil { return nil, err }
+		}
+		merged[idx] = true // for the -append record
 	}
 
-	if err := zw.Close(); err != nil { return "", err }
-	if err := outFile.Close(); err != nil { return "", err }
-	fmt.Printf("Hoàn tất! Tạo: %s\n", outPath)
-	fmt.Printf("Total time: %s\n", fmtHMS(time.Since(start)))
-	return outPath, nil
+	if err := out.finish(opt, buf); err != nil { return nil, err }
+	if jar != nil && jar.signatures > 0 { logf("-jar-mode: bỏ %d file chữ ký (META-INF/*.SF, *.RSA...)", jar.signatures) }
+	if err := reportMissing(opt, wl, missing, disk != nil && disk.full); err != nil { return nil, err }
+	if mf != nil {
+		if err := mf.close(); err != nil { return nil, fmt.Errorf("manifest: %v", err) }
+		logf("Manifest: %s (%d entries)", manifestPath(opt), mf.count)
+	}
+	if report != nil && report.count > 0 {
+		if err := report.close(); err != nil { return nil, fmt.Errorf("overflow report: %v", err) }
+		if err := wl.warn(warnOverflow, "%d entry không được ghi vì vượt giới hạn output, xem %s", report.count, report.path); err != nil { return nil, err }
+	}
+	logf("Hoàn tất! Tạo: %s", strings.Join(outputs, ", "))
+	logf("Total time: %s", fmtHMS(time.Since(start)))
+	record(slog.LevelInfo, "run-done", "outputs", outputs, "sources", len(names), "entries", totalEntries,
+		"bytes", overallDone, "warnings", wl.total, "seconds", time.Since(start).Seconds())
+	return outputs, nil
 }
 
-func main() {
-	opt, err := parseFlags()
-	if err != nil { fmt.Fprintln(os.Stderr, "ERROR:", err); os.Exit(2) }
+func main() { os.Exit(run(os.Args[1:])) }
+
+// run is main without os.Exit, so the deferred cleanup (closeLog flushing
+// -log-file, the signal handler) happens on every exit path.
+func run(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "join":
+			if err := runJoin(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR join:", err); return exitFatal }
+			return exitOK
+		case "check", "-check":
+			if err := runCheck(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR check:", err); return exitFatal }
+			return exitOK
+		case "gen-fixtures":
+			if err := runGenFixtures(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR gen-fixtures:", err); return exitFatal }
+			return exitOK
+		case "plan":
+			if err := runPlan(args[1:]); err != nil {
+				if errors.Is(err, errPlanChanged) { return exitChanged }
+				fmt.Fprintln(os.Stderr, "ERROR plan:", err); return exitFatal
+			}
+			return exitOK
+		case "completion":
+			if err := runCompletion(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR completion:", err); return exitUsage }
+			return exitOK
+		case "help":
+			if err := runHelp(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR help:", err); return exitUsage }
+			return exitOK
+		case "__complete":
+			runComplete(args[1:])
+			return exitOK
+		case "list":
+			if err := runList(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR list:", err); return exitFatal }
+			return exitOK
+		case "extract":
+			warned, err := runExtract(args[1:])
+			if err != nil {
+				errorf("ERROR extract: %v", err)
+				if errors.Is(err, errCanceled) { return exitCanceled }
+				if errors.Is(err, errNoSpace) { return exitNoSpace }
+				return exitFatal
+			}
+			if warned { return exitWarnings }
+			return exitOK
+		case "config":
+			if err := runConfig(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR config:", err); return exitUsage }
+			return exitOK
+		case "verify-signature":
+			if err := runVerifySignature(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR verify-signature:", err); return exitFatal }
+			return exitOK
+		case "schema":
+			if err := runSchema(args[1:]); err != nil { fmt.Fprintln(os.Stderr, "ERROR schema:", err); return exitUsage }
+			return exitOK
+		}
+	}
+
+	opt, err := parseFlags(args)
+	if err != nil { fmt.Fprintln(os.Stderr, "ERROR:", err); return exitUsage }
 
-	outPath, err := mergeZIP(opt)
-	if err != nil { fmt.Fprintln(os.Stderr, "ERROR:", err); os.Exit(1) }
+	jobID = opt.jobID
+	if opt.toStdout { logOut = os.Stderr }
+	closeLog, err := setupLogging(opt)
+	if err != nil { fmt.Fprintln(os.Stderr, "ERROR:", err); return exitUsage }
+	defer closeLog()
+	if opt.nice {
+		if err := lowerPriority(); err != nil { errorf("WARNING: -nice: %v", err) }
+	}
+	if err := confirmDeletes(opt); err != nil { errorf("ERROR: %v", err); return exitUsage }
+	claim, prev, err := claimJob(opt)
+	if err != nil {
+		errorf("ERROR: %v", err)
+		if errors.Is(err, errJobRunning) { return exitRunning }
+		return exitUsage
+	}
+	if prev != nil { reportJob(prev); return exitOK }
+	ctx, stop := cancelOnSignal()
+	defer stop()
+	wl := newWarnLog(opt)
+	var outputs, parts []string
+	if opt.watch {
+		err = runWatch(ctx, opt, wl)
+	} else {
+		outputs, err = mergeZIP(ctx, opt, wl)
+	}
+	if s := wl.summary(); s != "" { errorf("%s", s) }
+	if err != nil {
+		errorf("ERROR: %v", err)
+		claim.finish(nil, err)
+		if errors.Is(err, errCanceled) { return exitCanceled }
+		if errors.Is(err, errNoSpace) { return exitNoSpace }
+		if errors.Is(err, errOverflow) { return exitOverflow }
+		return exitFatal
+	}
 
 	if opt.splitSize != "" {
 		if strings.ToLower(opt.splitMode) != "raw" {
-			fmt.Println("NOTE: zip-split (.z01, .z02, ...) chưa hiện thực trong Go; dùng `zip -s` bên ngoài.")
+			logf("NOTE: zip-split (.z01, .z02, ...) chưa hiện thực trong Go; dùng `zip -s` bên ngoài.")
 		}
-		if err := rawSplit(outPath, opt.splitSize, opt.rmAfterSplit); err != nil {
-			fmt.Fprintln(os.Stderr, "ERROR split:", err); os.Exit(3)
+		for _, outPath := range outputs {
+			partPaths, err := rawSplit(ctx, outPath, opt)
+			parts = append(parts, partPaths...)
+			if err != nil {
+				errorf("ERROR split: %v", err)
+				claim.finish(outputs, err)
+				if errors.Is(err, errCanceled) { return exitCanceled }
+				return exitSplit
+			}
 		}
 	}
+	if err := uploadOutputs(ctx, opt, outputs, parts); err != nil {
+		errorf("ERROR upload: %v", err)
+		claim.finish(outputs, err)
+		if errors.Is(err, errCanceled) { return exitCanceled }
+		return exitFatal
+	}
+	if opt.quotaBytes > 0 && !opt.watch { // -watch prunes after every incorporation
+		if _, err := pruneOutputs(opt, outputs, 0, 0); err != nil { _ = wl.warn(warnQuota, "%v", err) }
+	}
+	claim.finish(outputs, nil)
+	if wl.total > 0 { return exitWarnings }
+	return exitOK
 }
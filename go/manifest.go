@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// manifestEntry is one line of -manifest: where an output entry came from
+// and how it was (re)compressed. Method/CompressedSize describe the source
+// entry, OutMethod/OutCompressedSize the copy in the output; Ratio is
+// OutCompressedSize/Size. DeclaredSize is only set when the source's size
+// for the entry differs from the bytes actually copied (Size).
+type manifestEntry struct {
+	JobID             string    `json:"-"` // JSON carries it once, in the header
+	Source            string    `json:"source"`
+	Path              string    `json:"path"`
+	Target            string    `json:"target"`
+	Size              uint64    `json:"size"`
+	CompressedSize    uint64    `json:"compressed_size"`
+	CRC32             string    `json:"crc32"`
+	Modified          time.Time `json:"modified"`
+	Renamed           bool      `json:"renamed"`
+	Deduped           bool      `json:"deduped"`
+	Output            string    `json:"output,omitempty"` // set when -on-overflow rollover may spread entries over several files
+	Method            string    `json:"method,omitempty"` // empty for tar/7z sources (no per-entry method)
+	OutMethod         string    `json:"out_method"`
+	OutCompressedSize uint64    `json:"out_compressed_size,omitempty"` // zip output only; tar streams are compressed as a whole
+	Ratio             float64   `json:"ratio,omitempty"`
+	DeclaredSize      *uint64   `json:"declared_size,omitempty"`
+	packed            *uint64   // filled by packMeter once the entry is closed
+}
+
+var manifestCSVHeader = []string{"job_id", "source", "path", "target", "size", "compressed_size", "crc32", "modified", "renamed", "deduped", "output",
+	"method", "out_method", "out_compressed_size", "ratio", "declared_size"}
+
+// manifestWriter streams entries as they are written so huge merges do not
+// keep the whole list in memory.
+// The last entry is held back until the next one arrives (or close): its
+// compressed size is only known once the archive writer has moved on.
+type manifestWriter struct {
+	f       *os.File
+	bw      *bufio.Writer
+	csv     *csv.Writer
+	jobID   string
+	count   int
+	written int
+	pending *manifestEntry
+}
+
+// manifestPath puts a bare file name next to the output archive.
+func manifestPath(opt options) string {
+	if filepath.Base(opt.manifest) == opt.manifest { return filepath.Join(opt.outDir, opt.manifest) }
+	return opt.manifest
+}
+
+func newManifestWriter(path, output, jobID string) (*manifestWriter, error) {
+	f, err := os.Create(path)
+	if err != nil { return nil, err }
+	m := &manifestWriter{f: f, bw: bufio.NewWriter(f), jobID: jobID}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		m.csv = csv.NewWriter(m.bw)
+		err = m.csv.Write(manifestCSVHeader)
+	} else {
+		head, _ := json.Marshal(output)
+		job, _ := json.Marshal(jobID)
+		_, err = fmt.Fprintf(m.bw, "{\n  \"schema\": %q,\n  \"job_id\": %s,\n  \"output\": %s,\n  \"created\": %q,\n  \"entries\": [",
+			schemaID("manifest"), job, head, time.Now().Format(time.RFC3339))
+	}
+	if err != nil { _ = f.Close(); return nil, err }
+	return m, nil
+}
+
+func (m *manifestWriter) add(e manifestEntry) error {
+	m.count++
+	e.JobID = m.jobID
+	err := m.flushPending()
+	m.pending = &e
+	return err
+}
+
+func (m *manifestWriter) flushPending() error {
+	e := m.pending
+	if e == nil { return nil }
+	m.pending = nil
+	if e.packed != nil { e.OutCompressedSize = *e.packed }
+	if e.OutCompressedSize > 0 && e.Size > 0 { e.Ratio = math.Round(float64(e.OutCompressedSize)/float64(e.Size)*1e4) / 1e4 }
+	if m.csv != nil {
+		var packed, ratio, declared string
+		if e.OutCompressedSize > 0 { packed = strconv.FormatUint(e.OutCompressedSize, 10) }
+		if e.Ratio > 0 { ratio = strconv.FormatFloat(e.Ratio, 'f', 4, 64) }
+		if e.DeclaredSize != nil { declared = strconv.FormatUint(*e.DeclaredSize, 10) }
+		return m.csv.Write([]string{
+			e.JobID, e.Source, e.Path, e.Target,
+			strconv.FormatUint(e.Size, 10), strconv.FormatUint(e.CompressedSize, 10),
+			e.CRC32, e.Modified.Format(time.RFC3339),
+			strconv.FormatBool(e.Renamed), strconv.FormatBool(e.Deduped), e.Output,
+			e.Method, e.OutMethod, packed, ratio, declared,
+		})
+	}
+	b, err := json.Marshal(e)
+	if err != nil { return err }
+	sep := ","
+	if m.written == 0 { sep = "" }
+	m.written++
+	_, err = fmt.Fprintf(m.bw, "%s\n    %s", sep, b)
+	return err
+}
+
+// packMeter reports what each zip entry takes in the output. zip.Writer
+// closes an entry's compressor when the next entry (or the directory) is
+// written; the byte count then lands in the slot armed before create.
+// Entries created without arming (placeholders, directories) are not counted.
+type packMeter struct{ next *uint64 }
+
+func (m *packMeter) arm(slot *uint64) { m.next = slot }
+
+func (m *packMeter) wrap(comp zip.Compressor) zip.Compressor {
+	if m == nil { return comp }
+	return func(w io.Writer) (io.WriteCloser, error) {
+		slot := m.next
+		m.next = nil
+		if slot == nil { return comp(w) }
+		cw := &countWriter{w: w}
+		c, err := comp(cw)
+		if err != nil { return nil, err }
+		return &meteredComp{WriteCloser: c, cw: cw, slot: slot}, nil
+	}
+}
+
+type meteredComp struct {
+	io.WriteCloser
+	cw   *countWriter
+	slot *uint64
+}
+
+func (c *meteredComp) Close() error {
+	err := c.WriteCloser.Close()
+	*c.slot = uint64(c.cw.n)
+	return err
+}
+
+type storeComp struct{ io.Writer }
+
+func (storeComp) Close() error { return nil }
+
+// zipMethodName names the methods seen in practice (APPNOTE 4.4.5).
+func zipMethodName(m uint16) string {
+	switch m {
+	case zip.Store: return "store"
+	case zip.Deflate: return "deflate"
+	case zipDeflate64: return "deflate64"
+	case 12: return "bzip2"
+	case 14: return "lzma"
+	case 93: return "zstd"
+	case 95: return "xz"
+	case 99: return "aes"
+	}
+	return fmt.Sprintf("method-%d", m)
+}
+
+func (m *manifestWriter) close() error {
+	if err := m.flushPending(); err != nil { _ = m.f.Close(); return err }
+	if m.csv != nil {
+		m.csv.Flush()
+		if err := m.csv.Error(); err != nil { _ = m.f.Close(); return err }
+	} else if _, err := m.bw.WriteString("\n  ]\n}\n"); err != nil {
+		_ = m.f.Close(); return err
+	}
+	if err := m.bw.Flush(); err != nil { _ = m.f.Close(); return err }
+	return m.f.Close()
+}
@@ -0,0 +1,10 @@
+//go:build darwin
+
+package main
+
+import "syscall"
+
+// lowerPriority only renices: macOS has no ionice equivalent in syscall.
+func lowerPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, 19)
+}
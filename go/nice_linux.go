@@ -0,0 +1,30 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lowerPriority is `nice -n 19 ionice -c 3` for the running process. Both
+// are per thread on Linux, so every existing thread is changed; threads
+// started later, and the zstd/xz/7z children, inherit it.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil { return err }
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil { continue }
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, 19); err != nil { return err }
+		if _, _, e := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift); e != 0 { return e }
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+func lowerPriority() error {
+	return errors.New("-nice chưa hỗ trợ trên hệ điều hành này")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// -sort orders the sources, and therefore the entries of the output.
+const (
+	sortNatural = "natural" // part-2 before part-10
+	sortName    = "name"    // plain byte order
+	sortMtime   = "mtime"   // oldest first
+	sortSize    = "size"    // smallest first
+	sortNone    = "none"    // as listed: directory order, then -remote order
+)
+
+func validSort(s string) bool {
+	switch s {
+	case sortNatural, sortName, sortMtime, sortSize, sortNone: return true
+	}
+	return false
+}
+
+// naturalLess compares runs of digits by value, everything else bytewise.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := isDigit(a[0]), isDigit(b[0])
+		if da && db {
+			na, ra := digitRun(a)
+			nb, rb := digitRun(b)
+			ta, tb := trimZeros(na), trimZeros(nb)
+			if len(ta) != len(tb) { return len(ta) < len(tb) }
+			if ta != tb { return ta < tb }
+			if len(na) != len(nb) { return len(na) < len(nb) } // 01 before 001
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] { return a[0] < b[0] }
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func digitRun(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) { i++ }
+	return s[:i], s[i:]
+}
+
+func trimZeros(s string) string {
+	for len(s) > 1 && s[0] == '0' { s = s[1:] }
+	return s
+}
+
+// sortSources reorders names and paths together according to -sort/-reverse.
+func sortSources(opt options, names, paths []string) error {
+	idx := make([]int, len(names))
+	for i := range idx { idx[i] = i }
+	var stamps []sourceStamp
+	if opt.sortBy == sortMtime || opt.sortBy == sortSize {
+		stamps = make([]sourceStamp, len(names))
+		for i, p := range paths {
+			st, err := stampSource(p)
+			if err != nil { return fmt.Errorf("-sort %s: %v", opt.sortBy, err) }
+			stamps[i] = st
+		}
+	}
+	less := func(i, j int) bool {
+		switch opt.sortBy {
+		case sortName: return names[i] < names[j]
+		case sortMtime:
+			if !stamps[i].mod.Equal(stamps[j].mod) { return stamps[i].mod.Before(stamps[j].mod) }
+		case sortSize:
+			if stamps[i].size != stamps[j].size { return stamps[i].size < stamps[j].size }
+		case sortNone: return false
+		}
+		return naturalLess(names[i], names[j])
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		if opt.reverse { return less(idx[b], idx[a]) }
+		return less(idx[a], idx[b])
+	})
+	n2, p2 := append([]string{}, names...), append([]string{}, paths...)
+	for k, i := range idx { names[k], paths[k] = n2[i], p2[i] }
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// archiveWriter is the output side of the merge core. Entries are described
+// with zip.FileHeader regardless of format so the filtering/renaming/progress
+// code does not care what container is being written.
+type archiveWriter interface {
+	create(hdr *zip.FileHeader) (io.Writer, error)
+	close() error
+}
+
+func outputExt(format string) string {
+	switch format {
+	case "tar": return ".tar"
+	case "tgz": return ".tar.gz"
+	case "tzst": return ".tar.zst"
+	}
+	return ".zip"
+}
+
+func validFormat(format string) bool {
+	switch format {
+	case "zip", "tar", "tgz", "tzst": return true
+	}
+	return false
+}
+
+func newArchiveWriter(w io.Writer, opt options, m *packMeter) (archiveWriter, error) {
+	switch opt.format {
+	case "tar":
+		return &tarArchive{tw: tar.NewWriter(w)}, nil
+	case "tgz":
+		level := opt.deflateLevel
+		if opt.store { level = gzip.NoCompression }
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil { return nil, err }
+		return &tarArchive{tw: tar.NewWriter(gz), comp: gz}, nil
+	case "tzst":
+		zc, err := newZstdWriter(w, opt)
+		if err != nil { return nil, err }
+		return &tarArchive{tw: tar.NewWriter(zc), comp: zc}, nil
+	}
+	if opt.blockBytes > 0 {
+		cw := &captureWriter{w: w}
+		zw := zip.NewWriter(cw)
+		registerCompressors(zw, opt, m)
+		if err := zw.SetComment(opt.comment); err != nil { return nil, err }
+		return &paddedZip{zw: zw, cw: cw, block: opt.blockBytes}, nil
+	}
+	zw := zip.NewWriter(w)
+	registerCompressors(zw, opt, m)
+	if err := zw.SetComment(opt.comment); err != nil { return nil, err }
+	return &zipArchive{zw: zw}, nil
+}
+
+// outputFile is one archive being written: the file, the optional checksum
+// and spool layers, and the archive writer on top. count sees the bytes the
+// archive writer has emitted so far, which the output budgets are based on.
+type outputFile struct {
+	path     string
+	file     *os.File
+	spool    *spoolWriter
+	sidecar  hash.Hash
+	blocks   *blockWriter
+	count    *countWriter
+	aw       archiveWriter
+	meter    *packMeter // arm before create to learn the entry's compressed size
+	signer   *archiveSigner
+	entries  int
+	dirBytes int64 // central directory still to be written (zip)
+	inEntry  bool  // an entry is half-written; abort must not keep it
+	done     bool
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func createOutput(opt options, path string) (*outputFile, error) {
+	o := &outputFile{path: path, file: os.Stdout, meter: &packMeter{}} // zip.Writer never seeks: data descriptors + trailing directory
+	if !opt.toStdout {
+		create := os.Create
+		if opt.outDevice != "" { create = openDevice }
+		f, err := create(path)
+		if err != nil { return nil, err }
+		o.file = f
+	}
+	out := limitWriter(o.file, opt.writeLimit)
+	if opt.checksum != "" && !opt.toStdout {
+		o.sidecar = newChecksum(opt.checksum)
+		out = io.MultiWriter(out, o.sidecar)
+	}
+	if opt.blockBytes > 0 || opt.ckptBytes > 0 {
+		o.blocks = newBlockWriter(out, o.file, opt.blockBytes, opt.ckptBytes)
+		out = o.blocks
+	}
+	if opt.spoolDir != "" {
+		s, err := newSpoolWriter(out, opt.spoolDir, opt.spoolMB)
+		if err != nil { o.abort(); return nil, err }
+		o.spool, out = s, s
+	}
+	o.count = &countWriter{w: out}
+	var aw archiveWriter
+	var err error
+	if opt.format == "zip" && !opt.toStdout && opt.outDevice == "" && o.blocks == nil && o.spool == nil {
+		aw, err = newSegmentedZip(o.file, o.count, opt, o.meter) // a failed entry can be cut off (rollback.go)
+	} else {
+		aw, err = newArchiveWriter(o.count, opt, o.meter)
+	}
+	if err != nil { o.abort(); return nil, err }
+	o.aw = aw
+	if opt.signPriv != nil { o.signer = newArchiveSigner(opt.signPriv) }
+	return o, nil
+}
+
+func openAppendOutput(opt options, path string) (*outputFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil { return nil, err }
+	meter := &packMeter{}
+	aw, err := openAppendArchive(f, opt, meter)
+	if err != nil { _ = f.Close(); return nil, fmt.Errorf("-append %s: %v", path, err) }
+	return &outputFile{path: path, file: f, aw: aw, meter: meter, count: &countWriter{}}, nil
+}
+
+// finish closes the layers top-down and records the -checksum line.
+func (o *outputFile) finish(opt options, buf []byte) error {
+	o.done = true
+	if o.signer != nil {
+		if err := o.signer.embed(o.aw); err != nil { _ = o.file.Close(); return err }
+	}
+	if err := o.aw.close(); err != nil { _ = o.file.Close(); return err }
+	if o.spool != nil {
+		logf("Flushing spool...")
+		if err := o.spool.close(); err != nil { _ = o.file.Close(); return err }
+	}
+	if o.blocks != nil {
+		if err := o.blocks.close(); err != nil { _ = o.file.Close(); return err }
+	}
+	if opt.outDevice != "" {
+		_ = o.file.Sync() // block devices; EINVAL on a FIFO
+		logf("Đã ghi %s (%d bytes) vào %s", humanBytes(uint64(o.count.n)), o.count.n, o.path)
+	}
+	if err := o.file.Close(); err != nil { return err }
+	if opt.checksum == "" || opt.toStdout { return nil }
+	var sum string
+	if o.sidecar != nil {
+		sum = hex.EncodeToString(o.sidecar.Sum(nil))
+	} else {
+		var err error
+		if sum, err = hashFile(o.path, opt.checksum, buf); err != nil { return err } // -append rewrote the file in place
+	}
+	return updateChecksums(opt, o.path, false, []checksumLine{{sum, filepath.Base(o.path)}})
+}
+
+// abort releases everything after a failure. For -append this still writes
+// a directory, so the old archive stays readable; an entry cut off mid-copy
+// (cancel, write error) is rolled back first. A new archive is not finalized
+// in that case: the file is removed anyway, and a reader of -out - gets an
+// unterminated stream instead of a valid-looking zip with a truncated entry.
+func (o *outputFile) abort() {
+	if o.done { return }
+	o.done = true
+	if a, ok := o.aw.(*zipAppendArchive); ok && o.inEntry {
+		a.rollback = true
+		_ = a.close()
+	} else if o.aw != nil && !o.inEntry {
+		_ = o.aw.close()
+	}
+	if o.spool != nil { _ = o.spool.close() }
+	_ = o.file.Close()
+}
+
+type zipArchive struct{ zw *zip.Writer }
+
+func (a *zipArchive) create(hdr *zip.FileHeader) (io.Writer, error) { return a.zw.CreateHeader(hdr) }
+func (a *zipArchive) close() error                                  { return a.zw.Close() }
+
+type tarArchive struct {
+	tw   *tar.Writer
+	comp io.WriteCloser
+}
+
+func (a *tarArchive) create(hdr *zip.FileHeader) (io.Writer, error) {
+	th := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     hdr.Name,
+		Size:     int64(hdr.UncompressedSize64),
+		Mode:     0o644,
+		ModTime:  hdr.Modified,
+		Format:   tar.FormatPAX,
+	}
+	if strings.HasSuffix(hdr.Name, "/") { th.Typeflag, th.Size, th.Mode = tar.TypeDir, 0, 0o755 } // -keep-dirs
+	if err := a.tw.WriteHeader(th); err != nil { return nil, err }
+	return a.tw, nil
+}
+
+func (a *tarArchive) close() error {
+	err := a.tw.Close()
+	if a.comp != nil {
+		if cErr := a.comp.Close(); err == nil { err = cErr }
+	}
+	return err
+}
+
+// zstd is not in the standard library; stream through the `zstd` CLI instead
+// (same approach as the `zip -s` hint for multi-part zips).
+type zstdWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func newZstdWriter(w io.Writer, opt options) (io.WriteCloser, error) {
+	args := []string{"-q", "-c", "-T0"}
+	if opt.store {
+		args = append(args, "-1")
+	} else if opt.deflateLevel >= 1 && opt.deflateLevel <= 19 {
+		args = append(args, "-"+strconv.Itoa(opt.deflateLevel))
+	}
+	cmd := exec.Command("zstd", args...)
+	cmd.Stdout = w
+	in, err := cmd.StdinPipe()
+	if err != nil { return nil, err }
+	if err := cmd.Start(); err != nil { return nil, fmt.Errorf("không chạy được zstd: %v", err) }
+	return &zstdWriter{WriteCloser: in, cmd: cmd}, nil
+}
+
+func (z *zstdWriter) Close() error {
+	err := z.WriteCloser.Close()
+	if wErr := z.cmd.Wait(); err == nil && wErr != nil {
+		err = fmt.Errorf("zstd: %v", wErr)
+	}
+	return err
+}
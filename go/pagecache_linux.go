@@ -0,0 +1,14 @@
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadvDontNeed = 4 // POSIX_FADV_DONTNEED
+
+func dropPageCache(f *os.File) {
+	_, _, _ = syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, fadvDontNeed, 0, 0)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"time"
+)
+
+// -on-entry-error placeholder: a source entry that cannot be read still
+// leaves a trace in the output, <target>.MISSING holding the error, so
+// whoever unpacks the merge sees the gap instead of finding it later. The
+// warning is issued either way.
+const (
+	entryErrSkip        = "skip"
+	entryErrPlaceholder = "placeholder"
+	placeholderSuffix   = ".MISSING"
+)
+
+func validateEntryError(opt *options) error {
+	switch opt.onEntryError {
+	case entryErrSkip, entryErrPlaceholder: return nil
+	}
+	return fmt.Errorf("-on-entry-error không hợp lệ: %q (skip|placeholder)", opt.onEntryError)
+}
+
+func placeholderText(source string, e *sourceEntry, cause error) []byte {
+	return []byte(fmt.Sprintf("source: %s\nentry: %s\nsize: %d\nerror: %v\n", source, e.Name, e.Size, cause))
+}
+
+// addPlaceholder writes the marker for e next to target. Its name is claimed
+// in dedup like any entry's: renamed if <target>.MISSING already exists, and
+// a real entry of that name coming later is renamed instead.
+func addPlaceholder(opt options, out *outputFile, dedup map[string]int, source string, e *sourceEntry, target string, cause error) error {
+	name := claimName(target+placeholderSuffix, dedup)
+	body := placeholderText(source, e, cause)
+	if limit := out.overBudget(opt, uint64(len(body)), name, ""); limit != "" {
+		return fmt.Errorf("không ghi placeholder %s: vượt %s", name, limit)
+	}
+	hdr := &zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now(), UncompressedSize64: uint64(len(body))}
+	w, err := out.create(hdr)
+	if err != nil { return err }
+	out.added(opt, name, "")
+	_, err = w.Write(body)
+	return err
+}
+
+// extractPlaceholder is the extract counterpart: <dst>.MISSING on disk.
+func extractPlaceholder(dst, source string, e *sourceEntry, cause error) error {
+	return os.WriteFile(dst+placeholderSuffix, placeholderText(source, e, cause), 0o644)
+}
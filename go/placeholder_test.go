@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readAll reads every entry of a zip and returns the names of those that
+// fail, or nil if the zip itself does not open.
+func readAll(path string) (names []string, bad map[string]bool) {
+	zr, err := zip.OpenReader(path)
+	if err != nil { return nil, nil }
+	defer zr.Close()
+	bad = map[string]bool{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		rc, err := f.Open()
+		if err == nil {
+			_, err = io.Copy(io.Discard, rc)
+			rc.Close()
+		}
+		if err != nil { bad[f.Name] = true }
+	}
+	return names, bad
+}
+
+// checkEntries checks that a merged zip reads back in full, holds none of
+// the unreadable source entries and no duplicate names, and returns its
+// placeholders.
+func checkEntries(t *testing.T, path string, unreadable map[string]bool) []string {
+	t.Helper()
+	list, bad := readAll(path)
+	if bad == nil { t.Fatalf("%s does not open", path) }
+	for name := range bad { t.Errorf("%s: %s does not read back", filepath.Base(path), name) }
+	var missing []string
+	names := map[string]bool{}
+	for _, name := range list {
+		if names[name] { t.Errorf("%s: duplicate entry %s", filepath.Base(path), name) }
+		names[name] = true
+		if unreadable[name] { t.Errorf("%s: unreadable source entry %s made it into the output", filepath.Base(path), name) }
+		if strings.HasSuffix(name, placeholderSuffix) { missing = append(missing, name) }
+	}
+	for _, m := range missing {
+		if names[strings.TrimSuffix(m, placeholderSuffix)] { t.Errorf("%s written next to its placeholder", strings.TrimSuffix(m, placeholderSuffix)) }
+	}
+	return missing
+}
+
+// An entry whose read fails half way (encrypted, bad CRC) is cut out of the
+// output again, into a new archive and with -append alike; with
+// -on-entry-error placeholder only its .MISSING is left.
+func TestReadErrorDropsEntry(t *testing.T) {
+	in, good := t.TempDir(), t.TempDir()
+	if err := genFixtures(fixtureSpec{dir: in, zips: 2, entries: 10, size: 1 << 10, broken: 2, encrypted: 1, seed: 5}); err != nil { t.Fatal(err) }
+	writeTestZip(t, filepath.Join(good, "good.zip"), 3, 0)
+	unreadable := map[string]bool{}
+	for i := 1; i <= 4; i++ {
+		_, bad := readAll(filepath.Join(in, fmt.Sprintf("part-%d.zip", i)))
+		for name := range bad { unreadable[name] = true }
+	}
+	if len(unreadable) == 0 { t.Fatal("fixtures have no entry that fails half way") }
+	for _, mode := range []string{entryErrSkip, entryErrPlaceholder} {
+		for _, appendOut := range []bool{false, true} {
+			out := t.TempDir()
+			args := []string{"-input", in, "-outdir", out, "-out", "m", "-on-entry-error", mode}
+			if appendOut {
+				runMerge(t, "-input", good, "-outdir", out, "-out", "m", "-append")
+				args = append(args, "-append")
+			}
+			runMerge(t, args...)
+			missing := checkEntries(t, filepath.Join(out, "m.zip"), unreadable)
+			if want := mode == entryErrPlaceholder; want != (len(missing) > 0) { t.Errorf("%s, append %v: placeholders %v", mode, appendOut, missing) }
+		}
+	}
+}
+
+// A source that already has X.MISSING before an unreadable X: the placeholder
+// must take a __dup name instead of writing X.MISSING a second time.
+func TestPlaceholderNameTaken(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	f, err := os.Create(filepath.Join(in, "src.zip"))
+	if err != nil { t.Fatal(err) }
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "a.txt" + placeholderSuffix, Method: zip.Store})
+	if err != nil { t.Fatal(err) }
+	if _, err := io.WriteString(w, "real file\n"); err != nil { t.Fatal(err) }
+	body := "bad crc\n"
+	w, err = zw.CreateRaw(&zip.FileHeader{Name: "a.txt", Method: zip.Store, CRC32: 1, CompressedSize64: uint64(len(body)), UncompressedSize64: uint64(len(body))})
+	if err != nil { t.Fatal(err) }
+	if _, err := io.WriteString(w, body); err != nil { t.Fatal(err) }
+	if err := zw.Close(); err != nil { t.Fatal(err) }
+	if err := f.Close(); err != nil { t.Fatal(err) }
+
+	runMerge(t, "-input", in, "-outdir", out, "-out", "m", "-on-entry-error", entryErrPlaceholder)
+	missing := checkEntries(t, filepath.Join(out, "m.zip"), map[string]bool{"a.txt": true})
+	if len(missing) != 2 { t.Fatalf("placeholders %v; want the real a.txt.MISSING and a renamed one", missing) }
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A plan is what a merge with the same flags would do, without writing
+// anything. Scheduled jobs keep the last one and run
+// `plan -o today.plan.json -compare yesterday.plan.json` to be alerted when
+// the input set changes unexpectedly.
+
+type planSource struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Entries  int       `json:"entries"`
+	Bytes    uint64    `json:"bytes"`
+	Skipped  string    `json:"skipped,omitempty"`
+}
+
+type planEntry struct {
+	Source string `json:"source"`
+	Path   string `json:"path"`
+	Target string `json:"target"`
+	Size   uint64 `json:"size"`
+	CRC32  string `json:"crc32,omitempty"` // zip sources only
+}
+
+type mergePlan struct {
+	Schema  string       `json:"schema"`
+	JobID   string       `json:"job_id"`
+	Created time.Time    `json:"created"`
+	Output  string       `json:"output"`
+	Sources []planSource `json:"sources"`
+	Entries []planEntry  `json:"entries"`
+	Bytes   uint64       `json:"bytes"`
+}
+
+func buildPlan(opt options) (*mergePlan, error) {
+	names, paths, err := mergeSources(opt)
+	if err != nil { return nil, err }
+	outPath := outputPath(opt)
+	dedup := map[string]int{}
+	var existing *existingArchive
+	if opt.appendOut {
+		if _, err := os.Stat(outPath); err == nil {
+			if existing, err = loadExistingArchive(opt, outPath, dedup); err != nil { return nil, err }
+		}
+	}
+
+	p := &mergePlan{Schema: schemaID("plan"), JobID: opt.jobID, Created: time.Now(), Output: outPath}
+	scans := make([]sourceScan, len(names))
+	hot := make([]bool, len(names))
+	for i, name := range names {
+		scans[i] = scanSource(opt, existing, name, paths[i])
+		hot[i] = scans[i].hot
+	}
+	order := priorityOrder(hot)
+	if opt.jarMode != jarOff { claimHeld(opt, scans, order, dedup) }
+
+	for _, i := range order {
+		name, sc := names[i], scans[i]
+		src := planSource{Name: name, Path: paths[i], Size: sc.stamp.size, Modified: sc.stamp.mod}
+		switch {
+		case sc.err != nil:
+			src.Skipped = fmt.Sprintf("không mở được: %v", sc.err)
+		case sc.merged:
+			src.Skipped = "đã có trong output (-append)"
+		default:
+			promoteListing(opt, sc.entries)
+			for _, e := range sc.entries {
+				pe := planEntry{Source: name, Path: e.Name, Size: e.Size}
+				switch selectEntry(opt, e) {
+				case entryCopy:
+					if !e.IsDir && existing.has(opt, name, e) { continue }
+					var ok bool
+					if pe.Target, _, ok = claimTarget(opt, name, e, dedup); !ok { continue }
+				case entryJarHeld:
+					pe.Target = heldName(e.Name) // one file for all sources, see jarmode.go
+				default:
+					continue
+				}
+				if e.zf != nil { pe.CRC32 = fmt.Sprintf("%08x", e.CRC32) }
+				p.Entries = append(p.Entries, pe)
+				src.Entries++
+				src.Bytes += e.Size
+			}
+			p.Bytes += src.Bytes
+		}
+		p.Sources = append(p.Sources, src)
+	}
+	return p, nil
+}
+
+// claimHeld claims the names -jar-mode writes before any other entry, in
+// the order jarMeta.write uses: the manifest, then the services files as
+// first seen.
+func claimHeld(opt options, scans []sourceScan, order []int, dedup map[string]int) {
+	manifest, seen := false, map[string]bool{}
+	var services []string
+	for _, i := range order {
+		for _, e := range scans[i].entries {
+			if selectEntry(opt, e) != entryJarHeld { continue }
+			name := heldName(e.Name)
+			switch {
+			case name == jarManifestPath: manifest = true
+			case !seen[name]: seen[name] = true; services = append(services, name)
+			}
+		}
+	}
+	if manifest { dedup[jarManifestPath]++ }
+	for _, name := range services { dedup[name]++ }
+}
+
+type planDiff struct {
+	Changed bool `json:"changed"`
+	Sources struct {
+		Added   []string `json:"added"`
+		Removed []string `json:"removed"`
+		Changed []string `json:"changed"` // size or mtime differ
+	} `json:"sources"`
+	Entries struct {
+		Added   []string `json:"added"`   // targets
+		Removed []string `json:"removed"` // targets in the previous plan
+		Changed []string `json:"changed"` // same source/path, different size or CRC32
+	} `json:"entries"`
+	Bytes struct {
+		Before int64 `json:"before"`
+		After  int64 `json:"after"`
+		Delta  int64 `json:"delta"`
+	} `json:"bytes"`
+}
+
+func comparePlans(prev, cur *mergePlan) *planDiff {
+	d := &planDiff{}
+	d.Sources.Added, d.Sources.Removed, d.Sources.Changed = []string{}, []string{}, []string{}
+	d.Entries.Added, d.Entries.Removed, d.Entries.Changed = []string{}, []string{}, []string{}
+
+	oldSrc := map[string]planSource{}
+	for _, s := range prev.Sources { oldSrc[s.Name] = s }
+	for _, s := range cur.Sources {
+		o, ok := oldSrc[s.Name]
+		switch {
+		case !ok: d.Sources.Added = append(d.Sources.Added, s.Name)
+		case o.Size != s.Size || !o.Modified.Equal(s.Modified): d.Sources.Changed = append(d.Sources.Changed, s.Name)
+		}
+		delete(oldSrc, s.Name)
+	}
+	for n := range oldSrc { d.Sources.Removed = append(d.Sources.Removed, n) }
+	sort.Strings(d.Sources.Removed)
+
+	key := func(e planEntry) string { return e.Source + "\x00" + e.Path }
+	oldEnt := map[string]planEntry{}
+	for _, e := range prev.Entries { oldEnt[key(e)] = e }
+	for _, e := range cur.Entries {
+		o, ok := oldEnt[key(e)]
+		switch {
+		case !ok: d.Entries.Added = append(d.Entries.Added, e.Target)
+		case o.Size != e.Size || o.CRC32 != e.CRC32: d.Entries.Changed = append(d.Entries.Changed, e.Target)
+		}
+		delete(oldEnt, key(e))
+	}
+	for _, e := range oldEnt { d.Entries.Removed = append(d.Entries.Removed, e.Target) }
+	sort.Strings(d.Entries.Removed)
+
+	d.Bytes.Before, d.Bytes.After = int64(prev.Bytes), int64(cur.Bytes)
+	d.Bytes.Delta = d.Bytes.After - d.Bytes.Before
+	d.Changed = len(d.Sources.Added)+len(d.Sources.Removed)+len(d.Sources.Changed)+
+		len(d.Entries.Added)+len(d.Entries.Removed)+len(d.Entries.Changed) > 0
+	return d
+}
+
+// takeArg removes `-name v`, `--name v` or `-name=v` from args.
+func takeArg(args []string, name string) (string, []string, error) {
+	for i, a := range args {
+		if a == "--" { break }
+		t := strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if t == name {
+			if i+1 >= len(args) { return "", nil, fmt.Errorf("-%s cần giá trị", name) }
+			return args[i+1], append(append([]string{}, args[:i]...), args[i+2:]...), nil
+		}
+		if strings.HasPrefix(t, name+"=") && t != a {
+			return t[len(name)+1:], append(append([]string{}, args[:i]...), args[i+1:]...), nil
+		}
+	}
+	return "", args, nil
+}
+
+// takeFlag removes a boolean subcommand flag (-name or --name) from args.
+func takeFlag(args []string, name string) (bool, []string) {
+	for i, a := range args {
+		if a == "--" { break }
+		if a == "-"+name || a == "--"+name {
+			return true, append(append([]string{}, args[:i]...), args[i+1:]...)
+		}
+	}
+	return false, args
+}
+
+// runPlan implements `plan [-o new.plan.json] [-compare previous.plan.json] [merge flags...]`.
+func runPlan(args []string) error {
+	out, args, err := takeArg(args, "o")
+	if err != nil { return err }
+	prevPath, args, err := takeArg(args, "compare")
+	if err != nil { return err }
+	logOut = os.Stderr // stdout carries the JSON
+	opt, err := parseFlags(args)
+	if err != nil { return err }
+	jobID = opt.jobID
+
+	var prev *mergePlan
+	if prevPath != "" {
+		b, err := os.ReadFile(prevPath)
+		if err != nil { return err }
+		prev = &mergePlan{}
+		if err := json.Unmarshal(b, prev); err != nil { return fmt.Errorf("%s: %v", prevPath, err) }
+	}
+	p, err := buildPlan(opt)
+	if err != nil { return err }
+	body, err := json.MarshalIndent(p, "", "  ")
+	if err != nil { return err }
+	if out != "" {
+		if err := os.WriteFile(out, append(body, '\n'), 0o644); err != nil { return err }
+		logf("Plan: %s (%d nguồn, %d entries, %s)", out, len(p.Sources), len(p.Entries), humanBytes(p.Bytes))
+	}
+	if prev == nil {
+		if out == "" { fmt.Println(string(body)) }
+		return nil
+	}
+	d := comparePlans(prev, p)
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil { return err }
+	fmt.Println(string(b))
+	if d.Changed { return errPlanChanged }
+	return nil
+}
+
+var errPlanChanged = errors.New("plan khác với lần trước")
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The plan must name the targets the merge writes, __dupN numbers included,
+// also when -priority reorders the sources.
+func TestPlanMatchesMerge(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	if err := genFixtures(fixtureSpec{dir: in, zips: 3, entries: 20, size: 1 << 10, dup: 0.5, seed: 7}); err != nil { t.Fatal(err) }
+	args := []string{"-input", in, "-outdir", out, "-out", "m", "-priority", "part-3.zip"}
+
+	opt, err := parseFlags(args)
+	if err != nil { t.Fatal(err) }
+	p, err := buildPlan(opt)
+	if err != nil { t.Fatal(err) }
+	runMerge(t, append(args, "-manifest", "m.json")...)
+
+	b, err := os.ReadFile(filepath.Join(out, "m.json"))
+	if err != nil { t.Fatal(err) }
+	var mf struct{ Entries []manifestEntry `json:"entries"` }
+	if err := json.Unmarshal(b, &mf); err != nil { t.Fatal(err) }
+	got := map[string]string{}
+	for _, e := range mf.Entries { got[e.Source+"\x00"+e.Path] = e.Target }
+	if len(got) != len(p.Entries) { t.Errorf("plan has %d entries, merge wrote %d", len(p.Entries), len(got)) }
+	for _, e := range p.Entries {
+		if g := got[e.Source+"\x00"+e.Path]; g != e.Target { t.Errorf("%s:%s: plan %q, merge %q", e.Source, e.Path, e.Target, g) }
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// prefetcher overlaps input and output I/O: a goroutine fills chunk buffers
+// from the current source entry while the merge loop drains the previous one
+// into the output. With two buffers (-read-ahead 2) this is plain double
+// buffering; more buffers absorb bursty sources (remote, xz/zstd pipes).
+type prefetcher struct {
+	full chan chunk
+	free chan []byte
+	quit chan struct{}
+}
+
+type chunk struct {
+	b   []byte
+	err error // io.EOF after the last chunk
+}
+
+// newPrefetcher starts reading src into bufs. Every chunk returned by next
+// must be handed back with release; stop must be called before src is closed.
+func newPrefetcher(src io.Reader, bufs [][]byte) *prefetcher {
+	p := &prefetcher{
+		full: make(chan chunk, len(bufs)), free: make(chan []byte, len(bufs)),
+		quit: make(chan struct{}),
+	}
+	for _, b := range bufs { p.free <- b }
+	go p.run(src)
+	return p
+}
+
+func (p *prefetcher) run(src io.Reader) {
+	defer close(p.full)
+	for {
+		var b []byte
+		select {
+		case b = <-p.free:
+		case <-p.quit:
+			return
+		}
+		// Fill the whole buffer: decompressors return a few KB per Read, the
+		// writer side wants large writes. quit is checked between Reads so
+		// stop never waits for more than one of them.
+		n := 0
+		var err error
+		for n < len(b) && err == nil {
+			select {
+			case <-p.quit:
+				return
+			default:
+			}
+			var m int
+			m, err = src.Read(b[n:])
+			n += m
+		}
+		select {
+		case p.full <- chunk{b[:n], err}:
+		case <-p.quit:
+			return
+		}
+		if err != nil { return }
+	}
+}
+
+// next returns the next chunk of data; err is io.EOF at the end of the entry
+// and errCanceled as soon as ctx is done, even while a slow Read is pending.
+func (p *prefetcher) next(ctx context.Context) ([]byte, error) {
+	select {
+	case c, ok := <-p.full:
+		if !ok { return nil, io.EOF }
+		return c.b, c.err
+	case <-ctx.Done():
+		return nil, errCanceled
+	}
+}
+
+func (p *prefetcher) release(b []byte) {
+	if b != nil { p.free <- b[:cap(b)] }
+}
+
+// stop ends the reader goroutine and waits for it, so the entry (and, for tar
+// sources, the archive stream) is no longer touched once it returns. A Read
+// already in flight is allowed to finish.
+func (p *prefetcher) stop() {
+	close(p.quit)
+	for range p.full {}
+}
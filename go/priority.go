@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// -priority lets the data that matters most land first in a long merge:
+// sources whose name matches one of the globs, or that hold a matching
+// entry, are merged before the others, and inside a zip source the matching
+// entries are copied first. -sort still orders each group. A glob without a
+// slash also matches the base name of an entry ("*.csv"). Tarballs and 7z
+// archives are streams, so their entries keep their own order.
+
+func validatePriority(opt *options) error {
+	for _, g := range opt.priority {
+		if _, err := path.Match(g, ""); err != nil { return fmt.Errorf("-priority: glob không hợp lệ %q", g) }
+	}
+	return nil
+}
+
+func isPriority(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok { return true }
+		if ok, _ := path.Match(g, path.Base(name)); ok { return true }
+	}
+	return false
+}
+
+// hasPriorityEntry reports whether a source listing holds an entry the merge
+// would take that matches -priority.
+func hasPriorityEntry(opt options, entries []*sourceEntry) bool {
+	for _, e := range entries {
+		if !e.IsDir && wantEntry(opt, e) && isPriority(opt.priority, e.Name) { return true }
+	}
+	return false
+}
+
+// priorityOrder is the order the sources are merged in: the hot ones first,
+// each group in -sort order.
+func priorityOrder(hot []bool) []int {
+	order := make([]int, len(hot))
+	for i := range order { order[i] = i }
+	sort.SliceStable(order, func(a, b int) bool { return hot[order[a]] && !hot[order[b]] })
+	return order
+}
+
+// promoteEntries moves the -priority entries of a zip source to the front.
+func promoteEntries(opt options, ar archiveReader) {
+	g, ok := ar.(guardedSource)
+	if !ok { return }
+	zs, ok := g.archiveReader.(*zipSource)
+	if !ok || len(opt.priority) == 0 { return }
+	files := zs.zr.File
+	sort.SliceStable(files, func(a, b int) bool {
+		return isPriority(opt.priority, files[a].Name) && !isPriority(opt.priority, files[b].Name)
+	})
+}
+
+// promoteListing is promoteEntries for a listing (plan.go): the merge
+// reorders zip sources only.
+func promoteListing(opt options, entries []*sourceEntry) {
+	if len(opt.priority) == 0 || len(entries) == 0 || entries[0].zf == nil { return }
+	sort.SliceStable(entries, func(a, b int) bool {
+		return isPriority(opt.priority, entries[a].Name) && !isPriority(opt.priority, entries[b].Name)
+	})
+}
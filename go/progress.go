@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// -progress summary is for unattended runs whose output ends up in a cron
+// mail: instead of the \r bar (one huge "line" in a mail) or nothing (-q),
+// one plain ASCII status line every -summary-interval, plus one when the
+// run reaches 100%.
+
+const (
+	progressBar     = "bar"
+	progressSummary = "summary"
+	progressNone    = "none"
+)
+
+var (
+	progressMode = progressBar
+	summaryEvery = 60 * time.Second
+	lastSummary  time.Time
+	summaryEnded bool
+)
+
+func printSummary(prefix string, zp, ap int, overallDone, overallTotal uint64, start time.Time) {
+	now := time.Now()
+	final := ap == 100 && overallDone >= overallTotal
+	if final && summaryEnded { return }
+	if !final && !lastSummary.IsZero() && now.Sub(lastSummary) < summaryEvery { return }
+	lastSummary, summaryEnded = now, final
+	elapsed := now.Sub(start)
+	eta := etaString(overallDone, overallTotal, elapsed)
+	if at, ok := finishAt(overallDone, overallTotal, elapsed); ok { eta += " (~" + at.Format("2006-01-02 15:04 MST") + ")" }
+	fmt.Fprintf(logOut, "%s %s%s %d%% | Overall %d%% (%s/%s) | Elapsed %s | ETA %s\n",
+		now.Format("2006-01-02 15:04:05"), jobTag(), asciiOnly(prefix), zp,
+		ap, humanBytes(overallDone), humanBytes(overallTotal), fmtHMS(elapsed), eta)
+}
+
+// Elapsed time and ETA are durations between time.Now() readings, which Go
+// measures on the monotonic clock: NTP steps, DST changes and a manual
+// `date -s` during a 12-hour merge do not move them. Only the expected
+// finish is wall-clock time, computed fresh as now + remaining, so it shows
+// the local time (and zone, e.g. CET vs CEST) the run should end at. start
+// must stay the value time.Now() returned; .UTC()/.Local()/.Round(0) would
+// drop the monotonic reading.
+
+// etaRemaining extrapolates the remaining time from the average speed so far.
+func etaRemaining(done, total uint64, elapsed time.Duration) (time.Duration, bool) {
+	if done == 0 || done >= total || elapsed <= 0 { return 0, false }
+	speed := float64(done) / elapsed.Seconds()
+	if speed <= 0 { return 0, false }
+	return time.Duration(float64(total-done)/speed) * time.Second, true
+}
+
+func etaString(done, total uint64, elapsed time.Duration) string {
+	rem, ok := etaRemaining(done, total, elapsed)
+	if !ok { return "--:--:--" }
+	return fmtHMS(rem)
+}
+
+// finishAt is the local wall-clock time the run is expected to end.
+func finishAt(done, total uint64, elapsed time.Duration) (time.Time, bool) {
+	rem, ok := etaRemaining(done, total, elapsed)
+	if !ok { return time.Time{}, false }
+	return time.Now().Add(rem), true
+}
+
+// finishString is finishAt for the progress bar: the time of day, with the
+// date only when the run ends on another day.
+func finishString(done, total uint64, elapsed time.Duration) string {
+	at, ok := finishAt(done, total, elapsed)
+	if !ok { return "" }
+	if now := time.Now(); at.YearDay() == now.YearDay() && at.Year() == now.Year() { return at.Format("15:04 MST") }
+	return at.Format("01-02 15:04 MST")
+}
+
+// asciiOnly replaces anything outside printable ASCII (source names can be
+// anything) so the line survives any mail transport.
+func asciiOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e { return '?' }
+		return r
+	}, s)
+}
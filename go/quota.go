@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -quota caps the total size of the output directory. With -prune, earlier
+// outputs (and their split parts) sharing the -out prefix are deleted oldest
+// first until the run fits; the newest -keep of them are never touched.
+
+var partSuffixRe = regexp.MustCompile(`\.part-[0-9]+$`)
+
+// outputGroup is one earlier output: the archive plus its split parts.
+type outputGroup struct {
+	stem  string
+	files []string
+	size  int64
+	mod   time.Time
+}
+
+func isOutputName(stem string) bool {
+	for _, f := range []string{"zip", "tar", "tgz", "tzst"} {
+		if strings.HasSuffix(stem, outputExt(f)) { return true }
+	}
+	return false
+}
+
+// scanOutputs sums every file in the output directory and groups the prunable
+// ones (name starts with -out, is an archive or part, is not current).
+func scanOutputs(opt options, current []string) ([]*outputGroup, int64, error) {
+	skip := map[string]bool{}
+	for _, c := range current { skip[filepath.Base(c)] = true }
+	ents, err := os.ReadDir(opt.outDir)
+	if err != nil { return nil, 0, err }
+	byStem := map[string]*outputGroup{}
+	var used int64
+	for _, e := range ents {
+		if !e.Type().IsRegular() { continue }
+		info, err := e.Info()
+		if err != nil { continue }
+		used += info.Size()
+		stem := partSuffixRe.ReplaceAllString(e.Name(), "")
+		if !strings.HasPrefix(stem, opt.outBase) || !isOutputName(stem) || skip[stem] { continue }
+		g := byStem[stem]
+		if g == nil { g = &outputGroup{stem: stem}; byStem[stem] = g }
+		g.files = append(g.files, filepath.Join(opt.outDir, e.Name()))
+		g.size += info.Size()
+		if info.ModTime().After(g.mod) { g.mod = info.ModTime() }
+	}
+	groups := make([]*outputGroup, 0, len(byStem))
+	for _, g := range byStem { groups = append(groups, g) }
+	sort.Slice(groups, func(i, j int) bool { return groups[i].mod.Before(groups[j].mod) })
+	return groups, used, nil
+}
+
+// pruneOutputs makes room for need more bytes: under -quota, and (when free
+// is known) on the disk itself. It returns the bytes deleted, and errNoSpace
+// if the quota still cannot be met.
+func pruneOutputs(opt options, current []string, need int64, free uint64) (int64, error) {
+	if opt.quotaBytes <= 0 && !opt.prune { return 0, nil }
+	groups, used, err := scanOutputs(opt, current)
+	if err != nil { return 0, err }
+	over := func(freed int64) bool {
+		if opt.quotaBytes > 0 && used-freed+need > opt.quotaBytes { return true }
+		return free > 0 && int64(free)+freed < need
+	}
+	var freed int64
+	if opt.prune {
+		for i := 0; i < len(groups)-opt.keep && over(freed); i++ {
+			g := groups[i]
+			for _, f := range g.files {
+				if err := removeByOption(opt, f, "-prune"); err != nil { return freed, err }
+			}
+			freed += g.size
+			verb := "xoá"
+			if opt.simDeletes { verb = "sẽ xoá (giả lập)" }
+			logf("Prune: %s %s (%d file, %s, %s)", verb, g.stem, len(g.files), humanBytes(uint64(g.size)), g.mod.Format("2006-01-02 15:04"))
+		}
+	}
+	if opt.quotaBytes > 0 && used-freed+need > opt.quotaBytes {
+		return freed, fmt.Errorf("%w: vượt -quota %s ở %s (đang dùng %s, cần thêm %s)", errNoSpace,
+			humanBytes(uint64(opt.quotaBytes)), opt.outDir, humanBytes(uint64(used-freed)), humanBytes(uint64(need)))
+	}
+	return freed, nil
+}
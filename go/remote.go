@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remote sources (-remote): zips are read with HTTP range requests, so only
+// the central directory and the entries themselves cross the wire and no
+// local copy is made. Tarballs are streamed with one plain GET.
+
+const (
+	remoteBlock  = 4 * 1024 * 1024 // bytes per range request
+	remoteBlocks = 4               // blocks kept in memory per source
+)
+
+// remoteClient serves HEAD and range requests, each at most remoteBlock
+// bytes. remoteStreamClient has no overall timeout, like uploadClient: a
+// whole tarball may take hours to stream.
+var (
+	remoteClient       = &http.Client{Timeout: 10 * time.Minute}
+	remoteStreamClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, ResponseHeaderTimeout: 5 * time.Minute}}
+)
+
+func isRemote(p string) bool {
+	lower := strings.ToLower(p)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "s3://")
+}
+
+// remoteName is the display/mapping name of a remote source: its last path element.
+func remoteName(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Path == "" { return raw }
+	return path.Base(u.Path)
+}
+
+// collectSources returns the display names and open paths of all sources:
+// local files matching -filter in -input, then the -remote URLs, ordered by -sort.
+func collectSources(opt options) (names, paths []string, err error) {
+	local := true
+	if len(opt.remote) > 0 {
+		if _, err := os.Stat(opt.inputDir); err != nil { local = false }
+	}
+	if local {
+		if names, err = listZipFiles(opt.inputDir, opt.filterGlob); err != nil { return nil, nil, err }
+		for _, n := range names { paths = append(paths, filepath.Join(opt.inputDir, n)) }
+	}
+	for _, r := range opt.remote {
+		if !isSourceName(remoteName(r)) { return nil, nil, fmt.Errorf("-remote: không nhận ra loại archive: %s", r) }
+		names = append(names, remoteName(r))
+		paths = append(paths, r)
+	}
+	if err := sortSources(opt, names, paths); err != nil { return nil, nil, err }
+	return names, paths, nil
+}
+
+// Payload hashes for signV4: body-less requests sign the hash of nothing,
+// uploads do not hash their (multi-GB) body up front.
+const (
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+)
+
+// remoteRequest builds a request for an http(s) or s3 URL, signing s3 ones
+// when AWS credentials are present in the environment.
+func remoteRequest(method, raw string) (*http.Request, error) {
+	return remoteRequestBody(method, raw, nil, 0, emptyPayloadHash, nil)
+}
+
+// remoteRequestBody is remoteRequest with a request body of size bytes and
+// extra headers (signed with the request). An s3 URL may carry a query, which
+// must already be in canonical (sorted, escaped) form.
+func remoteRequestBody(method, raw string, body io.ReadCloser, size int64, payloadHash string, hdr http.Header) (*http.Request, error) {
+	if !strings.HasPrefix(strings.ToLower(raw), "s3://") {
+		req, err := newBodyRequest(method, raw, body, size)
+		if err == nil { addHeaders(req, hdr) }
+		return req, err
+	}
+	u, err := url.Parse(raw)
+	if err != nil { return nil, err }
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" { return nil, fmt.Errorf("URL s3 không hợp lệ: %s", raw) }
+	region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" { region = "us-east-1" }
+	target := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, awsEscapePath(key))
+	if ep := os.Getenv("AWS_ENDPOINT_URL"); ep != "" {
+		target = strings.TrimRight(ep, "/") + "/" + bucket + "/" + awsEscapePath(key) // path-style (MinIO, ...)
+	}
+	if u.RawQuery != "" { target += "?" + u.RawQuery }
+	req, err := newBodyRequest(method, target, body, size)
+	if err != nil { return nil, err }
+	addHeaders(req, hdr)
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		signV4(req, id, secret, os.Getenv("AWS_SESSION_TOKEN"), region, payloadHash, time.Now().UTC())
+	}
+	return req, nil
+}
+
+func addHeaders(req *http.Request, hdr http.Header) {
+	for k, vs := range hdr {
+		for _, v := range vs { req.Header.Add(k, v) }
+	}
+}
+
+func newBodyRequest(method, target string, body io.ReadCloser, size int64) (*http.Request, error) {
+	if body == nil { return http.NewRequest(method, target, nil) }
+	req, err := http.NewRequest(method, target, body)
+	if err != nil { return nil, err }
+	req.ContentLength = size
+	return req, nil
+}
+
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" { return v }
+	}
+	return ""
+}
+
+func awsEscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header; the body is
+// covered by payloadHash as given, and every x-amz-* header is signed.
+func signV4(req *http.Request, id, secret, token, region, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if token != "" { req.Header.Set("x-amz-security-token", token) }
+	signed := []string{"host"}
+	for h := range req.Header {
+		if lh := strings.ToLower(h); strings.HasPrefix(lh, "x-amz-") { signed = append(signed, lh) }
+	}
+	sort.Strings(signed)
+	var canonHeaders strings.Builder
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" { v = req.URL.Host }
+		fmt.Fprintf(&canonHeaders, "%s:%s\n", h, strings.TrimSpace(v))
+	}
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		canonHeaders.String(), strings.Join(signed, ";"), payloadHash}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{day, region, "s3", "aws4_request"} { key = hmacSHA256(key, part) }
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		id, scope, strings.Join(signed, ";"), hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func remoteDo(method, raw, rng string) (*http.Response, error) {
+	return remoteDoWith(remoteClient, method, raw, rng)
+}
+
+func remoteDoWith(client *http.Client, method, raw, rng string) (*http.Response, error) {
+	req, err := remoteRequest(method, raw)
+	if err != nil { return nil, err }
+	if rng != "" { req.Header.Set("Range", rng) }
+	resp, err := client.Do(req)
+	if err != nil { return nil, err }
+	if resp.StatusCode/100 != 2 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, remoteName(raw), resp.Status)
+	}
+	return resp, nil
+}
+
+// stampRemote uses Content-Length/Last-Modified from a HEAD request.
+func stampRemote(raw string) (sourceStamp, error) {
+	resp, err := remoteDo(http.MethodHead, raw, "")
+	if err != nil { return sourceStamp{}, err }
+	_ = resp.Body.Close()
+	if resp.ContentLength < 0 { return sourceStamp{}, fmt.Errorf("%s: server không trả Content-Length", remoteName(raw)) }
+	mod, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return sourceStamp{size: resp.ContentLength, mod: mod}, nil
+}
+
+// remoteFile is an io.ReaderAt over range requests with a small block cache,
+// enough for zip.Reader's many small reads (headers, 4KB inflate refills).
+type remoteFile struct {
+	url  string
+	size int64
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	order  []int64
+	closed bool
+}
+
+func openRemoteFile(raw string) (*remoteFile, error) {
+	st, err := stampRemote(raw)
+	if err != nil { return nil, err }
+	return &remoteFile{url: raw, size: st.size, blocks: map[int64][]byte{}}, nil
+}
+
+func (r *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 { return 0, errors.New("remote: offset âm") }
+	n := 0
+	for n < len(p) {
+		if off >= r.size { return n, io.EOF }
+		b, err := r.block(off / remoteBlock)
+		if err != nil { return n, err }
+		m := copy(p[n:], b[off%remoteBlock:])
+		n += m
+		off += int64(m)
+	}
+	return n, nil
+}
+
+func (r *remoteFile) block(i int64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed { return nil, os.ErrClosed }
+	if b, ok := r.blocks[i]; ok { return b, nil }
+	start := i * remoteBlock
+	end := start + remoteBlock
+	if end > r.size { end = r.size }
+	resp, err := remoteDo(http.MethodGet, r.url, "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end-1, 10))
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent { return nil, fmt.Errorf("%s: server không hỗ trợ Range (%s)", remoteName(r.url), resp.Status) }
+	b := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, b); err != nil { return nil, err }
+	if len(r.order) >= remoteBlocks {
+		delete(r.blocks, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.blocks[i] = b
+	r.order = append(r.order, i)
+	return b, nil
+}
+
+// Close drops the cached blocks; entries already opened fail from then on.
+func (r *remoteFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blocks, r.order, r.closed = nil, nil, true
+	return nil
+}
+
+// openRemoteStream is used for tarballs, which are read front to back anyway.
+func openRemoteStream(raw string) (io.ReadCloser, error) {
+	resp, err := remoteDoWith(remoteStreamClient, http.MethodGet, raw, "")
+	if err != nil { return nil, err }
+	return resp.Body, nil
+}
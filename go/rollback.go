@@ -0,0 +1,215 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A write error inside an entry (disk hiccup, NFS/SMB timeout) used to end
+// the whole merge. For a new zip file the writer now sits on a failWriter,
+// which remembers the first failure instead of passing it up (zip.Writer's
+// buffer would return it forever after), so the entry can be cut off at its
+// local header and the merge carries on with a fresh zip.Writer at that
+// offset. The central directories of these segments are joined on close,
+// as -append does with the old directory. Three failures in a row are not
+// transient: the run stops as before.
+
+const maxFailStreak = 3
+
+// failWriter passes writes to w until one fails; from then on it reports
+// success and drops the bytes, keeping the first ones that did not make it
+// (the previous entry's data descriptor is often among them).
+type failWriter struct {
+	w      io.Writer
+	pos    int64 // absolute offset of the next byte to reach w
+	err    error
+	unsent []byte
+}
+
+const failKeep = 64 // > the largest data descriptor (24 bytes)
+
+func (f *failWriter) Write(p []byte) (int, error) {
+	if f.err != nil {
+		if k := failKeep - len(f.unsent); k > 0 { f.unsent = append(f.unsent, p[:min(len(p), k)]...) }
+		return len(p), nil
+	}
+	n, err := f.w.Write(p)
+	f.pos += int64(n)
+	if err == nil && n < len(p) { err = io.ErrShortWrite }
+	if err != nil {
+		f.err = err
+		f.unsent = append([]byte(nil), p[n:min(len(p), n+failKeep)]...)
+	}
+	return len(p), nil
+}
+
+// segmentedZip is a zipArchive that can drop its last entry after a write
+// error and go on.
+type segmentedZip struct {
+	f       *os.File
+	fw      *failWriter
+	cw      *captureWriter
+	zw      *zip.Writer
+	opt     options
+	meter   *packMeter
+	dirs    [][]byte // central directories of the finished segments
+	entries uint64
+	zip64   bool
+	streak  int // rollbacks since the last entry that made it
+}
+
+func newSegmentedZip(f *os.File, w io.Writer, opt options, m *packMeter) (*segmentedZip, error) {
+	a := &segmentedZip{f: f, fw: &failWriter{w: w}, opt: opt, meter: m}
+	return a, a.startSegment(0)
+}
+
+func (a *segmentedZip) startSegment(offset int64) error {
+	a.fw.pos, a.fw.err, a.fw.unsent = offset, nil, nil
+	a.cw = &captureWriter{w: a.fw, pos: offset}
+	a.zw = zip.NewWriter(a.cw)
+	a.zw.SetOffset(offset)
+	registerCompressors(a.zw, a.opt, a.meter)
+	return a.zw.SetComment(a.opt.comment)
+}
+
+func (a *segmentedZip) create(hdr *zip.FileHeader) (io.Writer, error) {
+	w, err := a.zw.CreateHeader(hdr)
+	if err != nil { return nil, err }
+	return &segmentEntry{w: w, fw: a.fw}, nil
+}
+
+// segmentEntry surfaces a failure of the layer below as soon as it happens,
+// so a multi-GB entry is not copied on into nothing.
+type segmentEntry struct {
+	w  io.Writer
+	fw *failWriter
+}
+
+func (e *segmentEntry) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err == nil && e.fw.err != nil { err = e.fw.err }
+	return n, err
+}
+
+// entryDone pushes the finished entry out of zip.Writer's buffer and
+// reports whether all of it reached the file.
+func (a *segmentedZip) entryDone() error {
+	if err := a.zw.Flush(); err != nil { return err }
+	if a.fw.err == nil { a.streak = 0 }
+	return a.fw.err
+}
+
+// rollback drops the last entry after a write error; see cut.
+func (a *segmentedZip) rollback() (int64, error) {
+	cause := a.fw.err
+	if cause == nil { return 0, errors.New("rollback khi không có lỗi ghi") }
+	if a.streak++; a.streak >= maxFailStreak { return 0, fmt.Errorf("%d lỗi ghi liên tiếp, dừng: %v", a.streak, cause) }
+	return a.cut()
+}
+
+// cut removes the last entry from the file and starts a new segment where
+// its local header began. It returns the new end of the file. Bytes lost
+// before that header (the previous entry's data descriptor) are rewritten
+// from failWriter's copy.
+func (a *segmentedZip) cut() (int64, error) {
+	cause := a.fw.err
+	if err := a.zw.Flush(); err != nil { return 0, err }
+	failPos := a.fw.pos // the end of the file when nothing failed
+	a.cw.capture = true
+	if err := a.zw.Close(); err != nil { return 0, err }
+	tail, err := readZipDirectory(a.cw, a.cw.pos, a.cw.pos+int64(a.cw.buf.Len()))
+	if err != nil { return 0, fmt.Errorf("central directory mới không hợp lệ: %v", err) }
+	raw, cut, err := dropLastRecord(tail.raw)
+	if err != nil { return 0, err }
+	if cause != nil && failPos < cut && cut-failPos > int64(len(a.fw.unsent)) {
+		return 0, fmt.Errorf("lỗi ghi ở offset %d, trước entry đang ghi (offset %d): %v", failPos, cut, cause)
+	}
+
+	if _, err := a.f.Seek(failPos, io.SeekStart); err != nil { return 0, err }
+	if failPos < cut {
+		if _, err := a.f.Write(a.fw.unsent[:cut-failPos]); err != nil { return 0, err }
+	}
+	if err := a.f.Truncate(cut); err != nil { return 0, err }
+	if _, err := a.f.Seek(cut, io.SeekStart); err != nil { return 0, err }
+
+	a.dirs = append(a.dirs, raw)
+	a.entries += tail.entries - 1
+	a.zip64 = a.zip64 || tail.zip64
+	return cut, a.startSegment(cut)
+}
+
+func (a *segmentedZip) close() error {
+	if len(a.dirs) == 0 {
+		if err := a.zw.Close(); err != nil { return err }
+		return a.fw.err
+	}
+	if err := a.zw.Flush(); err != nil { return err }
+	a.cw.capture = true
+	if err := a.zw.Close(); err != nil { return err }
+	tail, err := readZipDirectory(a.cw, a.cw.pos, a.cw.pos+int64(a.cw.buf.Len()))
+	if err != nil { return fmt.Errorf("central directory mới không hợp lệ: %v", err) }
+	if _, err := a.fw.Write(a.cw.buf.Bytes()[:tail.offset-a.cw.pos]); err != nil { return err }
+	var cdSize uint64
+	for _, d := range append(a.dirs, tail.raw) {
+		if _, err := a.fw.Write(d); err != nil { return err }
+		cdSize += uint64(len(d))
+	}
+	end := buildEOCD(a.entries+tail.entries, cdSize, uint64(tail.offset), tail.comment, a.zip64 || tail.zip64)
+	if _, err := a.fw.Write(end); err != nil { return err }
+	return a.fw.err
+}
+
+// entryDone reports a write error hidden in the entry just copied (one that
+// only surfaced when zip.Writer's buffer was flushed).
+func (o *outputFile) entryDone() error {
+	if a, ok := o.aw.(*segmentedZip); ok { return a.entryDone() }
+	return nil
+}
+
+// canDrop reports whether an entry can be taken out again once started.
+func (o *outputFile) canDrop() bool {
+	switch o.aw.(type) {
+	case *segmentedZip, *zipAppendArchive:
+		return true
+	}
+	return false
+}
+
+// rollback drops the entry being written after cause, a write error, when
+// the output supports it; otherwise cause is returned and the run stops.
+func (o *outputFile) rollback(opt options, name, comment string, cause error) error {
+	a, ok := o.aw.(*segmentedZip)
+	if !ok { return cause }
+	end, err := a.rollback()
+	if err != nil { return err }
+	o.forget(opt, name, comment, end)
+	return nil
+}
+
+// dropEntry takes the entry being written out of the output even though it
+// was written fine (-zip-timeout, -deadline, a read error). Only valid when
+// canDrop.
+func (o *outputFile) dropEntry(opt options, name, comment string) error {
+	if a, ok := o.aw.(*zipAppendArchive); ok {
+		if err := a.cut(); err != nil { return err }
+		o.forget(opt, name, comment, o.count.n) // -append counts nothing
+		return nil
+	}
+	end, err := o.aw.(*segmentedZip).cut()
+	if err != nil { return err }
+	o.forget(opt, name, comment, end)
+	return nil
+}
+
+func (o *outputFile) forget(opt options, name, comment string, end int64) {
+	o.count.n = end
+	o.entries--
+	_, dir := entryCost(opt, 0, name)
+	o.dirBytes -= dir + int64(len(comment))
+	o.sidecar = nil // it hashed the dropped bytes; finish re-reads the file
+	if o.signer != nil { o.signer.drop() }
+	o.inEntry = false
+}
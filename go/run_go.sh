@@ -7,7 +7,8 @@ DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 cd "$DIR"
 BIN="./mergezip_go"
 if [[ ! -x "$BIN" ]]; then
-  echo "[build] go build -o mergezip_go main.go"
-  go build -o mergezip_go ./main.go
+  # No go.mod: build the directory in GOPATH mode so per-OS files (_linux.go, ...) are honoured.
+  echo "[build] GO111MODULE=off go build -o mergezip_go ."
+  GO111MODULE=off go build -o mergezip_go .
 fi
 exec "$BIN" "$@"
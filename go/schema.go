@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSON Schemas (draft 2020-12) for everything this tool writes as JSON, so
+// downstream parsers can validate against the version they were built for:
+// `schema list`, `schema print NAME`. They are derived from the same Go
+// types that are marshalled, so they cannot drift from the output.
+//
+// Compatibility: within a major version (the v1 in each $id) fields are
+// only added, as optional properties. Nothing is renamed, removed or changes
+// type; readers must ignore properties they do not know
+// (additionalProperties is left open for that). A breaking change bumps
+// schemaMajor. The manifest and plan carry their $id in a "schema" field.
+
+const schemaMajor = 1
+
+type jsonSchemaDoc struct {
+	name   string
+	title  string
+	schema func() map[string]interface{}
+}
+
+var jsonSchemas = []jsonSchemaDoc{
+	{"manifest", "-manifest FILE.json: một dòng cho mỗi entry của output", func() map[string]interface{} { return typeSchema(reflect.TypeOf(manifestDoc{})) }},
+	{"plan", "plan -o FILE: kế hoạch merge", func() map[string]interface{} { return typeSchema(reflect.TypeOf(mergePlan{})) }},
+	{"plan-diff", "plan -compare: khác biệt so với kế hoạch trước", func() map[string]interface{} { return typeSchema(reflect.TypeOf(planDiff{})) }},
+	{"list", "list -json: thống kê nguồn", func() map[string]interface{} { return typeSchema(reflect.TypeOf(listReport{})) }},
+	{"config", "config show -o json: giá trị cuối cùng của mọi flag", func() map[string]interface{} { return typeSchema(reflect.TypeOf(map[string]setting{})) }},
+	{"split-trailer", "-split-meta: trailer JSON ở cuối mỗi part", func() map[string]interface{} { return typeSchema(reflect.TypeOf(splitTrailer{})) }},
+	{"log", "-log-file: một event JSON mỗi dòng", logEventSchema},
+}
+
+// manifestDoc is the shape manifestWriter streams by hand.
+type manifestDoc struct {
+	Schema  string          `json:"schema"`
+	JobID   string          `json:"job_id"`
+	Output  string          `json:"output"`
+	Created time.Time       `json:"created"`
+	Entries []manifestEntry `json:"entries"`
+}
+
+func schemaID(name string) string { return fmt.Sprintf("urn:mergezip:schema:%s:v%d", name, schemaMajor) }
+
+func findSchema(name string) *jsonSchemaDoc {
+	for i := range jsonSchemas {
+		if jsonSchemas[i].name == name { return &jsonSchemas[i] }
+	}
+	return nil
+}
+
+// runSchema implements `schema list` and `schema print [NAME]`.
+func runSchema(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		for _, s := range jsonSchemas { fmt.Printf("%-14s %-38s %s\n", s.name, schemaID(s.name), s.title) }
+		return nil
+	}
+	if args[0] != "print" || len(args) > 2 { return errors.New("dùng: schema list | schema print [TÊN]") }
+	var out interface{}
+	if len(args) == 1 {
+		all := map[string]interface{}{}
+		for _, s := range jsonSchemas { all[s.name] = documentSchema(s) }
+		out = all
+	} else {
+		s := findSchema(args[1])
+		if s == nil { return fmt.Errorf("không có schema %q (xem: %s schema list)", args[1], progName()) }
+		out = documentSchema(*s)
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil { return err }
+	fmt.Println(string(b))
+	return nil
+}
+
+func documentSchema(s jsonSchemaDoc) map[string]interface{} {
+	m := s.schema()
+	m["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	m["$id"] = schemaID(s.name)
+	m["title"] = s.title
+	return m
+}
+
+// typeSchema describes how encoding/json marshals t.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) { return map[string]interface{}{"type": "string", "format": "date-time"} }
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{"anyOf": []interface{}{typeSchema(t.Elem()), map[string]interface{}{"type": "null"}}}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice: // a nil slice or map is written as null
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": typeSchema(t.Elem())}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if !f.IsExported() || tag == "-" { continue }
+			name, opts, _ := strings.Cut(tag, ",")
+			if name == "" { name = f.Name }
+			props[name] = typeSchema(f.Type)
+			if !strings.Contains(opts, "omitempty") { required = append(required, name) }
+		}
+		sort.Strings(required)
+		return map[string]interface{}{"type": "object", "properties": props, "required": required}
+	}
+	return map[string]interface{}{} // interface{}: any JSON value
+}
+
+// logEventSchema covers the lines of -log-file. The fixed keys come from
+// slog's JSON handler and setupLogging; the rest depend on msg (the event
+// name) and are only listed, not pinned down per event.
+func logEventSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"time":  map[string]interface{}{"type": "string", "format": "date-time"},
+			"level": map[string]interface{}{"enum": []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+			"msg":   map[string]interface{}{"type": "string", "description": "event: log, warning, source-done, entry-skip, entry-rename, entry-drop, charset, secret, deadline, low-disk, checkpoint, simulate-delete, upload, upload-tune, upload-delta, job-reused, run-done"},
+			"job":   map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"job", "level", "msg", "time"},
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// -secret-scan looks for leaked credentials in text entries while they are
+// copied, for archives that leave the house: AWS access keys and secret
+// keys, PEM private key headers, and long high-entropy tokens (API keys,
+// passwords pasted into configs). Binary entries (a NUL byte near the start)
+// are not scanned. `report` warns per entry and goes on; `block` ends the
+// run at the first hit, and the new output is removed (an -append output is
+// rolled back to before that entry), so nothing is shipped by accident.
+// Findings name the rule and the line, never the matched text.
+
+const (
+	secretOff    = "off"
+	secretReport = "report"
+	secretBlock  = "block"
+)
+
+const (
+	secretSniff    = 8 << 10  // bytes checked for NUL to tell text from binary
+	secretMaxLine  = 64 << 10 // longer lines are scanned up to here
+	secretMaxFound = 20       // findings kept per entry
+	secretMinToken = 32
+	secretMinBits  = 4.5 // bits/char; base64 of random bytes is ~5.5+ at this length
+)
+
+var (
+	errSecret = errors.New("phát hiện secret (-secret-scan block)")
+
+	awsKeyRe    = regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)
+	awsSecretRe = regexp.MustCompile(`(?i)aws_?secret_?(access_?)?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`)
+	pemKeyRe    = regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)
+	tokenRe     = regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`)
+)
+
+func validateSecretScan(opt *options) error {
+	switch opt.secretScan {
+	case secretOff, secretReport, secretBlock: return nil
+	}
+	return fmt.Errorf("-secret-scan không hợp lệ: %q (off|report|block)", opt.secretScan)
+}
+
+type secretFinding struct {
+	rule string
+	line int
+}
+
+// secretScanner is fed an entry's bytes chunk by chunk, like the CRC.
+type secretScanner struct {
+	started bool
+	binary  bool
+	line    int
+	carry   []byte // unfinished last line of the previous chunk
+	found   []secretFinding
+}
+
+// newSecretScanner returns nil when -secret-scan is off.
+func newSecretScanner(opt options) *secretScanner {
+	if opt.secretScan == secretOff { return nil }
+	return &secretScanner{}
+}
+
+func (s *secretScanner) Write(p []byte) (int, error) {
+	n := len(p)
+	if !s.started {
+		s.started = true
+		s.binary = bytes.IndexByte(p[:min(len(p), secretSniff)], 0) >= 0
+	}
+	if s.binary { return n, nil }
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.carry = append(s.carry, p[:min(len(p), secretMaxLine-len(s.carry))]...)
+			break
+		}
+		line := p[:i]
+		if len(s.carry) > 0 {
+			line = append(s.carry, line[:min(len(line), secretMaxLine-len(s.carry))]...)
+			s.carry = s.carry[:0]
+		}
+		s.scanLine(line)
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (s *secretScanner) scanLine(line []byte) {
+	s.line++
+	if len(s.found) >= secretMaxFound { return }
+	if bytes.Contains(line, []byte("PRIVATE KEY")) && pemKeyRe.Match(line) { s.hit("private-key") }
+	if awsKeyRe.Match(line) { s.hit("aws-access-key") }
+	if awsSecretRe.Match(line) { s.hit("aws-secret-key"); return } // its value is a high-entropy token too
+	for _, tok := range tokenRe.FindAll(line, -1) {
+		if highEntropyToken(tok) { s.hit("high-entropy"); break }
+	}
+}
+
+func (s *secretScanner) hit(rule string) {
+	if len(s.found) < secretMaxFound { s.found = append(s.found, secretFinding{rule, s.line}) }
+}
+
+// highEntropyToken skips tokens that are words, paths or plain hex (hashes,
+// UUIDs are common and harmless): it wants upper, lower and digits mixed.
+func highEntropyToken(tok []byte) bool {
+	if len(tok) < secretMinToken { return false }
+	var upper, lower, digit bool
+	for _, c := range tok {
+		switch {
+		case c >= 'A' && c <= 'Z': upper = true
+		case c >= 'a' && c <= 'z': lower = true
+		case c >= '0' && c <= '9': digit = true
+		}
+	}
+	return upper && lower && digit && shannonEntropy(tok) >= secretMinBits
+}
+
+// verdict applies -secret-scan to a finished entry: a warning per entry
+// with findings, and errSecret with block.
+func (s *secretScanner) verdict(opt options, wl *warnLog, source, path string) error {
+	if len(s.carry) > 0 { s.scanLine(s.carry) }
+	if len(s.found) == 0 { return nil }
+	hits := make([]string, 0, len(s.found))
+	for _, f := range s.found {
+		record(slog.LevelWarn, "secret", "source", source, "path", path, "rule", f.rule, "line", f.line)
+		hits = append(hits, fmt.Sprintf("%s (dòng %d)", f.rule, f.line))
+	}
+	msg := fmt.Sprintf("entry '%s' trong %s có thể chứa secret: %s", path, source, strings.Join(hits, ", "))
+	if opt.secretScan == secretBlock {
+		printErr("%s", msg)
+		return fmt.Errorf("%w: '%s' trong %s", errSecret, path, source)
+	}
+	return wl.warn(warnSecret, "%s", msg)
+}
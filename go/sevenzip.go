@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// .7z sources go through the 7z CLI (p7zip / 7-Zip), like zstd and xz tar
+// sources: `7z l -slt` gives the entry list, then a single `7z x -so`
+// streams every file's data back to back in archive order and the reader
+// cuts it at the listed sizes. One extraction pass per source, so solid
+// archives are not decompressed again for every entry.
+
+// sevenZipTools are tried in this order.
+var sevenZipTools = []string{"7z", "7zz", "7za"}
+
+type sevenZipSource struct {
+	tool     string
+	path     string
+	entries  []*sourceEntry
+	i        int
+	cmd      *exec.Cmd
+	out      io.ReadCloser
+	stderr   bytes.Buffer
+	streamAt int            // index of the entry whose data is next on out
+	cur      *sevenZipEntry // the opened entry, until next is called
+}
+
+func findSevenZip() (string, error) {
+	for _, t := range sevenZipTools {
+		if p, err := exec.LookPath(t); err == nil { return p, nil }
+	}
+	return "", errors.New("cần lệnh 7z (p7zip-full / 7-Zip) trong PATH để đọc nguồn .7z")
+}
+
+func openSevenZipSource(path string) (*sevenZipSource, error) {
+	if isRemote(path) { return nil, errors.New("nguồn .7z qua -remote chưa được hỗ trợ (7z cần đọc file local)") }
+	tool, err := findSevenZip()
+	if err != nil { return nil, err }
+	var stderr bytes.Buffer
+	cmd := exec.Command(tool, "l", "-slt", "--", path)
+	cmd.Stderr = &stderr
+	listing, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" { err = fmt.Errorf("%v (%s)", err, msg) }
+		return nil, fmt.Errorf("7z không liệt kê được %s: %v", path, err)
+	}
+	entries, err := parseSevenZipListing(listing)
+	if err != nil { return nil, err }
+	return &sevenZipSource{tool: tool, path: path, entries: entries}, nil
+}
+
+// parseSevenZipListing reads the technical listing (-slt): one "Key = value"
+// block per entry after the "----------" separator.
+func parseSevenZipListing(listing []byte) ([]*sourceEntry, error) {
+	var out []*sourceEntry
+	sc := bufio.NewScanner(bytes.NewReader(listing))
+	sc.Buffer(make([]byte, 64*1024), maxEntryName+1024)
+	inEntries := false
+	var cur *sourceEntry
+	flush := func() {
+		if cur != nil && cur.Name != "" { out = append(out, cur) }
+		cur = nil
+	}
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if !inEntries {
+			inEntries = line == "----------"
+			continue
+		}
+		if line == "" { flush(); continue }
+		key, val, ok := strings.Cut(line, " = ")
+		if !ok { continue }
+		if cur == nil { cur = &sourceEntry{} }
+		switch key {
+		case "Path":
+			cur.Name = strings.ReplaceAll(val, "\\", "/")
+		case "Folder":
+			cur.IsDir = val == "+"
+		case "Size":
+			n, err := strconv.ParseUint(val, 10, 64)
+			if err != nil { return nil, malformed("7z: size không hợp lệ %q", val) }
+			cur.Size, cur.CompressedSize = n, n
+		case "CRC":
+			if v, err := strconv.ParseUint(val, 16, 32); err == nil { cur.CRC32 = uint32(v) }
+		case "Modified":
+			if t, err := time.ParseInLocation("2006-01-02 15:04:05", val, time.Local); err == nil { cur.Modified = t }
+		case "Encrypted":
+			if val == "+" { return nil, errors.New("nguồn .7z có entry mã hoá, không hỗ trợ") }
+		}
+		if len(out) > maxSourceEntries { return nil, malformed("quá %d entries", maxSourceEntries) }
+	}
+	flush()
+	if err := sc.Err(); err != nil { return nil, malformed("7z: %v", err) }
+	if !inEntries { return nil, malformed("7z: không đọc được danh sách entry") }
+	return out, nil
+}
+
+func (s *sevenZipSource) next() (*sourceEntry, error) {
+	if s.cur != nil {
+		if err := s.cur.skip(); err != nil { return nil, err }
+		s.cur = nil
+	}
+	if s.i >= len(s.entries) { return nil, io.EOF }
+	k := s.i
+	e := s.entries[k]
+	s.i++
+	if !e.IsDir {
+		e.open = func() (io.ReadCloser, error) {
+			if err := s.seek(k); err != nil { return nil, err }
+			return io.NopCloser(s.cur), nil
+		}
+	}
+	return e, nil
+}
+
+// seek positions the data stream at entry k, starting `7z x -so` on first
+// use and discarding the data of earlier files that were not opened.
+func (s *sevenZipSource) seek(k int) error {
+	if k < s.streamAt { return errors.New("7z: entry đã được đọc") }
+	if s.cmd == nil {
+		s.cmd = exec.Command(s.tool, "x", "-so", "-y", "-bso0", "-bsp0", "--", s.path)
+		s.cmd.Stderr = &s.stderr
+		out, err := s.cmd.StdoutPipe()
+		if err != nil { return err }
+		if err := s.cmd.Start(); err != nil { return fmt.Errorf("không chạy được %s để giải nén: %v", s.tool, err) }
+		s.out = out
+	}
+	for ; s.streamAt < k; s.streamAt++ {
+		if p := s.entries[s.streamAt]; !p.IsDir {
+			if err := s.entryReader(p).skip(); err != nil { return err }
+		}
+	}
+	s.streamAt++
+	s.cur = s.entryReader(s.entries[k])
+	return nil
+}
+
+func (s *sevenZipSource) entryReader(e *sourceEntry) *sevenZipEntry {
+	return &sevenZipEntry{s: s, e: e, n: e.Size, crc: crc32.NewIEEE()}
+}
+
+func (s *sevenZipSource) close() error {
+	if s.cmd != nil && s.cmd.Process != nil {
+		_ = s.cmd.Process.Kill()
+		_ = s.cmd.Wait()
+	}
+	return nil
+}
+
+// sevenZipEntry reads one file's share of the `7z x -so` stream and checks
+// its CRC at the end, which also catches the stream and the listing
+// drifting apart.
+type sevenZipEntry struct {
+	s   *sevenZipSource
+	e   *sourceEntry
+	n   uint64
+	crc hash.Hash32
+}
+
+func (r *sevenZipEntry) Read(p []byte) (int, error) {
+	if r.n == 0 { return 0, io.EOF }
+	if uint64(len(p)) > r.n { p = p[:r.n] }
+	n, err := r.s.out.Read(p)
+	r.crc.Write(p[:n])
+	r.n -= uint64(n)
+	if r.n == 0 {
+		if r.e.CRC32 != 0 && r.crc.Sum32() != r.e.CRC32 { return n, malformed("7z: CRC sai ở '%s'", r.e.Name) }
+		return n, io.EOF
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+		if msg := strings.TrimSpace(r.s.stderr.String()); msg != "" { err = fmt.Errorf("%w (%s)", err, msg) }
+	}
+	return n, err
+}
+
+func (r *sevenZipEntry) skip() error {
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -sign-key puts the proof of origin inside the archive instead of next to
+// it (a SHA256SUMS file is easy to lose or swap). While entries are written
+// their bytes are hashed; on close the output gets two more entries:
+// META-MERGE/MANIFEST, one line "<sha256> <size> <quoted name>" per entry,
+// and META-MERGE/SIGNATURE, an ed25519 signature over the MANIFEST bytes.
+// `verify-signature -key PUB.pem ARCHIVE` checks the signature, then every
+// entry against the manifest, and fails on entries added or removed since.
+//
+// Keys are PEM, as openssl writes them:
+//
+//	openssl genpkey -algorithm ed25519 -out merge-key.pem
+//	openssl pkey -in merge-key.pem -pubout -out merge-pub.pem
+
+const (
+	signManifestName  = "META-MERGE/MANIFEST"
+	signatureName     = "META-MERGE/SIGNATURE"
+	signManifestMagic = "mergezip-manifest v1"
+	signMaxManifest   = 256 << 20
+)
+
+func validateSign(opt *options) error {
+	if opt.signKey == "" { return nil }
+	switch {
+	case opt.format != "zip": return errors.New("-sign-key chỉ hỗ trợ -format zip")
+	case opt.appendOut: return errors.New("-sign-key không dùng chung được với -append/-watch (entry cũ chưa được băm)")
+	}
+	var err error
+	opt.signPriv, err = loadPrivateKey(opt.signKey)
+	if err != nil { return fmt.Errorf("-sign-key %s: %v", opt.signKey, err) }
+	return nil
+}
+
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	blk, _ := pem.Decode(b)
+	if blk == nil || blk.Type != "PRIVATE KEY" { return nil, errors.New("cần khoá PEM \"PRIVATE KEY\" (PKCS#8)") }
+	k, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil { return nil, err }
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok { return nil, fmt.Errorf("khoá %T, cần ed25519", k) }
+	return priv, nil
+}
+
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	blk, _ := pem.Decode(b)
+	if blk == nil { return nil, errors.New("không phải file PEM") }
+	switch blk.Type {
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(blk.Bytes)
+		if err != nil { return nil, err }
+		pub, ok := k.(ed25519.PublicKey)
+		if !ok { return nil, fmt.Errorf("khoá %T, cần ed25519", k) }
+		return pub, nil
+	case "PRIVATE KEY": // the signer's own key will do too
+		k, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+		if err != nil { return nil, err }
+		priv, ok := k.(ed25519.PrivateKey)
+		if !ok { return nil, fmt.Errorf("khoá %T, cần ed25519", k) }
+		return priv.Public().(ed25519.PublicKey), nil
+	}
+	return nil, fmt.Errorf("PEM %q, cần \"PUBLIC KEY\"", blk.Type)
+}
+
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+type signedEntry struct {
+	name string
+	sum  hash.Hash
+	size int64
+}
+
+// archiveSigner collects the hashes of one output's entries.
+type archiveSigner struct {
+	key     ed25519.PrivateKey
+	entries []*signedEntry
+	mfSize  int64 // upper bound of the MANIFEST size so far, for the budgets
+}
+
+func newArchiveSigner(key ed25519.PrivateKey) *archiveSigner {
+	return &archiveSigner{key: key, mfSize: int64(len(signManifestMagic)) + 1}
+}
+
+const signatureSize = 160 // the SIGNATURE entry's body, rounded up
+
+// manifestLine is the most a manifest line for name can take.
+func manifestLine(name string) int64 { return 64 + 1 + 20 + 1 + int64(len(strconv.Quote(name))) + 1 }
+
+// cost is what embed will add to the output once name is signed as well:
+// both entries, with their directory records, counted as stored.
+func (s *archiveSigner) cost(opt options, name string) int64 {
+	data, dir := entryCost(opt, uint64(s.mfSize+manifestLine(name)), signManifestName)
+	sData, sDir := entryCost(opt, signatureSize, signatureName)
+	return data + dir + sData + sDir
+}
+
+// drop forgets the last entry (rolled back, see rollback.go).
+func (s *archiveSigner) drop() {
+	e := s.entries[len(s.entries)-1]
+	s.entries = s.entries[:len(s.entries)-1]
+	s.mfSize -= manifestLine(e.name)
+}
+
+type signedWriter struct {
+	w io.Writer
+	e *signedEntry
+}
+
+func (s *signedWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	_, _ = s.e.sum.Write(p[:n])
+	s.e.size += int64(n)
+	return n, err
+}
+
+// create starts an entry of the output; with -sign-key its bytes are hashed
+// on the way through.
+func (o *outputFile) create(hdr *zip.FileHeader) (io.Writer, error) {
+	w, err := o.aw.create(hdr)
+	if err != nil || o.signer == nil { return w, err }
+	e := &signedEntry{name: hdr.Name, sum: sha256.New()}
+	o.signer.entries = append(o.signer.entries, e)
+	o.signer.mfSize += manifestLine(e.name)
+	return &signedWriter{w: w, e: e}, nil
+}
+
+func (s *archiveSigner) manifest() []byte {
+	var b bytes.Buffer
+	b.WriteString(signManifestMagic + "\n")
+	for _, e := range s.entries { fmt.Fprintf(&b, "%x %d %s\n", e.sum.Sum(nil), e.size, strconv.Quote(e.name)) }
+	return b.Bytes()
+}
+
+// embed writes MANIFEST and SIGNATURE as the last entries, before the
+// archive is closed.
+func (s *archiveSigner) embed(aw archiveWriter) error {
+	mf := s.manifest()
+	pub := s.key.Public().(ed25519.PublicKey)
+	sig := fmt.Sprintf("algorithm: ed25519\nkey-id: %s\nsignature: %s\n", keyID(pub), base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, mf)))
+	for _, e := range []struct {
+		name   string
+		method uint16
+		body   []byte
+	}{{signManifestName, zip.Deflate, mf}, {signatureName, zip.Store, []byte(sig)}} {
+		w, err := aw.create(&zip.FileHeader{Name: e.name, Method: e.method, Modified: time.Now(), UncompressedSize64: uint64(len(e.body))})
+		if err != nil { return err }
+		if _, err := w.Write(e.body); err != nil { return err }
+	}
+	logf("Đã ký %d entry (ed25519, key-id %s)", len(s.entries), keyID(pub))
+	return nil
+}
+
+// runVerifySignature implements `verify-signature -key PUB.pem ARCHIVE`.
+func runVerifySignature(args []string) error {
+	fs := flag.NewFlagSet("verify-signature", flag.ExitOnError)
+	keyPath := fs.String("key", "", "Khoá công khai ed25519 (PEM)")
+	_ = fs.Parse(args)
+	if *keyPath == "" || fs.NArg() != 1 { return errors.New("dùng: verify-signature -key PUB.pem ARCHIVE.zip") }
+	pub, err := loadPublicKey(*keyPath)
+	if err != nil { return fmt.Errorf("%s: %v", *keyPath, err) }
+	zr, err := zip.OpenReader(fs.Arg(0))
+	if err != nil { return err }
+	defer zr.Close()
+
+	files := map[string]*zip.File{}
+	var mfFile, sigFile *zip.File
+	for _, f := range zr.File {
+		switch f.Name {
+		case signManifestName: mfFile = f
+		case signatureName: sigFile = f
+		default:
+			if files[f.Name] != nil { return fmt.Errorf("entry trùng tên %q", f.Name) }
+			files[f.Name] = f
+		}
+	}
+	if mfFile == nil || sigFile == nil { return fmt.Errorf("%s không có chữ ký (%s, %s)", fs.Arg(0), signManifestName, signatureName) }
+	mf, err := readZipEntry(mfFile, signMaxManifest)
+	if err != nil { return fmt.Errorf("%s: %v", signManifestName, err) }
+	sigText, err := readZipEntry(sigFile, 4<<10)
+	if err != nil { return fmt.Errorf("%s: %v", signatureName, err) }
+	sig, err := parseSignature(sigText)
+	if err != nil { return fmt.Errorf("%s: %v", signatureName, err) }
+	if !ed25519.Verify(pub, mf, sig) { return fmt.Errorf("chữ ký không hợp lệ với khoá %s (sai khoá, hoặc manifest đã bị sửa)", keyID(pub)) }
+
+	sc := bufio.NewScanner(bytes.NewReader(mf))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	if !sc.Scan() || sc.Text() != signManifestMagic { return fmt.Errorf("%s: không phải %q", signManifestName, signManifestMagic) }
+	bad, total := 0, 0
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), " ", 3)
+		if len(fields) != 3 { return fmt.Errorf("%s: dòng hỏng: %q", signManifestName, sc.Text()) }
+		name, err := strconv.Unquote(fields[2])
+		if err != nil { return fmt.Errorf("%s: tên hỏng: %s", signManifestName, fields[2]) }
+		total++
+		f := files[name]
+		if f == nil { fmt.Printf("%s: FAILED thiếu trong archive\n", name); bad++; continue }
+		delete(files, name)
+		got, size, err := hashZipEntry(f)
+		switch {
+		case err != nil:
+			fmt.Printf("%s: FAILED đọc (%v)\n", name, err)
+			bad++
+		case got != fields[0] || strconv.FormatInt(size, 10) != fields[1]:
+			fmt.Printf("%s: FAILED nội dung khác manifest\n", name)
+			bad++
+		}
+	}
+	if err := sc.Err(); err != nil { return fmt.Errorf("%s: %v", signManifestName, err) }
+	extra := make([]string, 0, len(files))
+	for name := range files { extra = append(extra, name) }
+	sort.Strings(extra)
+	for _, name := range extra {
+		fmt.Printf("%s: FAILED không có trong manifest\n", name)
+		bad++
+	}
+	if bad > 0 { return fmt.Errorf("%d entry không khớp chữ ký (key-id %s)", bad, keyID(pub)) }
+	fmt.Printf("OK: %d entry, chữ ký ed25519 hợp lệ (key-id %s)\n", total, keyID(pub))
+	return nil
+}
+
+func parseSignature(text []byte) ([]byte, error) {
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(text), "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok { fields[strings.TrimSpace(k)] = strings.TrimSpace(v) }
+	}
+	if a := fields["algorithm"]; a != "ed25519" { return nil, fmt.Errorf("thuật toán %q không hỗ trợ", a) }
+	sig, err := base64.StdEncoding.DecodeString(fields["signature"])
+	if err != nil || len(sig) != ed25519.SignatureSize { return nil, errors.New("signature hỏng") }
+	return sig, nil
+}
+
+func readZipEntry(f *zip.File, limit int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil { return nil, err }
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err == nil && int64(len(b)) > limit { err = fmt.Errorf("lớn hơn %s", humanBytes(uint64(limit))) }
+	return b, err
+}
+
+// hashZipEntry reads f to the end, so zip's CRC-32 check runs as well.
+func hashZipEntry(f *zip.File) (string, int64, error) {
+	rc, err := f.Open()
+	if err != nil { return "", 0, err }
+	defer rc.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	return hex.EncodeToString(h.Sum(nil)), n, err
+}
@@ -0,0 +1,27 @@
+package main
+
+// Sources declare each entry's size up front (zip central directory, tar
+// header, 7z listing) and the output header is built from it. The readers
+// catch most short entries themselves (archive/zip checks the count at EOF),
+// but nothing checked what the copy actually produced, so a source that
+// stops early without an error went into the output unnoticed. The merge
+// now compares the two after each entry.
+
+// sizeMismatch describes how the copy of an entry differs from its declared
+// size, or returns "" when they agree.
+func sizeMismatch(declared, copied uint64) string {
+	switch {
+	case copied < declared: return "ngắn hơn khai báo"
+	case copied > declared: return "dài hơn khai báo"
+	}
+	return ""
+}
+
+// zeroReader pads a short entry in a tar output: the header already carries
+// the declared size, and the next header must start where readers expect it.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
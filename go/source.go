@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sourceEntry describes one member of a source archive independent of its
+// container. zf is set for zip sources only (CRC-based append checks etc.).
+type sourceEntry struct {
+	Name           string
+	Modified       time.Time
+	Size           uint64
+	CompressedSize uint64
+	CRC32          uint32
+	IsDir          bool
+	Mode           os.FileMode // permission bits as stored; 0 if the source has none
+	Comment        string      // zip entry comment
+	zf             *zip.File
+	open           func() (io.ReadCloser, error)
+	invalid        error // set by checkEntry: never copied, warned as malformed
+}
+
+// archiveReader is the input side of the merge core. Entries come back in
+// archive order; for streaming containers (tar) an entry's open is only valid
+// until the next call to next.
+type archiveReader interface {
+	next() (*sourceEntry, error)
+	close() error
+}
+
+// tarTools maps tarball suffixes to the external decompressor used for them
+// ("" = plain tar). zstd and xz are not in the standard library, so they are
+// streamed through the CLI tools like tzst output is; "gzip" is read with
+// compress/gzip.
+var tarTools = []struct{ suffix, tool string }{
+	{".tar", ""},
+	{".tar.gz", "gzip"}, {".tgz", "gzip"},
+	{".tar.zst", "zstd"}, {".tzst", "zstd"},
+	{".tar.xz", "xz"}, {".txz", "xz"},
+}
+
+func isSourceName(name string) bool {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".7z") { return true }
+	for _, ext := range []string{".jar", ".war", ".ear"} { // zip files; see jarmode.go
+		if strings.HasSuffix(lower, ext) { return true }
+	}
+	for _, t := range tarTools {
+		if strings.HasSuffix(lower, t.suffix) { return true }
+	}
+	return false
+}
+
+// sourceStem strips the archive suffix (".zip", ".tar.xz", ...) from a name.
+func sourceStem(name string) string {
+	lower := strings.ToLower(name)
+	for _, t := range tarTools {
+		if strings.HasSuffix(lower, t.suffix) { return name[:len(name)-len(t.suffix)] }
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// openSource opens any supported source; every reader comes wrapped in
+// guardedSource (see guard.go).
+func openSource(opt options, path string) (archiveReader, error) {
+	lower := strings.ToLower(path)
+	for _, t := range tarTools {
+		if strings.HasSuffix(lower, t.suffix) {
+			ts, err := openTarSource(path, t.tool)
+			if err != nil { return nil, err }
+			return guardedSource{ts}, nil
+		}
+	}
+	if strings.HasSuffix(lower, ".7z") {
+		ss, err := openSevenZipSource(path)
+		if err != nil { return nil, err }
+		return guardedSource{ss}, nil
+	}
+	var ra io.ReaderAt
+	var size int64
+	var c io.Closer
+	if isRemote(path) {
+		rf, err := openRemoteFile(path)
+		if err != nil { return nil, err }
+		ra, size, c = rf, rf.size, rf
+	} else {
+		f, err := os.Open(path)
+		if err != nil { return nil, err }
+		info, err := f.Stat()
+		if err != nil { _ = f.Close(); return nil, err }
+		ra, size, c = f, info.Size(), f
+	}
+	zr, err := openZipReader(ra, size)
+	if err == nil { err = checkZipReader(zr, size) }
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	decodeNames(opt.srcEncoding, path, zr)
+	return guardedSource{&zipSource{zr: zr, c: c}}, nil
+}
+
+func openZipReader(ra io.ReaderAt, size int64) (zr *zip.Reader, err error) {
+	defer func() {
+		if r := recover(); r != nil { zr, err = nil, malformed("panic khi đọc central directory: %v", r) }
+	}()
+	return zip.NewReader(ra, size)
+}
+
+// listSource reads every header of a source (a full pass for tarballs).
+func listSource(opt options, path string) ([]*sourceEntry, error) {
+	ar, err := openSource(opt, path)
+	if err != nil { return nil, err }
+	defer ar.close()
+	var out []*sourceEntry
+	for {
+		e, err := ar.next()
+		if err == io.EOF { return out, nil }
+		if err != nil { return nil, err }
+		e.open = nil
+		out = append(out, e)
+	}
+}
+
+type zipSource struct {
+	zr *zip.Reader
+	c  io.Closer // the file, or the remoteFile
+	i  int
+}
+
+func (s *zipSource) next() (*sourceEntry, error) {
+	if s.i >= len(s.zr.File) { return nil, io.EOF }
+	f := s.zr.File[s.i]
+	s.i++
+	return &sourceEntry{
+		Name: f.Name, Modified: f.Modified, Size: f.UncompressedSize64, CompressedSize: f.CompressedSize64,
+		CRC32: f.CRC32, IsDir: f.FileInfo().IsDir(), Mode: f.Mode().Perm(), Comment: f.Comment, zf: f, open: f.Open,
+	}, nil
+}
+
+func (s *zipSource) close() error {
+	return s.c.Close()
+}
+
+type tarSource struct {
+	f      io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	tr     *tar.Reader
+}
+
+func openTarSource(path, tool string) (*tarSource, error) {
+	var f io.ReadCloser
+	var err error
+	if isRemote(path) { f, err = openRemoteStream(path) } else { f, err = os.Open(path) }
+	if err != nil { return nil, err }
+	s := &tarSource{f: f}
+	switch tool {
+	case "":
+		s.tr = tar.NewReader(f)
+		return s, nil
+	case "gzip":
+		gz, err := gzip.NewReader(f)
+		if err != nil { _ = f.Close(); return nil, err }
+		s.tr = tar.NewReader(gz)
+		return s, nil
+	}
+	s.cmd = exec.Command(tool, "-dc")
+	s.cmd.Stdin = f
+	s.cmd.Stderr = &s.stderr
+	out, err := s.cmd.StdoutPipe()
+	if err != nil { _ = f.Close(); return nil, err }
+	if err := s.cmd.Start(); err != nil { _ = f.Close(); return nil, fmt.Errorf("không chạy được %s để giải nén: %v", tool, err) }
+	s.tr = tar.NewReader(out)
+	return s, nil
+}
+
+func (s *tarSource) next() (*sourceEntry, error) {
+	for {
+		h, err := s.tr.Next()
+		if err == io.EOF { return nil, io.EOF }
+		if err != nil {
+			if msg := strings.TrimSpace(s.stderr.String()); msg != "" { err = fmt.Errorf("%v (%s)", err, msg) }
+			return nil, err
+		}
+		switch h.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			continue // symlinks, devices, ... have no place in a zip
+		}
+		tr := s.tr
+		return &sourceEntry{
+			Name: h.Name, Modified: h.ModTime, Size: uint64(h.Size), CompressedSize: uint64(h.Size),
+			IsDir: h.Typeflag == tar.TypeDir, Mode: os.FileMode(h.Mode).Perm(),
+			open:  func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}, nil
+	}
+}
+
+func (s *tarSource) close() error {
+	if s.cmd != nil && s.cmd.Process != nil {
+		_ = s.cmd.Process.Kill()
+		_ = s.cmd.Wait()
+	}
+	return s.f.Close()
+}
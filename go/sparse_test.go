@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"sort"
+)
+
+// sparseFile is an io.ReaderAt of size bytes that are zero except for the
+// regions set, so tests can place archive structures beyond 4 GiB without
+// writing them to disk.
+type sparseFile struct {
+	size    int64
+	regions map[int64][]byte
+}
+
+func newSparseFile() *sparseFile { return &sparseFile{regions: map[int64][]byte{}} }
+
+func (s *sparseFile) set(off int64, b []byte) {
+	s.regions[off] = b
+	if end := off + int64(len(b)); end > s.size { s.size = end }
+}
+
+func (s *sparseFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size { return 0, io.EOF }
+	n := len(p)
+	if rest := s.size - off; int64(n) > rest { n = int(rest) }
+	clear(p[:n])
+	offs := make([]int64, 0, len(s.regions))
+	for o := range s.regions { offs = append(offs, o) }
+	sort.Slice(offs, func(i, j int) bool { return offs[i] < offs[j] })
+	for _, o := range offs {
+		b := s.regions[o]
+		if o >= off+int64(n) || o+int64(len(b)) <= off { continue }
+		if o >= off { copy(p[o-off:n], b) } else { copy(p[:n], b[off-o:]) }
+	}
+	if n < len(p) { return n, io.EOF }
+	return n, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Trailer layout (appended to the end of each part when -split-meta is set):
+//   <JSON splitTrailer> <uint32 LE length of JSON> <8-byte magic>
+// The trailer travels inside the part itself, so `join` can still order and
+// validate parts after they have been renamed in transit.
+const (
+	splitTrailerMagic = "MZSPLIT1"
+	maxSplitTrailer   = 1 << 20 // a real trailer is a few hundred bytes
+)
+
+type splitTrailer struct {
+	Index      int    `json:"index"`
+	Total      int    `json:"total"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	PartSize   int64  `json:"part_size"`
+	PartSHA256 string `json:"part_sha256"`
+}
+
+type splitPart struct {
+	path   string
+	size   int64
+	sha256 string
+	sum    hash.Hash // -checksum digest of the part as shipped (trailer included)
+}
+
+func writeSplitTrailers(parts []splitPart, name string, total int64, sum string) error {
+	for i, p := range parts {
+		t := splitTrailer{Index: i, Total: len(parts), Name: name, Size: total, SHA256: sum, PartSize: p.size, PartSHA256: p.sha256}
+		body, err := json.Marshal(t)
+		if err != nil { return err }
+		f, err := os.OpenFile(p.path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil { return err }
+		var n [4]byte
+		binary.LittleEndian.PutUint32(n[:], uint32(len(body)))
+		trailer := append(append(body, n[:]...), splitTrailerMagic...)
+		_, err = f.Write(trailer)
+		if p.sum != nil { p.sum.Write(trailer) }
+		if cErr := f.Close(); err == nil { err = cErr }
+		if err != nil { return err }
+	}
+	return nil
+}
+
+// readSplitTrailer returns (nil, nil) when the file has no trailer.
+func readSplitTrailer(path string) (*splitTrailer, int64, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, 0, err }
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil { return nil, 0, err }
+	size := info.Size()
+	foot := int64(4 + len(splitTrailerMagic))
+	if size < foot { return nil, size, nil }
+	tail := make([]byte, foot)
+	if _, err := f.ReadAt(tail, size-foot); err != nil { return nil, 0, err }
+	if string(tail[4:]) != splitTrailerMagic { return nil, size, nil }
+	n := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if n <= 0 || n > size-foot || n > maxSplitTrailer { return nil, 0, fmt.Errorf("%s: trailer hỏng (độ dài %d)", path, n) }
+	body := make([]byte, n)
+	if _, err := f.ReadAt(body, size-foot-n); err != nil { return nil, 0, err }
+	var t splitTrailer
+	if err := json.Unmarshal(body, &t); err != nil { return nil, 0, fmt.Errorf("%s: trailer hỏng: %v", path, err) }
+	data := size - foot - n
+	if data != t.PartSize { return nil, 0, fmt.Errorf("%s: part bị cắt cụt (%d/%d bytes)", path, data, t.PartSize) }
+	return &t, data, nil
+}
+
+func runJoin(args []string) (err error) {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	outPath := fs.String("o", "", "File đầu ra (mặc định: tên gốc ghi trong trailer, cạnh các part)")
+	_ = fs.Parse(args)
+	paths := fs.Args()
+	if len(paths) == 0 { return errors.New("cần ít nhất một file part") }
+
+	type joinPart struct {
+		path string
+		data int64
+		t    *splitTrailer
+	}
+	var parts []joinPart
+	withMeta := 0
+	for _, p := range paths {
+		t, data, err := readSplitTrailer(p)
+		if err != nil { return err }
+		if t != nil { withMeta++ }
+		parts = append(parts, joinPart{path: p, data: data, t: t})
+	}
+	if withMeta != 0 && withMeta != len(parts) { return errors.New("lẫn part có trailer và không có trailer") }
+
+	var ref *splitTrailer
+	if withMeta == 0 {
+		sort.Slice(parts, func(i, j int) bool { return parts[i].path < parts[j].path })
+		fmt.Println("NOTE: các part không có trailer; ghép theo thứ tự tên file, không kiểm tra được.")
+		if *outPath == "" { return errors.New("part không có trailer: cần -o") }
+	} else {
+		ref = parts[0].t
+		seen := make(map[int]string, len(parts))
+		for _, p := range parts {
+			t := p.t
+			if t.Name != ref.Name || t.Size != ref.Size || t.SHA256 != ref.SHA256 || t.Total != ref.Total {
+				return fmt.Errorf("%s thuộc file khác (%s) so với %s (%s)", p.path, t.Name, parts[0].path, ref.Name)
+			}
+			if prev, ok := seen[t.Index]; ok { return fmt.Errorf("part #%d bị trùng: %s và %s", t.Index, prev, p.path) }
+			seen[t.Index] = p.path
+		}
+		var missing []int
+		for i := 0; i < ref.Total; i++ {
+			if _, ok := seen[i]; !ok { missing = append(missing, i) }
+		}
+		if len(missing) > 0 { return fmt.Errorf("thiếu part %v của %s (có %d/%d)", missing, ref.Name, len(parts), ref.Total) }
+		sort.Slice(parts, func(i, j int) bool { return parts[i].t.Index < parts[j].t.Index })
+		if *outPath == "" { *outPath = filepath.Join(filepath.Dir(parts[0].path), ref.Name) }
+	}
+
+	// The parts are joined into <out>.partial, renamed once every check
+	// passed: a join that fails verification leaves nothing that looks like
+	// the result.
+	partial := *outPath + ".partial"
+	out, err := os.Create(partial)
+	if err != nil { return err }
+	defer func() {
+		_ = out.Close()
+		if err != nil { _ = os.Remove(partial) }
+	}()
+	whole := sha256.New()
+	var total int64
+	for _, p := range parts {
+		in, err := os.Open(p.path)
+		if err != nil { return err }
+		partSum := sha256.New()
+		n, err := io.Copy(io.MultiWriter(out, whole, partSum), io.LimitReader(in, p.data))
+		_ = in.Close()
+		if err != nil { return err }
+		total += n
+		if p.t != nil && hex.EncodeToString(partSum.Sum(nil)) != p.t.PartSHA256 {
+			return fmt.Errorf("%s (part #%d): sha256 không khớp", p.path, p.t.Index)
+		}
+		fmt.Printf("Joined %s (%s)\n", p.path, humanBytes(uint64(n)))
+	}
+	if err := out.Close(); err != nil { return err }
+	if ref != nil {
+		if total != ref.Size { return fmt.Errorf("kích thước sau ghép %d khác %d", total, ref.Size) }
+		if hex.EncodeToString(whole.Sum(nil)) != ref.SHA256 { return errors.New("sha256 của file ghép không khớp") }
+		fmt.Println("Verified sha256 OK")
+	}
+	if err = os.Rename(partial, *outPath); err != nil { return err }
+	fmt.Printf("Done join: %s\n", *outPath)
+	return nil
+}
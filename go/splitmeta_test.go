@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A join that fails verification must not leave a file at the output path,
+// nor its .partial; one that passes leaves only the output.
+func TestJoinVerify(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "m.zip")
+	data := make([]byte, 10000)
+	for i := range data { data[i] = byte(i * 7) }
+	if err := os.WriteFile(src, data, 0o644); err != nil { t.Fatal(err) }
+	opt, err := parseFlags([]string{"-progress", "none", "-q", "-split", "4000", "-split-meta"})
+	if err != nil { t.Fatal(err) }
+	parts, err := rawSplit(context.Background(), src, opt)
+	if err != nil { t.Fatal(err) }
+
+	joined := filepath.Join(dir, "joined.zip")
+	if err := runJoin(append([]string{"-o", joined}, parts...)); err != nil { t.Fatal(err) }
+	if b, err := os.ReadFile(joined); err != nil || string(b) != string(data) { t.Fatalf("joined file differs (%v)", err) }
+	if _, err := os.Stat(joined + ".partial"); !os.IsNotExist(err) { t.Errorf(".partial left behind: %v", err) }
+
+	b, err := os.ReadFile(parts[1])
+	if err != nil { t.Fatal(err) }
+	b[10] ^= 0xFF // in the data, the trailer still checks out
+	if err := os.WriteFile(parts[1], b, 0o644); err != nil { t.Fatal(err) }
+	bad := filepath.Join(dir, "bad.zip")
+	if err := runJoin(append([]string{"-o", bad}, parts...)); err == nil { t.Fatal("corrupt part joined without error") }
+	for _, p := range []string{bad, bad + ".partial"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) { t.Errorf("%s left behind after a failed join: %v", filepath.Base(p), err) }
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+const splitVerifyAttempts = 3
+
+// verifyPart re-reads a freshly written (and fsynced) part and compares it to
+// the digest computed while writing. The page cache is dropped first where
+// the platform allows, so the bytes come from the medium, not from RAM.
+func verifyPart(partName string, size int64, want []byte, buf []byte) error {
+	f, err := os.Open(partName)
+	if err != nil { return err }
+	defer f.Close()
+	dropPageCache(f)
+	sum := sha256.New()
+	n, err := io.CopyBuffer(sum, f, buf)
+	if err != nil { return fmt.Errorf("đọc lại %s: %v", partName, err) }
+	if n != size { return fmt.Errorf("đọc lại %s: %d bytes, đã ghi %d", partName, n, size) }
+	if !bytes.Equal(sum.Sum(nil), want) { return fmt.Errorf("đọc lại %s: sha256 không khớp", partName) }
+	return nil
+}
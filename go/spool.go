@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// spoolWriter stages output in fixed-size segment files on a fast local disk
+// and a background goroutine drains finished segments to the (slow) real
+// destination in large sequential writes. At most spoolQueue segments wait on
+// disk, so a stalled destination eventually applies backpressure.
+type spoolWriter struct {
+	dst     io.Writer
+	dir     string
+	segSize int64
+	seq     int
+	cur     *os.File
+	curN    int64
+	queue   chan string
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+const spoolQueue = 4
+
+func newSpoolWriter(dst io.Writer, dir string, segMB int) (*spoolWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil { return nil, err }
+	s := &spoolWriter{
+		dst: dst, dir: dir, segSize: int64(segMB) * 1024 * 1024,
+		queue: make(chan string, spoolQueue), done: make(chan struct{}),
+	}
+	go s.drain()
+	return s, nil
+}
+
+func (s *spoolWriter) drain() {
+	defer close(s.done)
+	buf := make([]byte, 8*1024*1024)
+	for p := range s.queue {
+		if s.failed() == nil {
+			f, err := os.Open(p)
+			if err == nil {
+				_, err = io.CopyBuffer(s.dst, f, buf)
+				_ = f.Close()
+			}
+			if err != nil { s.fail(fmt.Errorf("spool flush: %v", err)) }
+		}
+		_ = os.Remove(p)
+	}
+}
+
+func (s *spoolWriter) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil { s.err = err }
+	s.mu.Unlock()
+}
+
+func (s *spoolWriter) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *spoolWriter) Write(p []byte) (int, error) {
+	if err := s.failed(); err != nil { return 0, err }
+	written := 0
+	for len(p) > 0 {
+		if s.cur == nil {
+			f, err := os.CreateTemp(s.dir, fmt.Sprintf("mergezip-spool-%06d-*", s.seq))
+			if err != nil { return written, err }
+			s.cur, s.curN = f, 0
+			s.seq++
+		}
+		n := int64(len(p))
+		if room := s.segSize - s.curN; n > room { n = room }
+		m, err := s.cur.Write(p[:n])
+		written += m
+		s.curN += int64(m)
+		if err != nil { return written, err }
+		p = p[m:]
+		if s.curN >= s.segSize {
+			if err := s.rotate(); err != nil { return written, err }
+		}
+	}
+	return written, nil
+}
+
+func (s *spoolWriter) rotate() error {
+	name := s.cur.Name()
+	err := s.cur.Close()
+	s.cur = nil
+	if err != nil { _ = os.Remove(name); return err }
+	s.queue <- name
+	return nil
+}
+
+// close hands over the last partial segment and waits for the destination
+// to catch up.
+func (s *spoolWriter) close() error {
+	if s.queue == nil { return s.failed() }
+	var err error
+	if s.cur != nil { err = s.rotate() }
+	close(s.queue)
+	s.queue = nil
+	<-s.done
+	if fErr := s.failed(); fErr != nil { err = fErr }
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"math"
+	"path"
+	"strings"
+)
+
+// defaultStoreExt lists formats that are already compressed; deflating them
+// again burns CPU for ~0% gain, so they are stored as-is.
+const defaultStoreExt = "jpg,jpeg,png,gif,webp,heic,mp3,mp4,m4a,m4v,mov,mkv,webm,avi," +
+	"gz,tgz,bz2,xz,zst,lz4,zip,7z,rar,jar,docx,xlsx,pptx"
+
+const (
+	entropySample    = 64 * 1024 // bytes peeked from each entry for -store-entropy
+	entropyMinSample = 4 * 1024  // smaller entries are always deflated
+	entropyThreshold = 7.5       // bits/byte; random/compressed data is ~7.9+
+)
+
+func hasStoreExt(exts []string, name string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	if ext == "" { return false }
+	for _, e := range exts {
+		if strings.EqualFold(strings.TrimPrefix(e, "."), ext) { return true }
+	}
+	return false
+}
+
+// entryMethod picks Store or Deflate for one entry. With -store-entropy it
+// peeks the start of the entry; the returned reader replays those bytes.
+func entryMethod(opt options, name string, rc io.Reader, peek []byte) (uint16, io.Reader, error) {
+	if opt.store { return zip.Store, rc, nil }
+	if hasStoreExt(opt.storeExt, name) { return zip.Store, rc, nil }
+	if !opt.storeEntropy { return zip.Deflate, rc, nil }
+	n, err := io.ReadFull(rc, peek)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF { return 0, nil, err }
+	r := io.MultiReader(bytes.NewReader(peek[:n]), rc)
+	if n >= entropyMinSample && shannonEntropy(peek[:n]) >= entropyThreshold { return zip.Store, r, nil }
+	return zip.Deflate, r, nil
+}
+
+func shannonEntropy(b []byte) float64 {
+	var freq [256]int
+	for _, c := range b { freq[c]++ }
+	h := 0.0
+	for _, n := range freq {
+		if n == 0 { continue }
+		p := float64(n) / float64(len(b))
+		h -= p * math.Log2(p)
+	}
+	return h
+}
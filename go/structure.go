@@ -0,0 +1,57 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive structure beyond file data: -keep-dirs carries explicit directory
+// entries over (so empty directories survive the merge), -keep-comments
+// copies per-entry comments, and -comment sets the output's archive comment
+// from a template.
+
+func validateStructure(opt *options) error {
+	if opt.format != "zip" && (opt.keepComments || opt.comment != "") {
+		return errors.New("-keep-comments/-comment chỉ dùng được với -format zip (tar không có comment)")
+	}
+	return nil
+}
+
+// claimDir maps a directory entry to its output name. Directories are never
+// __dupN-renamed: ok is false when the output already has one by that name.
+func claimDir(opt options, zipName, inner string, dedup map[string]int) (target string, ok bool) {
+	target = strings.TrimRight(targetBase(opt, zipName, inner), "/") + "/"
+	if _, seen := dedup[target]; seen { return target, false }
+	dedup[target] = 1
+	return target, true
+}
+
+func dirHeader(target string, e *sourceEntry) *zip.FileHeader {
+	hdr := &zip.FileHeader{Name: target, Method: zip.Store, Modified: e.Modified}
+	if hdr.Modified.IsZero() { hdr.Modified = time.Now() }
+	mode := e.Mode
+	if mode == 0 { mode = 0o755 }
+	hdr.SetMode(os.ModeDir | mode)
+	return hdr
+}
+
+// renderComment fills the -comment template: {sources} (comma-separated
+// source names), {count}, {time} (RFC3339), {job}; a literal \n is a line
+// break.
+func renderComment(tmpl string, names []string, job string) (string, error) {
+	if tmpl == "" { return "", nil }
+	c := strings.NewReplacer(
+		`\n`, "\n",
+		"{sources}", strings.Join(names, ", "),
+		"{count}", strconv.Itoa(len(names)),
+		"{time}", time.Now().Format(time.RFC3339),
+		"{job}", job,
+	).Replace(tmpl)
+	if len(c) > 0xFFFF { return "", fmt.Errorf("-comment dài %d bytes sau khi thay {sources}... (zip cho tối đa 65535)", len(c)) }
+	return c, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Tape pipelines (-block-size, -checkpoint): the output leaves the process in
+// writes of exactly one block each, the archive ends on a block boundary,
+// and every -checkpoint bytes the file is synced and a checkpoint logged.
+
+func validateTape(opt *options) error {
+	var err error
+	if opt.blockSize != "" {
+		if opt.blockBytes, err = parseSize(opt.blockSize); err != nil || opt.blockBytes < 512 || opt.blockBytes%512 != 0 || opt.blockBytes > 64<<20 {
+			return fmt.Errorf("-block-size không hợp lệ: %q (bội của 512, tối đa 64m)", opt.blockSize)
+		}
+		switch {
+		case opt.format == "tgz" || opt.format == "tzst": return errors.New("-block-size chỉ dùng với -format zip hoặc tar")
+		case opt.appendOut: return errors.New("-block-size không dùng chung được với -append/-watch")
+		}
+	}
+	if opt.checkpoint != "" {
+		if opt.ckptBytes, err = parseSize(opt.checkpoint); err != nil || opt.ckptBytes <= 0 {
+			return fmt.Errorf("-checkpoint không hợp lệ: %q", opt.checkpoint)
+		}
+	}
+	return nil
+}
+
+// blockWriter re-chunks the output stream into fixed-size writes (a tape
+// drive in variable-block mode records each write as one block) and pads
+// the last block with zeros on close.
+type blockWriter struct {
+	w       io.Writer
+	f       *os.File // synced at checkpoints
+	buf     []byte
+	n       int
+	written int64
+	every   int64
+	next    int64
+	pad     bool // false with -checkpoint alone: blocks are just buffering then
+}
+
+func newBlockWriter(w io.Writer, f *os.File, size, every int64) *blockWriter {
+	b := &blockWriter{w: w, f: f, every: every, next: every, pad: size > 0}
+	if size <= 0 { size = 64 << 10 }
+	b.buf = make([]byte, size)
+	return b
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		m := copy(b.buf[b.n:], p)
+		b.n += m
+		p = p[m:]
+		if b.n == len(b.buf) {
+			if err := b.flushBlock(); err != nil { return total - len(p), err }
+		}
+	}
+	return total, nil
+}
+
+func (b *blockWriter) flushBlock() error {
+	if _, err := b.w.Write(b.buf); err != nil { return err }
+	b.n = 0
+	b.written += int64(len(b.buf))
+	if b.every > 0 && b.written >= b.next {
+		for b.next <= b.written { b.next += b.every }
+		_ = b.f.Sync() // EINVAL on pipes; the log line is the checkpoint then
+		blocks := b.written / int64(len(b.buf))
+		logf("Checkpoint: %s (%d blocks) đã ghi", humanBytes(uint64(b.written)), blocks)
+		record(slog.LevelInfo, "checkpoint", "bytes", b.written, "blocks", blocks)
+	}
+	return nil
+}
+
+// close writes the last, zero-padded block.
+func (b *blockWriter) close() error {
+	if b.n == 0 { return nil }
+	if !b.pad {
+		_, err := b.w.Write(b.buf[:b.n])
+		b.n = 0
+		return err
+	}
+	for i := b.n; i < len(b.buf); i++ { b.buf[i] = 0 }
+	b.n = len(b.buf)
+	return b.flushBlock()
+}
+
+// paddedZip makes a zip end exactly on a block boundary. Padding after the
+// end record would hide it from readers, so zip.Writer's tail (last data
+// descriptor, central directory, end record) is captured and the zeros go
+// between the last entry and the directory instead.
+type paddedZip struct {
+	zw    *zip.Writer
+	cw    *captureWriter
+	block int64
+}
+
+func (a *paddedZip) create(hdr *zip.FileHeader) (io.Writer, error) { return a.zw.CreateHeader(hdr) }
+
+func (a *paddedZip) close() error {
+	if err := a.zw.Flush(); err != nil { return err }
+	a.cw.capture = true
+	if err := a.zw.Close(); err != nil { return err }
+	tail, err := readZipDirectory(a.cw, a.cw.pos, a.cw.pos+int64(a.cw.buf.Len()))
+	if err != nil { return fmt.Errorf("central directory mới không hợp lệ: %v", err) }
+	if _, err := a.cw.w.Write(a.cw.buf.Bytes()[:tail.offset-a.cw.pos]); err != nil { return err }
+	cdSize := uint64(len(tail.raw))
+	zip64 := tail.zip64 || tail.entries >= 0xFFFF || cdSize >= 0xFFFFFFFF || tail.offset+a.block >= 0xFFFFFFFF
+	endLen := int64(len(buildEOCD(tail.entries, cdSize, 0, tail.comment, zip64)))
+	pad := (a.block - (tail.offset+int64(cdSize)+endLen)%a.block) % a.block
+	if _, err := a.cw.w.Write(make([]byte, pad)); err != nil { return err }
+	if _, err := a.cw.w.Write(tail.raw); err != nil { return err }
+	_, err = a.cw.w.Write(buildEOCD(tail.entries, cdSize, uint64(tail.offset+pad), tail.comment, zip64))
+	return err
+}
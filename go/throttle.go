@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// -max-read-mbps / -max-write-mbps keep a long merge from saturating a
+// shared disk or NAS. Reads are counted as the copy loop sees them (after
+// decompression, so never less than what actually comes off the disk);
+// writes at the bottom of the output stack, below the spool.
+
+// rateLimiter is a token bucket in bytes that holds at most one second's
+// worth of rate. It is shared by every reader/writer of a run.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(mbps float64) *rateLimiter {
+	if mbps <= 0 { return nil }
+	r := mbps * 1024 * 1024
+	return &rateLimiter{rate: r, tokens: r, last: time.Now()}
+}
+
+// wait accounts for n bytes and sleeps while the bucket is in debt. A chunk
+// bigger than the bucket simply takes the debt, so -chunk 64 against 10 MB/s
+// means one 6-second pause per chunk rather than never passing.
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 { return }
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate { l.tokens = l.rate }
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 { d = time.Duration(-l.tokens / l.rate * float64(time.Second)) }
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+type throttledReader struct {
+	r    io.Reader
+	lims []*rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	for _, l := range t.lims { l.wait(n) }
+	return n, err
+}
+
+// limitReader charges what r returns to every non-nil limiter in lims.
+func limitReader(r io.Reader, lims ...*rateLimiter) io.Reader {
+	var active []*rateLimiter
+	for _, l := range lims {
+		if l != nil { active = append(active, l) }
+	}
+	if len(active) == 0 { return r }
+	return &throttledReader{r: r, lims: active}
+}
+
+type throttledWriter struct {
+	w   io.Writer
+	lim *rateLimiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	t.lim.wait(len(p))
+	return t.w.Write(p)
+}
+
+func limitWriter(w io.Writer, lim *rateLimiter) io.Writer {
+	if lim == nil { return w }
+	return &throttledWriter{w: w, lim: lim}
+}
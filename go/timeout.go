@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// -zip-timeout bounds the time spent on one source (hung NFS mount, a zip
+// bomb that decompresses forever): the source is left with a warning and the
+// merge goes on with the next one. -deadline bounds the whole merge: once it
+// passes, no further source is started and the output is finalized as usual,
+// so it is a valid archive of what made it, and the sources left out are
+// listed in a warning.
+//
+// Both act between entries, and in the middle of an entry's copy when the
+// output can drop that entry again (a new zip file, see rollback.go). For
+// other outputs the entry being copied is finished first, so a read that
+// hangs for good is only cut short there by Ctrl-C.
+
+var (
+	errZipTimeout = errors.New("quá -zip-timeout")
+	errDeadline   = errors.New("hết -deadline")
+)
+
+func validateTimeouts(opt *options) error {
+	if opt.zipTimeout < 0 { return fmt.Errorf("-zip-timeout không hợp lệ: %v", opt.zipTimeout) }
+	if opt.deadline < 0 { return fmt.Errorf("-deadline không hợp lệ: %v", opt.deadline) }
+	return nil
+}
+
+// deadlineContext applies -deadline to the run's context.
+func deadlineContext(ctx context.Context, opt options) (context.Context, context.CancelFunc) {
+	if opt.deadline <= 0 { return context.WithCancel(ctx) }
+	return context.WithTimeout(ctx, opt.deadline)
+}
+
+// sourceContext applies -zip-timeout to one source.
+func sourceContext(run context.Context, opt options) (context.Context, context.CancelFunc) {
+	if opt.zipTimeout <= 0 { return context.WithCancel(run) }
+	return context.WithTimeout(run, opt.zipTimeout)
+}
+
+// stopCause tells why a source context ended: a signal (errCanceled),
+// -deadline, or -zip-timeout. nil while it is still running.
+func stopCause(sig, run, src context.Context) error {
+	switch {
+	case sig.Err() != nil: return errCanceled
+	case run.Err() != nil: return errDeadline
+	case src.Err() != nil: return errZipTimeout
+	}
+	return nil
+}
+
+// reportMissing warns about the sources -deadline (or -on-low-disk finish,
+// lowDisk) left out of the output.
+func reportMissing(opt options, wl *warnLog, missing []string, lowDisk bool) error {
+	if len(missing) == 0 { return nil }
+	list := missing
+	if len(list) > 10 { list = append(append([]string{}, list[:10]...), fmt.Sprintf("... (+%d)", len(missing)-10)) }
+	if lowDisk {
+		record(slog.LevelInfo, "low-disk", "min_free", opt.minFreeBytes, "action", lowDiskFinish, "missing", missing)
+		return wl.warn(warnDisk, "dung lượng trống dưới -min-free %s: %d nguồn chưa (hết) được merge: %s", opt.minFree, len(missing), strings.Join(list, ", "))
+	}
+	record(slog.LevelInfo, "deadline", "deadline", opt.deadline.String(), "missing", missing)
+	return wl.warn(warnTimeout, "hết -deadline %s: %d nguồn chưa (hết) được merge: %s", opt.deadline, len(missing), strings.Join(list, ", "))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -upload copies the finished output (only its parts when -split, then the
+// -checksum sidecar and -manifest) to an https:// or s3:// prefix with one PUT
+// per file; s3 objects over 5 GiB, the limit of a single PUT, go up as a
+// multipart upload. With -upload-concurrency 0 the number of parallel PUTs is tuned
+// while uploading: it grows while it still buys throughput and halves on
+// every failed or throttled request, so a saturated uplink is not pushed into
+// packet loss by a fixed high parallelism.
+
+const (
+	uploadStart    = 2 // parallel PUTs the tuner starts with
+	uploadMaxAuto  = 8
+	uploadAttempts = 4
+	uploadGain     = 1.15 // throughput gain that earns one more PUT
+)
+
+// uploadClient has no overall timeout: a part may take hours on a slow uplink.
+var uploadClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, ResponseHeaderTimeout: 5 * time.Minute}}
+
+func validateUpload(opt *options) error {
+	if opt.upload == "" {
+		if opt.uploadConc != 0 || opt.uploadDelta { return errors.New("-upload-concurrency/-upload-delta cần -upload") }
+		return nil
+	}
+	switch {
+	case !isRemote(opt.upload): return fmt.Errorf("-upload cần URL https://... hoặc s3://bucket/prefix: %q", opt.upload)
+	case opt.toStdout, opt.outDevice != "": return errors.New("-upload cần output là file (không dùng với -out - hay FIFO/thiết bị)")
+	case opt.watch: return errors.New("-upload không dùng chung được với -watch")
+	case opt.uploadConc < 0: return errors.New("-upload-concurrency phải >= 0 (0: tự điều chỉnh)")
+	case opt.uploadDelta && !strings.HasPrefix(strings.ToLower(opt.upload), "s3://"): return errors.New("-upload-delta chỉ dùng được với -upload s3://... (https không có thao tác sao chép phía server)")
+	}
+	return nil
+}
+
+// uploadTuner is a counting semaphore whose limit follows the observed
+// throughput (additive increase per round of completed uploads, halving on
+// errors). With fixed set it is a plain semaphore.
+type uploadTuner struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+	fixed  bool
+
+	roundStart time.Time
+	roundBytes int64
+	roundDone  int
+	lastRate   float64 // bytes/s of the previous round
+}
+
+func newUploadTuner(fixed int) *uploadTuner {
+	t := &uploadTuner{limit: uploadStart, fixed: fixed > 0, roundStart: time.Now()}
+	if t.fixed { t.limit = fixed }
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+func (t *uploadTuner) acquire() {
+	t.mu.Lock()
+	for t.active >= t.limit { t.cond.Wait() }
+	t.active++
+	t.mu.Unlock()
+}
+
+// release ends one PUT of n bytes; failed marks a retryable failure
+// (network error, 5xx, 429), which halves the limit.
+func (t *uploadTuner) release(n int64, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.cond.Broadcast()
+	t.active--
+	if t.fixed { return }
+	if failed {
+		if t.limit > 1 {
+			t.limit /= 2
+			logEvent("upload-tune", fmt.Sprintf("Upload: lỗi/bị nghẽn, giảm còn %d luồng", t.limit), "limit", t.limit, "reason", "error")
+		}
+		t.newRound(0)
+		return
+	}
+	t.roundBytes += n
+	t.roundDone++
+	if t.roundDone < t.limit { return } // judge a level only after a full round at it
+	rate := float64(t.roundBytes) / time.Since(t.roundStart).Seconds()
+	prev := t.limit
+	switch {
+	case t.lastRate == 0 || rate > t.lastRate*uploadGain:
+		if t.limit < uploadMaxAuto { t.limit++ }
+	case rate < t.lastRate/uploadGain && t.limit > 1:
+		t.limit--
+	}
+	if t.limit != prev {
+		logEvent("upload-tune", fmt.Sprintf("Upload: %s/s với %d luồng -> %d luồng", humanBytes(uint64(rate)), prev, t.limit),
+			"limit", t.limit, "bytes_per_sec", int64(rate))
+	}
+	t.newRound(rate)
+}
+
+func (t *uploadTuner) newRound(rate float64) {
+	t.roundStart, t.roundBytes, t.roundDone = time.Now(), 0, 0
+	if rate > 0 { t.lastRate = rate }
+}
+
+// uploadOutputs uploads every file the run produced. The sidecars go in a
+// second batch once all data files are up, so a SHA256SUMS at the
+// destination means the files it lists have arrived.
+func uploadOutputs(ctx context.Context, opt options, outputs, parts []string) error {
+	if opt.upload == "" { return nil }
+	data := parts // a split output is uploaded as its parts only
+	if len(parts) == 0 { data = outputs }
+	var sidecars []string
+	if opt.checksum != "" { sidecars = append(sidecars, checksumPath(opt)) }
+	if opt.manifest != "" { sidecars = append(sidecars, manifestPath(opt)) }
+
+	t := newUploadTuner(opt.uploadConc)
+	start := time.Now()
+	var total int64
+	for i, batch := range [][]string{data, sidecars} {
+		n, err := uploadBatch(ctx, t, opt, batch, opt.uploadDelta && i == 0)
+		total += n
+		if err != nil { return err }
+	}
+	logf("Upload xong %d file (%s) lên %s trong %s, %s/s", len(data)+len(sidecars), humanBytes(uint64(total)), opt.upload,
+		fmtHMS(time.Since(start)), humanBytes(uint64(float64(total)/time.Since(start).Seconds())))
+	return nil
+}
+
+// uploadBatch uploads files in parallel under t and waits for all of them;
+// the first failure cancels the rest. n counts the bytes sent.
+func uploadBatch(ctx context.Context, t *uploadTuner, opt options, files []string, delta bool) (int64, error) {
+	bctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu    sync.Mutex
+		first error
+		total int64
+		wg    sync.WaitGroup
+	)
+	for _, f := range files {
+		wg.Add(1)
+		go func(f string) {
+			defer wg.Done()
+			var n int64
+			var err error
+			if delta {
+				n, err = uploadDelta(bctx, t, opt, f)
+			} else {
+				n, err = uploadFile(bctx, t, opt.upload, f)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			total += n
+			if err != nil && first == nil { first = err; cancel() }
+		}(f)
+	}
+	wg.Wait()
+	if ctx.Err() != nil { return total, canceled() }
+	return total, first
+}
+
+func uploadFile(ctx context.Context, t *uploadTuner, prefix, path string) (int64, error) {
+	target := uploadURL(prefix, filepath.Base(path))
+	if info, err := os.Stat(path); err == nil && info.Size() > s3MaxPut && strings.HasPrefix(strings.ToLower(target), "s3://") {
+		return uploadMultipart(ctx, t, target, path, info.Size())
+	}
+	n, err := withRetry(ctx, t, filepath.Base(path), func() (int64, bool, error) { return putFile(ctx, target, path) })
+	if err == nil { logEvent("upload", fmt.Sprintf("Upload %s (%s)", filepath.Base(path), humanBytes(uint64(n))), "file", path, "url", target, "bytes", n) }
+	return n, err
+}
+
+// uploadMultipart sends a file too large for one PUT to s3 in parts of
+// about deltaPart bytes, which go up in parallel under t like whole files.
+func uploadMultipart(ctx context.Context, t *uploadTuner, target, path string, size int64) (int64, error) {
+	parts := planParts([]deltaSeg{{off: 0, n: size, src: -1}}, size)
+	_, n, _, err := s3PutParts(ctx, t, target, path, parts, "")
+	if err != nil { return n, err }
+	logEvent("upload", fmt.Sprintf("Upload %s (%s, multipart %d part)", filepath.Base(path), humanBytes(uint64(n)), len(parts)), "file", path, "url", target, "bytes", n, "parts", len(parts))
+	return n, nil
+}
+
+// withRetry makes one request per attempt, each under its own slot of t,
+// and retries retryable failures with backoff; a retry therefore waits for
+// the (lowered) limit like any other request.
+func withRetry(ctx context.Context, t *uploadTuner, what string, do func() (n int64, retry bool, err error)) (int64, error) {
+	for attempt := 1; ; attempt++ {
+		t.acquire()
+		n, retry, err := do()
+		t.release(n, err != nil && retry)
+		if err == nil { return n, nil }
+		if ctx.Err() != nil { return 0, errCanceled }
+		if !retry || attempt >= uploadAttempts { return 0, fmt.Errorf("upload %s: %v", what, err) }
+		wait := time.Duration(1<<(attempt-1)) * time.Second
+		errorf("WARNING: upload %s lỗi (%v), thử lại lần %d/%d sau %s", what, err, attempt+1, uploadAttempts, wait)
+		select {
+		case <-ctx.Done(): return 0, errCanceled
+		case <-time.After(wait):
+		}
+	}
+}
+
+func uploadURL(prefix, name string) string {
+	prefix = strings.TrimRight(prefix, "/") + "/"
+	if strings.HasPrefix(strings.ToLower(prefix), "s3://") { return prefix + name } // remoteRequest escapes the key
+	return prefix + url.PathEscape(name)
+}
+
+// putFile sends path in one PUT (s3 takes at most s3MaxPut that way). retry reports whether a failure is worth
+// another attempt.
+func putFile(ctx context.Context, target, path string) (n int64, retry bool, err error) {
+	f, err := os.Open(path)
+	if err != nil { return 0, false, err }
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil { return 0, false, err }
+	req, err := remoteRequestBody(http.MethodPut, target, io.NopCloser(f), info.Size(), unsignedPayload, nil)
+	if err != nil { return 0, false, err }
+	resp, err := uploadClient.Do(req.WithContext(ctx))
+	if err != nil { return 0, true, err }
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+		return 0, retry, fmt.Errorf("PUT %s: %s", target, resp.Status)
+	}
+	return info.Size(), false, nil
+}
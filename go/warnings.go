@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Exit codes. 2 and 3 predate this list (flag errors, split errors).
+const (
+	exitOK       = 0
+	exitFatal    = 1
+	exitUsage    = 2
+	exitSplit    = 3
+	exitWarnings = 4
+	exitOverflow = 5
+	exitChanged  = 6 // `plan -compare`: the plan differs from the previous one
+	exitRunning  = 7 // -idempotency-key: the job with this key is still running
+	exitNoSpace  = 8
+	exitCanceled = 130 // 128 + SIGINT, as shells report it
+)
+
+// Warning categories, used for the end-of-run summary.
+const (
+	warnOpen      = "open"      // source archive could not be opened
+	warnList      = "list"      // source archive listing broke off
+	warnCreate    = "create"    // output entry could not be created
+	warnEntry     = "entry"     // source entry could not be opened
+	warnRead      = "read"      // source entry failed mid-copy
+	warnChanged   = "changed"   // source modified between pre-scan and merge
+	warnQuota     = "quota"     // output directory still over -quota after the run
+	warnOverflow  = "overflow"  // entries left out by -on-overflow truncate-report
+	warnMalformed = "malformed" // corrupt or hostile source archive/entry (guard.go)
+	warnWrite     = "write"     // output entry rolled back after a write error (rollback.go)
+	warnSize      = "size"      // entry copied with a size other than its source declared (sizecheck.go)
+	warnTimeout   = "timeout"   // source cut short by -zip-timeout, or left out by -deadline
+	warnSecret    = "secret"    // -secret-scan report: entry looks like it holds a credential
+	warnDisk      = "disk"      // sources left out by -on-low-disk finish
+)
+
+var (
+	errNoSpace  = errors.New("không đủ dung lượng trống")
+	errOverflow = errors.New("vượt giới hạn output")
+)
+
+// warnLog collects non-fatal problems. With -strict the first warning aborts
+// the run; with -max-warnings N the (N+1)-th does.
+type warnLog struct {
+	strict bool
+	max    int
+	counts map[string]int
+	total  int
+}
+
+func newWarnLog(opt options) *warnLog {
+	return &warnLog{strict: opt.strict, max: opt.maxWarnings, counts: map[string]int{}}
+}
+
+// warn prints a warning and returns a non-nil error when the run must stop.
+func (w *warnLog) warn(category, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	printErr("WARNING: %s", msg)
+	record(slog.LevelWarn, "warning", "category", category, "text", msg)
+	w.counts[category]++
+	w.total++
+	if w.strict { return fmt.Errorf("-strict: %s", msg) }
+	if w.max >= 0 && w.total > w.max { return fmt.Errorf("vượt quá -max-warnings %d (%s)", w.max, msg) }
+	return nil
+}
+
+func (w *warnLog) summary() string {
+	if w.total == 0 { return "" }
+	var cats []string
+	for c := range w.counts { cats = append(cats, c) }
+	sort.Strings(cats)
+	parts := make([]string, 0, len(cats))
+	for _, c := range cats { parts = append(parts, fmt.Sprintf("%s=%d", c, w.counts[c])) }
+	return fmt.Sprintf("Warnings: %d (%s)", w.total, strings.Join(parts, ", "))
+}
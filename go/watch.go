@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+)
+
+// -watch keeps running and appends sources to the output as they arrive.
+// The input directory is polled (no fsnotify outside the standard library);
+// a source is taken once its size and mtime have not changed for
+// -stable-for, so parts still being uploaded are left alone.
+
+type pendingSource struct {
+	stamp sourceStamp
+	since time.Time
+}
+
+// validateWatch turns -watch into an -append run and rejects what cannot be
+// repeated per incorporation.
+func validateWatch(opt *options) error {
+	if !opt.watch { return nil }
+	switch {
+	case opt.toStdout: return errors.New("-watch không dùng chung được với -out -")
+	case opt.splitSize != "": return errors.New("-watch không dùng chung được với -split (hãy split khi đã đủ part)")
+	case opt.format != "zip": return errors.New("-watch chỉ hỗ trợ -format zip")
+	case opt.watchEvery <= 0: return errors.New("-watch-interval phải > 0")
+	}
+	opt.appendOut = true
+	return nil
+}
+
+// runWatch merges every new stable source into the output until ctx is
+// cancelled. A stop while idle is a clean exit; a stop mid-merge rolls back
+// like any cancelled -append.
+func runWatch(ctx context.Context, opt options, wl *warnLog) error {
+	done := map[string]sourceStamp{}
+	pending := map[string]pendingSource{}
+	logf("Watch: theo dõi %s (mỗi %s, ổn định %s), Ctrl-C để dừng", opt.inputDir, opt.watchEvery, opt.stableFor)
+	for {
+		names, paths, err := collectSources(opt)
+		if err != nil {
+			if err := wl.warn(warnList, "watch: không liệt kê được %s: %v", opt.inputDir, err); err != nil { return err }
+		}
+		names, paths = filterMinAge(opt, names, paths)
+		ready := map[string]bool{}
+		var readyNames []string
+		for i, p := range paths {
+			st, err := stampSource(p)
+			if err != nil { continue }
+			if prev, ok := done[p]; ok && prev == st { continue }
+			if ps, ok := pending[p]; !ok || ps.stamp != st {
+				pending[p] = pendingSource{st, time.Now()}
+				if opt.stableFor > 0 { continue }
+			}
+			if time.Since(pending[p].since) < opt.stableFor { continue }
+			ready[p] = true
+			readyNames = append(readyNames, names[i])
+		}
+
+		if len(ready) > 0 {
+			o := opt
+			o.only = ready
+			outputs, err := mergeZIP(ctx, o, wl)
+			if err != nil { return err }
+			for p := range ready {
+				done[p] = pending[p].stamp
+				delete(pending, p)
+			}
+			for _, n := range readyNames { logf("Watch: đã gộp %s vào %s", n, filepath.Base(outputs[len(outputs)-1])) }
+			if opt.quotaBytes > 0 {
+				if _, err := pruneOutputs(opt, outputs, 0, 0); err != nil {
+					if err := wl.warn(warnQuota, "%v", err); err != nil { return err }
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logf("Watch: dừng (%d nguồn đã gộp)", len(done))
+			return nil
+		case <-time.After(opt.watchEvery):
+		}
+	}
+}
+
+// keepOnly restricts a source list to the paths in only (-watch).
+func keepOnly(names, paths []string, only map[string]bool) ([]string, []string) {
+	var keepNames, keepPaths []string
+	for i, p := range paths {
+		if !only[p] { continue }
+		keepNames = append(keepNames, names[i])
+		keepPaths = append(keepPaths, p)
+	}
+	return keepNames, keepPaths
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestZip writes a zip of stored entries: n small ones, or with huge
+// set a single one of that many zero bytes.
+func writeTestZip(t *testing.T, path string, n int, huge int64) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil { t.Fatal(err) }
+	defer f.Close()
+	bw := bufio.NewWriterSize(f, 1<<20)
+	zw := zip.NewWriter(bw)
+	mod := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if huge > 0 {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "huge.bin", Method: zip.Store, Modified: mod})
+		if err != nil { t.Fatal(err) }
+		if _, err := io.CopyN(w, zeroReader{}, huge); err != nil { t.Fatal(err) }
+	}
+	for i := 0; i < n; i++ {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("%s/%05d.txt", filepath.Base(path), i), Method: zip.Store, Modified: mod})
+		if err != nil { t.Fatal(err) }
+		if _, err := fmt.Fprintf(w, "%d\n", i); err != nil { t.Fatal(err) }
+	}
+	if err := zw.Close(); err != nil { t.Fatal(err) }
+	if err := bw.Flush(); err != nil { t.Fatal(err) }
+}
+
+func outputDirectory(t *testing.T, path string) *zipDirectory {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil { t.Fatal(err) }
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil { t.Fatal(err) }
+	d, err := readZipDirectory(f, 0, info.Size())
+	if err != nil { t.Fatal(err) }
+	return d
+}
+
+// More than 65,535 entries need the Zip64 end record, in a new output and
+// again when -append rewrites it.
+func TestZip64ManyEntries(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	const many = 70000
+	writeTestZip(t, filepath.Join(in, "many.zip"), many, 0)
+	args := []string{"-input", in, "-outdir", out, "-out", "m", "-store", "-append"}
+	runMerge(t, args...)
+	path := filepath.Join(out, "m.zip")
+	if d := outputDirectory(t, path); !d.zip64 || d.entries != many { t.Fatalf("new output: zip64 %v, %d entries; want Zip64 end record, %d", d.zip64, d.entries, many) }
+	if n := entryCount(t, path); n != many { t.Fatalf("archive/zip reads %d entries, want %d", n, many) }
+
+	writeTestZip(t, filepath.Join(in, "more.zip"), 3, 0)
+	runMerge(t, args...)
+	if d := outputDirectory(t, path); !d.zip64 || d.entries != many+3 { t.Fatalf("after -append: zip64 %v, %d entries; want Zip64 end record, %d", d.zip64, d.entries, many+3) }
+	if n := entryCount(t, path); n != many+3 { t.Fatalf("archive/zip reads %d entries after -append, want %d", n, many+3) }
+}
+
+// cdRecord is a central directory record with the given 32-bit fields and
+// extra field.
+func cdRecord(name string, csize, usize, off uint32, extra []byte) []byte {
+	r := make([]byte, 46, 46+len(name)+len(extra))
+	le := binary.LittleEndian
+	le.PutUint32(r, sigCentralDir)
+	le.PutUint32(r[20:], csize)
+	le.PutUint32(r[24:], usize)
+	le.PutUint16(r[28:], uint16(len(name)))
+	le.PutUint16(r[30:], uint16(len(extra)))
+	le.PutUint32(r[42:], off)
+	return append(append(r, name...), extra...)
+}
+
+func zip64Extra(fields ...uint64) []byte {
+	b := make([]byte, 4+8*len(fields))
+	binary.LittleEndian.PutUint16(b, 0x0001)
+	binary.LittleEndian.PutUint16(b[2:], uint16(8*len(fields)))
+	for i, v := range fields { binary.LittleEndian.PutUint64(b[4+8*i:], v) }
+	return b
+}
+
+func TestDropLastRecordZip64(t *testing.T) {
+	const sat = 0xFFFFFFFF
+	first := cdRecord("a.txt", 3, 3, 0, nil)
+	for _, tc := range []struct {
+		name string
+		last []byte
+		want int64
+	}{
+		{"32-bit offset", cdRecord("b.txt", 3, 3, 1234, nil), 1234},
+		{"zip64 offset", cdRecord("b.txt", 3, 3, sat, zip64Extra(5<<30+7)), 5<<30 + 7},
+		{"zip64 sizes and offset", cdRecord("b.bin", sat, sat, sat, zip64Extra(6<<30, 6<<30, 9<<30)), 9 << 30},
+		{"zip64 size only", cdRecord("b.bin", 10, sat, 4321, zip64Extra(6<<30)), 4321},
+	} {
+		raw := append(append([]byte(nil), first...), tc.last...)
+		rest, off, err := dropLastRecord(raw)
+		if err != nil { t.Errorf("%s: %v", tc.name, err); continue }
+		if off != tc.want || string(rest) != string(first) { t.Errorf("%s: offset %d, %d bytes left; want %d, %d", tc.name, off, len(rest), tc.want, len(first)) }
+	}
+	if _, _, err := dropLastRecord(cdRecord("b.txt", 3, 3, sat, zip64Extra())); err == nil { t.Error("zip64 offset without its extra field: no error") }
+}
+
+// A raw split cuts the archive anywhere, Zip64 end record included; join
+// must give back the same bytes, with a directory that parses.
+func TestZip64SplitJoin(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	const many = 70000
+	writeTestZip(t, filepath.Join(in, "many.zip"), many, 0)
+	runMerge(t, "-input", in, "-outdir", out, "-out", "m", "-store")
+	path := filepath.Join(out, "m.zip")
+	want, err := os.ReadFile(path)
+	if err != nil { t.Fatal(err) }
+	total := int64(len(want))
+	d := outputDirectory(t, path)
+	if !d.zip64 { t.Fatal("no Zip64 end record") }
+	// Boundaries inside the central directory, inside the Zip64 end record
+	// and its locator, and inside the classic end record.
+	for _, cut := range []int64{d.offset + 1000, total - 22 - 20 - 30, total - 22 - 10, total - 5} {
+		opt, err := parseFlags([]string{"-progress", "none", "-q", "-split", fmt.Sprint(cut), "-split-meta"})
+		if err != nil { t.Fatal(err) }
+		parts, err := rawSplit(context.Background(), path, opt)
+		if err != nil { t.Fatal(err) }
+		if len(parts) < 2 { t.Fatalf("split at %d: %d parts", cut, len(parts)) }
+		joined := filepath.Join(t.TempDir(), "joined.zip")
+		if err := runJoin(append([]string{"-o", joined}, parts...)); err != nil { t.Fatalf("join, split at %d: %v", cut, err) }
+		got, err := os.ReadFile(joined)
+		if err != nil { t.Fatal(err) }
+		if !bytes.Equal(got, want) { t.Fatalf("split at %d: joined file differs", cut) }
+		if jd := outputDirectory(t, joined); !jd.zip64 || jd.entries != many || jd.offset != d.offset { t.Fatalf("split at %d: joined directory zip64 %v, %d entries at %d", cut, jd.zip64, jd.entries, jd.offset) }
+	}
+}
+
+// An entry over 4 GiB puts the central directory beyond 4 GiB as well; it
+// is merged and then appended to. Writes about 9 GB to TMPDIR, so it only
+// runs with MERGEZIP_BIG_TESTS set.
+func TestZip64HugeEntry(t *testing.T) {
+	if os.Getenv("MERGEZIP_BIG_TESTS") == "" { t.Skip("writes > 8 GB; set MERGEZIP_BIG_TESTS=1 to run") }
+	in, out := t.TempDir(), t.TempDir()
+	const huge int64 = 4<<30 + 1<<20
+	writeTestZip(t, filepath.Join(in, "huge.zip"), 0, huge)
+	args := []string{"-input", in, "-outdir", out, "-out", "m", "-store", "-append"}
+	runMerge(t, args...)
+	path := filepath.Join(out, "m.zip")
+	if d := outputDirectory(t, path); !d.zip64 || d.offset <= 4<<30 { t.Fatalf("zip64 %v, directory at %d; want a Zip64 end record past 4 GiB", d.zip64, d.offset) }
+
+	writeTestZip(t, filepath.Join(in, "small.zip"), 2, 0)
+	runMerge(t, args...)
+	if d := outputDirectory(t, path); !d.zip64 || d.entries != 3 { t.Fatalf("after -append: zip64 %v, %d entries", d.zip64, d.entries) }
+	zr, err := zip.OpenReader(path)
+	if err != nil { t.Fatal(err) }
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Name != "huge.bin" { continue }
+		if f.UncompressedSize64 != uint64(huge) { t.Fatalf("huge.bin: %d bytes, want %d", f.UncompressedSize64, huge) }
+		rc, err := f.Open()
+		if err != nil { t.Fatal(err) }
+		_, err = io.Copy(io.Discard, rc) // archive/zip checks the CRC-32 at EOF
+		rc.Close()
+		if err != nil { t.Fatal(err) }
+		return
+	}
+	t.Fatal("huge.bin missing")
+}